@@ -7,8 +7,10 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"slices"
 	"syscall"
 
+	badger "github.com/ipfs/go-ds-badger2"
 	"github.com/spf13/cobra"
 	"github.com/zot/p2p-webapp/internal/bundle"
 	"github.com/zot/p2p-webapp/internal/commands"
@@ -24,8 +26,12 @@ var (
 	verbose int
 	port    int
 	dir     string
+	relay   bool
 )
 
+// relayIdentity is the stored identity of the relay peer, so its address survives restarts
+const relayIdentity = "relay"
+
 // CRC: crc-CommandRouter.md
 var rootCmd = &cobra.Command{
 	Use:   "p2p-webapp",
@@ -46,7 +52,12 @@ With --dir flag:
   Serves from the specified directory which must contain:
   - html/: website to serve (must contain index.html)
   - ipfs/: content to make available in IPFS (optional)
-  - storage/: server storage (peer keys, etc.)`,
+  - storage/: server storage (peer identities in storage/identities, etc.)
+
+Relay mode (--relay):
+  Runs headless as a circuit relay for peers behind NATs, with no browser,
+  HTTP or WebSocket server. Configure it in [p2p.relayService] and list its
+  address in staticRelays of the other instances.`,
 	RunE: runServe,
 }
 
@@ -56,6 +67,7 @@ func init() {
 	rootCmd.Flags().CountVarP(&verbose, "verbose", "v", "Verbose output (can be specified multiple times: -v, -vv, -vvv)")
 	rootCmd.Flags().IntVarP(&port, "port", "p", 0, "Port to listen on (default: auto-select starting from 10000)")
 	rootCmd.Flags().StringVar(&dir, "dir", "", "Directory to serve from (if not specified, serves from bundled site)")
+	rootCmd.Flags().BoolVar(&relay, "relay", false, "Run headless as a circuit relay for peers behind NATs (no browser or WebSocket)")
 
 	rootCmd.AddCommand(commands.ExtractCmd)
 	rootCmd.AddCommand(commands.BundleCmd)
@@ -66,6 +78,7 @@ func init() {
 	rootCmd.AddCommand(commands.KillCmd)
 	rootCmd.AddCommand(commands.KillAllCmd)
 	rootCmd.AddCommand(commands.VersionCmd)
+	rootCmd.AddCommand(commands.BlocklistCmd)
 }
 
 func runServe(cmd *cobra.Command, args []string) error {
@@ -73,13 +86,14 @@ func runServe(cmd *cobra.Command, args []string) error {
 	defer cancel()
 
 	var srv *server.Server
+	var peerManager *peer.Manager
 	var storagePath string
 	var cfg *config.Config
 	var err error
 
 	if dir != "" {
 		// Directory mode: serve from filesystem
-		if err := validateDirectoryStructure(dir); err != nil {
+		if err := validateDirectoryStructure(dir, relay); err != nil {
 			return err
 		}
 
@@ -91,6 +105,14 @@ func runServe(cmd *cobra.Command, args []string) error {
 
 		storagePath = filepath.Join(dir, "storage")
 
+		// Merge command-line flags with configuration
+		cfg.Merge(port, noOpen, linger, verbose)
+
+		// Validate configuration before anything joins the network
+		if err := cfg.Validate(); err != nil {
+			return fmt.Errorf("invalid configuration: %w", err)
+		}
+
 		// Create IPFS node
 		ipfsNode, err := ipfs.NewNode(ctx, storagePath, 0) // Random port
 		if err != nil {
@@ -110,19 +132,20 @@ func runServe(cmd *cobra.Command, args []string) error {
 
 		fmt.Printf("Peer ID: %s\n", ipfsNode.PeerID())
 
-		// Merge command-line flags with configuration
-		cfg.Merge(port, noOpen, linger, verbose)
-
-		// Validate configuration
-		if err := cfg.Validate(); err != nil {
-			return fmt.Errorf("invalid configuration: %w", err)
-		}
-
 		// Create peer manager
-		peerManager, err := peer.NewManager(ctx, ipfsNode.Host(), ipfsNode.Peer(), cfg.Behavior.Verbosity, cfg.P2P.FileUpdateNotifyTopic, cfg.P2P.IPFSGetTimeout.Duration, cfg.P2P.StreamTimeout.Duration)
+		peerManager, err = peer.NewManager(ctx, ipfsNode.Host(), ipfsNode.Peer(), cfg.Behavior.Verbosity, cfg.P2P.FileUpdateNotifyTopic, cfg.P2P.IPFSGetTimeout.Duration, cfg.P2P.StreamTimeout.Duration, pubsubSettings(cfg.P2P.Pubsub))
 		if err != nil {
 			return fmt.Errorf("failed to create peer manager: %w", err)
 		}
+		closeQueues, err := configureManager(cfg, peerManager, storagePath, relay)
+		if err != nil {
+			return err
+		}
+		defer closeQueues()
+
+		if relay {
+			return runRelay(peerManager)
+		}
 
 		// Create HTTP server from directory
 		htmlDir := filepath.Join(dir, "html")
@@ -177,10 +200,19 @@ func runServe(cmd *cobra.Command, args []string) error {
 		fmt.Printf("Peer ID: %s\n", ipfsNode.PeerID())
 
 		// Create peer manager
-		peerManager, err := peer.NewManager(ctx, ipfsNode.Host(), ipfsNode.Peer(), cfg.Behavior.Verbosity, cfg.P2P.FileUpdateNotifyTopic, cfg.P2P.IPFSGetTimeout.Duration, cfg.P2P.StreamTimeout.Duration)
+		peerManager, err = peer.NewManager(ctx, ipfsNode.Host(), ipfsNode.Peer(), cfg.Behavior.Verbosity, cfg.P2P.FileUpdateNotifyTopic, cfg.P2P.IPFSGetTimeout.Duration, cfg.P2P.StreamTimeout.Duration, pubsubSettings(cfg.P2P.Pubsub))
 		if err != nil {
 			return fmt.Errorf("failed to create peer manager: %w", err)
 		}
+		closeQueues, err := configureManager(cfg, peerManager, storagePath, relay)
+		if err != nil {
+			return err
+		}
+		defer closeQueues()
+
+		if relay {
+			return runRelay(peerManager)
+		}
 
 		// Create HTTP server from bundle
 		srv = server.NewServerFromBundle(ctx, peerManager, cfg, bundleReader)
@@ -226,17 +258,170 @@ func runServe(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
-func validateDirectoryStructure(baseDir string) error {
-	// Check html directory
-	htmlDir := filepath.Join(baseDir, "html")
-	if _, err := os.Stat(htmlDir); os.IsNotExist(err) {
-		return fmt.Errorf("html directory not found in %s", baseDir)
+// configureManager applies the peer manager settings from the configuration, opening the queue
+// store when queues persist. The returned function closes the queue store
+func configureManager(cfg *config.Config, pm *peer.Manager, storagePath string, relay bool) (func(), error) {
+	pm.SetQueueTTL(cfg.P2P.QueueTTL.Duration, cfg.P2P.QueueTTLOverrides())
+	limits := cfg.P2P.QueueLimits
+	pm.SetQueueLimits(limits.MaxMessages, limits.MaxBytes, limits.Policy)
+	if err := pm.SetTopicSchemas(cfg.P2P.TopicSchemas); err != nil {
+		return nil, fmt.Errorf("failed to load topic schemas: %w", err)
+	}
+	pm.SetTopicHistory(cfg.P2P.TopicHistory.MaxMessages, cfg.P2P.TopicHistory.MaxAge.Duration)
+	if err := pm.SetTransports(transportSettings(cfg.P2P.Transports)); err != nil {
+		return nil, fmt.Errorf("invalid transports configuration: %w", err)
+	}
+	if err := pm.SetNetwork(peer.NetworkSettings{
+		BootstrapPeers: cfg.P2P.BootstrapPeers,
+		DHTMode:        cfg.P2P.DHT,
+		LANOnly:        cfg.P2P.LANOnly,
+		StaticRelays:   cfg.P2P.StaticRelays,
+	}); err != nil {
+		return nil, fmt.Errorf("invalid network configuration: %w", err)
+	}
+	pm.SetRelayService(relaySettings(cfg.P2P.RelayService, relay))
+	if cfg.P2P.Namespace == "" {
+		fmt.Println("Warning: no [p2p] namespace set, so mDNS discovery, topics and protocols are shared with every other app")
+	}
+	if err := pm.SetNamespace(cfg.P2P.Namespace, cfg.P2P.ProtocolName); err != nil {
+		return nil, fmt.Errorf("invalid namespace: %w", err)
+	}
+	closeQueues := func() {}
+	if cfg.P2P.PersistQueues {
+		queueStore, err := openQueueStore(storagePath)
+		if err != nil {
+			return nil, err
+		}
+		closeQueues = func() { queueStore.Close() }
+		pm.EnableQueuePersistence(queueStore)
+	}
+	if err := pm.EnableKeystore(filepath.Join(storagePath, peer.KeystoreDirName)); err != nil {
+		closeQueues()
+		return nil, err
+	}
+	if err := pm.EnableBlocklist(filepath.Join(storagePath, peer.BlocklistFileName)); err != nil {
+		closeQueues()
+		return nil, err
+	}
+	return closeQueues, nil
+}
+
+// pubsubSettings converts the [p2p.pubsub] configuration for the peer manager
+func pubsubSettings(c config.PubsubConfig) peer.PubsubSettings {
+	settings := peer.PubsubSettings{
+		Router:                c.Router,
+		D:                     c.D,
+		Dlo:                   c.Dlo,
+		Dhi:                   c.Dhi,
+		Dout:                  c.Dout,
+		Dscore:                c.Dscore,
+		Dlazy:                 c.Dlazy,
+		HeartbeatInitialDelay: c.HeartbeatInitialDelay.Duration,
+		HeartbeatInterval:     c.HeartbeatInterval.Duration,
+		FloodPublish:          c.FloodPublish,
+		PeerExchange:          c.PeerExchange,
+		SignaturePolicy:       c.SignaturePolicy,
+		MaxMessageSize:        c.MaxMessageSize,
+		RandomSubSize:         c.RandomSubSize,
+	}
+	if c.Scoring.Enabled {
+		settings.Scoring = &peer.PeerScoreSettings{
+			GossipThreshold:             c.Scoring.GossipThreshold,
+			PublishThreshold:            c.Scoring.PublishThreshold,
+			GraylistThreshold:           c.Scoring.GraylistThreshold,
+			AcceptPXThreshold:           c.Scoring.AcceptPXThreshold,
+			OpportunisticGraftThreshold: c.Scoring.OpportunisticGraftThreshold,
+		}
+	}
+	return settings
+}
+
+// relaySettings converts the [p2p.relayService] configuration for the peer manager
+// Relay mode always runs the service, assuming an always-on relay is publicly reachable
+func relaySettings(c config.RelayServiceConfig, relayMode bool) peer.RelaySettings {
+	return peer.RelaySettings{
+		Enabled:              c.Enabled || relayMode,
+		ForcePublic:          c.ForcePublic || relayMode,
+		MaxReservations:      c.MaxReservations,
+		MaxCircuits:          c.MaxCircuits,
+		MaxReservationsPerIP: c.MaxReservationsPerIP,
+		ReservationTTL:       c.ReservationTTL.Duration,
+		ConnectionDuration:   c.ConnectionDuration.Duration,
+		ConnectionData:       c.ConnectionData,
+	}
+}
+
+// runRelay runs headless as a circuit relay: one peer with a stored identity and no HTTP or WebSocket server
+// CRC: crc-CommandRouter.md
+func runRelay(pm *peer.Manager) error {
+	defer pm.Shutdown()
+
+	identities, err := pm.ListIdentities()
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(identities, func(id peer.Identity) bool { return id.Name == relayIdentity }) {
+		if _, err := pm.CreateIdentity(relayIdentity, "", ""); err != nil {
+			return fmt.Errorf("failed to create relay identity: %w", err)
+		}
+	}
+	peerID, err := pm.CreatePeerFromIdentity(relayIdentity, "", "")
+	if err != nil {
+		return fmt.Errorf("failed to create relay peer: %w", err)
 	}
 
-	// Check for index.html
-	indexPath := filepath.Join(htmlDir, "index.html")
-	if _, err := os.Stat(indexPath); os.IsNotExist(err) {
-		return fmt.Errorf("html/index.html not found in %s", baseDir)
+	c, err := pm.Connections(peerID)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Relay peer ID: %s\n", peerID)
+	fmt.Println("List one of these addresses in staticRelays of the instances behind NATs:")
+	for _, addr := range c.Addrs {
+		fmt.Printf("  %s/p2p/%s\n", addr, peerID)
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	<-sigCh
+	fmt.Println("\nShutting down...")
+	return nil
+}
+
+// openQueueStore opens the datastore persisted outbound queues are kept in (storage/queues)
+// It is separate from the IPFS node's datastore, whose directory the node keeps locked
+func openQueueStore(storagePath string) (*badger.Datastore, error) {
+	ds, err := badger.NewDatastore(filepath.Join(storagePath, peer.QueueStoreDirName), &badger.DefaultOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open queue store: %w", err)
+	}
+	return ds, nil
+}
+
+// transportSettings converts the [p2p.transports] configuration for the peer manager
+func transportSettings(c config.TransportsConfig) peer.TransportSettings {
+	return peer.TransportSettings{
+		ListenAddrs: c.ListenAddrs,
+		PortRange:   c.PortRange,
+		Transports:  c.Enabled,
+		Announce:    c.Announce,
+		NoAnnounce:  c.NoAnnounce,
+	}
+}
+
+// validateDirectoryStructure checks a site directory; headless (relay) mode serves no html
+func validateDirectoryStructure(baseDir string, headless bool) error {
+	if !headless {
+		// Check html directory
+		htmlDir := filepath.Join(baseDir, "html")
+		if _, err := os.Stat(htmlDir); os.IsNotExist(err) {
+			return fmt.Errorf("html directory not found in %s", baseDir)
+		}
+
+		// Check for index.html
+		indexPath := filepath.Join(htmlDir, "index.html")
+		if _, err := os.Stat(indexPath); os.IsNotExist(err) {
+			return fmt.Errorf("html/index.html not found in %s", baseDir)
+		}
 	}
 
 	// Create ipfs directory if it doesn't exist
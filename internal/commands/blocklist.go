@@ -0,0 +1,153 @@
+// CRC: crc-CommandRouter.md, Spec: main.md
+package commands
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/zot/p2p-webapp/internal/peer"
+)
+
+var blocklistDir string
+
+// BlocklistCmd represents the blocklist command
+// CRC: crc-CommandRouter.md
+var BlocklistCmd = &cobra.Command{
+	Use:   "blocklist",
+	Short: "Manage the peer blocklist",
+	Long: `Manage the peers refused by p2p-webapp.
+The blocklist is kept in storage/blocklist.json; a running instance
+reloads it within a few seconds and disconnects newly blocked peers.
+A non-empty allow list only lets the allowed peers connect.
+
+Examples:
+  p2p-webapp blocklist list                        # blocklist of the bundled site
+  p2p-webapp blocklist block 12D3KooW... spam      # block a peer with a reason
+  p2p-webapp blocklist unblock 12D3KooW... --dir my-site`,
+}
+
+var blocklistListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List blocked and allowed peers",
+	Args:  cobra.NoArgs,
+	RunE:  runBlocklistList,
+}
+
+var blocklistBlockCmd = &cobra.Command{
+	Use:   "block PEER [REASON]",
+	Short: "Block a peer",
+	Args:  cobra.RangeArgs(1, 2),
+	RunE:  runBlocklistBlock,
+}
+
+var blocklistUnblockCmd = &cobra.Command{
+	Use:   "unblock PEER",
+	Short: "Unblock a peer",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runBlocklistUnblock,
+}
+
+var blocklistAllowCmd = &cobra.Command{
+	Use:   "allow PEER",
+	Short: "Add a peer to the allow list",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runBlocklistAllow,
+}
+
+var blocklistDisallowCmd = &cobra.Command{
+	Use:   "disallow PEER",
+	Short: "Remove a peer from the allow list",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runBlocklistDisallow,
+}
+
+func init() {
+	BlocklistCmd.PersistentFlags().StringVar(&blocklistDir, "dir", "", "Site directory (if not specified, uses the bundled site's storage in the current directory)")
+	BlocklistCmd.AddCommand(blocklistListCmd, blocklistBlockCmd, blocklistUnblockCmd, blocklistAllowCmd, blocklistDisallowCmd)
+}
+
+// blocklistPath returns the blocklist file the server uses for the same --dir
+func blocklistPath() string {
+	if blocklistDir != "" {
+		return filepath.Join(blocklistDir, "storage", peer.BlocklistFileName)
+	}
+	return filepath.Join(".p2p-webapp-storage", peer.BlocklistFileName)
+}
+
+// editBlocklist applies fn to the blocklist file and saves it, locked against a running instance's own edits
+func editBlocklist(fn func(list *peer.Blocklist) error) error {
+	return peer.EditBlocklist(blocklistPath(), fn)
+}
+
+func runBlocklistList(cmd *cobra.Command, args []string) error {
+	list, err := peer.LoadBlocklist(blocklistPath())
+	if err != nil {
+		return err
+	}
+
+	if len(list.Blocked) == 0 {
+		fmt.Println("No blocked peers")
+	} else {
+		fmt.Printf("Blocked peers (%d):\n", len(list.Blocked))
+		for _, blocked := range list.Blocked {
+			fmt.Printf("  %s\t%s\t%s\n", blocked.PeerID, blocked.BlockedAt.Local().Format(time.DateTime), blocked.Reason)
+		}
+	}
+	if len(list.Allowed) > 0 {
+		fmt.Printf("Allowed peers (%d), all others are refused:\n", len(list.Allowed))
+		for _, allowed := range list.Allowed {
+			fmt.Printf("  %s\n", allowed)
+		}
+	}
+	return nil
+}
+
+func runBlocklistBlock(cmd *cobra.Command, args []string) error {
+	reason := ""
+	if len(args) > 1 {
+		reason = args[1]
+	}
+	if err := editBlocklist(func(list *peer.Blocklist) error { return list.Block(args[0], reason) }); err != nil {
+		return err
+	}
+	fmt.Printf("Blocked %s\n", args[0])
+	return nil
+}
+
+func runBlocklistUnblock(cmd *cobra.Command, args []string) error {
+	err := editBlocklist(func(list *peer.Blocklist) error {
+		if !list.Unblock(args[0]) {
+			return fmt.Errorf("peer not blocked: %s", args[0])
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Unblocked %s\n", args[0])
+	return nil
+}
+
+func runBlocklistAllow(cmd *cobra.Command, args []string) error {
+	if err := editBlocklist(func(list *peer.Blocklist) error { return list.Allow(args[0]) }); err != nil {
+		return err
+	}
+	fmt.Printf("Allowed %s\n", args[0])
+	return nil
+}
+
+func runBlocklistDisallow(cmd *cobra.Command, args []string) error {
+	err := editBlocklist(func(list *peer.Blocklist) error {
+		if !list.Disallow(args[0]) {
+			return fmt.Errorf("peer not allowed: %s", args[0])
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Disallowed %s\n", args[0])
+	return nil
+}
@@ -53,10 +53,12 @@ type CORSConfig struct {
 
 // WebSocketConfig holds WebSocket settings
 type WebSocketConfig struct {
-	CheckOrigin     bool     `toml:"checkOrigin"`
-	AllowedOrigins  []string `toml:"allowedOrigins"`
-	ReadBufferSize  int      `toml:"readBufferSize"`
-	WriteBufferSize int      `toml:"writeBufferSize"`
+	CheckOrigin       bool     `toml:"checkOrigin"`
+	AllowedOrigins    []string `toml:"allowedOrigins"`
+	ReadBufferSize    int      `toml:"readBufferSize"`
+	WriteBufferSize   int      `toml:"writeBufferSize"`
+	ResumeGracePeriod Duration `toml:"resumeGracePeriod"` // How long a disconnected peer waits to be resumed (0 = removed at once)
+	ResumeBufferSize  int      `toml:"resumeBufferSize"`  // Server messages kept for a disconnected peer (oldest dropped first)
 }
 
 // BehaviorConfig holds application behavior settings
@@ -75,10 +77,98 @@ type FilesConfig struct {
 
 // P2PConfig holds P2P protocol settings
 type P2PConfig struct {
-	ProtocolName            string   `toml:"protocolName"`
-	FileUpdateNotifyTopic   string   `toml:"fileUpdateNotifyTopic"`
-	IPFSGetTimeout          Duration `toml:"ipfsGetTimeout"`
-	StreamTimeout           Duration `toml:"streamTimeout"`
+	ProtocolName          string              `toml:"protocolName"` // Overrides the file protocol /<namespace>/p2p-webapp/1.0.0
+	Namespace             string              `toml:"namespace"`    // Isolates mDNS, topics and protocols (empty = shared with every app)
+	FileUpdateNotifyTopic string              `toml:"fileUpdateNotifyTopic"`
+	IPFSGetTimeout        Duration            `toml:"ipfsGetTimeout"`
+	StreamTimeout         Duration            `toml:"streamTimeout"`
+	PersistQueues         bool                `toml:"persistQueues"` // Store outbound queues in storage/queues so they survive restarts
+	QueueTTL              Duration            `toml:"queueTTL"`      // How long undelivered messages are kept (0 = forever)
+	QueueTTLs             map[string]Duration `toml:"queueTTLs"`     // Per-protocol queueTTL overrides
+	QueueLimits           QueueLimitsConfig   `toml:"queueLimits"`
+	TopicSchemas          map[string]string   `toml:"topicSchemas"` // JSON Schemas for setTopicValidator, by name
+	TopicHistory          TopicHistoryConfig  `toml:"topicHistory"`
+	Pubsub                PubsubConfig        `toml:"pubsub"`
+	Transports            TransportsConfig    `toml:"transports"`
+	BootstrapPeers        []string            `toml:"bootstrapPeers"` // Multiaddrs ending in /p2p/<id> (empty = public IPFS bootstrap nodes)
+	DHT                   string              `toml:"dht"`            // "off", "client", "server" or "auto"
+	LANOnly               bool                `toml:"lanOnly"`        // Only mDNS and local peers: no DHT, bootstrap, relays or NAT traversal
+	StaticRelays          []string            `toml:"staticRelays"`   // Relays AutoRelay reserves slots on, as multiaddrs ending in /p2p/<id>
+	RelayService          RelayServiceConfig  `toml:"relayService"`
+}
+
+// RelayServiceConfig runs a circuit relay v2 service on every peer's host for peers behind NATs
+// Zero limits use the libp2p defaults
+type RelayServiceConfig struct {
+	Enabled              bool     `toml:"enabled"`
+	ForcePublic          bool     `toml:"forcePublic"`          // Serve without waiting for AutoNAT to report the host publicly reachable
+	MaxReservations      int      `toml:"maxReservations"`      // Peers holding a relay slot at once
+	MaxCircuits          int      `toml:"maxCircuits"`          // Open relayed connections per peer
+	MaxReservationsPerIP int      `toml:"maxReservationsPerIP"` // Relay slots per IP address
+	ReservationTTL       Duration `toml:"reservationTTL"`       // How long a slot lasts before the peer must refresh it
+	ConnectionDuration   Duration `toml:"connectionDuration"`   // How long a relayed connection lasts before it is reset
+	ConnectionData       int64    `toml:"connectionData"`       // Bytes relayed in each direction before the connection is reset
+}
+
+// TransportsConfig selects the transports and addresses of every peer's libp2p host
+type TransportsConfig struct {
+	ListenAddrs []string `toml:"listenAddrs"` // Multiaddrs to listen on; port 0 picks a random port
+	PortRange   int      `toml:"portRange"`   // Peers from fixed ports: peer n listens on port+n (0 or 1 = a single peer)
+	Enabled     []string `toml:"enabled"`     // "tcp", "quic-v1", "webtransport", "websocket" (empty = all libp2p defaults)
+	Announce    []string `toml:"announce"`    // Addresses announced instead of the listen addresses
+	NoAnnounce  []string `toml:"noAnnounce"`  // Addresses or /ipcidr ranges never announced
+}
+
+// PubsubConfig selects and tunes the pubsub router of every peer
+// Zero mesh parameters and heartbeats use the pubsub library defaults
+type PubsubConfig struct {
+	Router                string        `toml:"router"` // "gossipsub", "floodsub" or "randomsub"
+	D                     int           `toml:"d"`      // GossipSub mesh parameters
+	Dlo                   int           `toml:"dlo"`
+	Dhi                   int           `toml:"dhi"`
+	Dout                  int           `toml:"dout"`
+	Dscore                int           `toml:"dscore"`
+	Dlazy                 int           `toml:"dlazy"`
+	HeartbeatInitialDelay Duration      `toml:"heartbeatInitialDelay"`
+	HeartbeatInterval     Duration      `toml:"heartbeatInterval"`
+	FloodPublish          bool          `toml:"floodPublish"`
+	PeerExchange          bool          `toml:"peerExchange"`
+	SignaturePolicy       string        `toml:"signaturePolicy"` // "strictSign" or "strictNoSign"
+	MaxMessageSize        int           `toml:"maxMessageSize"`  // Bytes (0 = 1 MiB)
+	RandomSubSize         int           `toml:"randomSubSize"`   // Expected network size for randomsub
+	Scoring               ScoringConfig `toml:"scoring"`
+}
+
+// ScoringConfig holds the gossipsub peer score thresholds
+type ScoringConfig struct {
+	Enabled                     bool    `toml:"enabled"`
+	GossipThreshold             float64 `toml:"gossipThreshold"`
+	PublishThreshold            float64 `toml:"publishThreshold"`
+	GraylistThreshold           float64 `toml:"graylistThreshold"`
+	AcceptPXThreshold           float64 `toml:"acceptPXThreshold"`
+	OpportunisticGraftThreshold float64 `toml:"opportunisticGraftThreshold"`
+}
+
+// TopicHistoryConfig bounds the recent messages each peer keeps per subscribed topic for replay
+type TopicHistoryConfig struct {
+	MaxMessages int      `toml:"maxMessages"` // Messages kept per topic (0 = history disabled)
+	MaxAge      Duration `toml:"maxAge"`      // How long messages are kept (0 = until pushed out)
+}
+
+// QueueLimitsConfig bounds each outbound (peer, protocol) message queue
+type QueueLimitsConfig struct {
+	MaxMessages int    `toml:"maxMessages"` // Messages per queue (0 = unlimited)
+	MaxBytes    int64  `toml:"maxBytes"`    // Payload bytes per queue (0 = unlimited)
+	Policy      string `toml:"policy"`      // "reject", "dropOldest" or "block" when a queue is full
+}
+
+// QueueTTLOverrides returns the per-protocol queue TTLs as time.Durations
+func (c P2PConfig) QueueTTLOverrides() map[string]time.Duration {
+	ttls := make(map[string]time.Duration, len(c.QueueTTLs))
+	for protocol, ttl := range c.QueueTTLs {
+		ttls[protocol] = ttl.Duration
+	}
+	return ttls
 }
 
 // Duration wraps time.Duration for TOML parsing
@@ -33,10 +33,12 @@ func DefaultConfig() *Config {
 			},
 		},
 		WebSocket: WebSocketConfig{
-			CheckOrigin:     false, // Allow all origins by default
-			AllowedOrigins:  []string{},
-			ReadBufferSize:  1024,
-			WriteBufferSize: 1024,
+			CheckOrigin:       false, // Allow all origins by default
+			AllowedOrigins:    []string{},
+			ReadBufferSize:    1024,
+			WriteBufferSize:   1024,
+			ResumeGracePeriod: Duration{30 * time.Second},
+			ResumeBufferSize:  1000,
 		},
 		Behavior: BehaviorConfig{
 			AutoExitTimeout: Duration{5 * time.Second},
@@ -51,6 +53,43 @@ func DefaultConfig() *Config {
 		P2P: P2PConfig{
 			IPFSGetTimeout: Duration{3 * time.Second},
 			StreamTimeout:  Duration{30 * time.Second},
+			PersistQueues:  false,
+			QueueTTL:       Duration{24 * time.Hour},
+			QueueLimits: QueueLimitsConfig{
+				MaxMessages: 1000,
+				MaxBytes:    16 * 1024 * 1024,
+				Policy:      "reject",
+			},
+			TopicHistory: TopicHistoryConfig{
+				MaxMessages: 100,
+				MaxAge:      Duration{time.Hour},
+			},
+			Pubsub: PubsubConfig{
+				Router:                "gossipsub",
+				D:                     6,
+				Dlo:                   5,
+				Dhi:                   12,
+				Dout:                  2,
+				Dscore:                4,
+				Dlazy:                 6,
+				HeartbeatInitialDelay: Duration{50 * time.Millisecond},
+				HeartbeatInterval:     Duration{500 * time.Millisecond},
+				FloodPublish:          true,
+				PeerExchange:          true,
+				SignaturePolicy:       "strictSign",
+				MaxMessageSize:        1 << 20,
+				RandomSubSize:         6,
+				Scoring: ScoringConfig{
+					Enabled:           false,
+					GossipThreshold:   -100,
+					PublishThreshold:  -500,
+					GraylistThreshold: -1000,
+				},
+			},
+			Transports: TransportsConfig{
+				ListenAddrs: []string{"/ip4/0.0.0.0/tcp/0"},
+			},
+			DHT: "auto",
 		},
 	}
 }
@@ -3,16 +3,21 @@ package config
 
 import (
 	"archive/zip"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"regexp"
 
 	"github.com/BurntSushi/toml"
 )
 
 const ConfigFileName = "p2p-webapp.toml"
 
+// namespacePattern matches valid app namespaces
+var namespacePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
 // LoadFromDir loads configuration from a filesystem directory
 // Returns default config if file doesn't exist
 // CRC: crc-ConfigLoader.md
@@ -120,6 +125,85 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("invalid write timeout: %v (must be positive)", c.Server.Timeouts.Write)
 	}
 
+	// Validate session resumption
+	if c.WebSocket.ResumeGracePeriod.Duration < 0 {
+		return fmt.Errorf("invalid resume grace period: %v (must be positive)", c.WebSocket.ResumeGracePeriod)
+	}
+	if c.WebSocket.ResumeBufferSize < 0 {
+		return fmt.Errorf("invalid resume buffer size: %d (must be >= 0)", c.WebSocket.ResumeBufferSize)
+	}
+
+	// Validate queue TTLs
+	if c.P2P.QueueTTL.Duration < 0 {
+		return fmt.Errorf("invalid queue TTL: %v (must be positive)", c.P2P.QueueTTL)
+	}
+	for protocol, ttl := range c.P2P.QueueTTLs {
+		if ttl.Duration < 0 {
+			return fmt.Errorf("invalid queue TTL for protocol %s: %v (must be positive)", protocol, ttl)
+		}
+	}
+
+	// Validate queue limits
+	if c.P2P.QueueLimits.MaxMessages < 0 {
+		return fmt.Errorf("invalid queue max messages: %d (must be >= 0)", c.P2P.QueueLimits.MaxMessages)
+	}
+	if c.P2P.QueueLimits.MaxBytes < 0 {
+		return fmt.Errorf("invalid queue max bytes: %d (must be >= 0)", c.P2P.QueueLimits.MaxBytes)
+	}
+	switch c.P2P.QueueLimits.Policy {
+	case "reject", "dropOldest", "block":
+	default:
+		return fmt.Errorf("invalid queue policy: %q (must be reject, dropOldest or block)", c.P2P.QueueLimits.Policy)
+	}
+
+	// Validate topic schemas (full compilation happens in the peer manager)
+	for name, schema := range c.P2P.TopicSchemas {
+		if !json.Valid([]byte(schema)) {
+			return fmt.Errorf("invalid topic schema %s: not valid JSON", name)
+		}
+	}
+
+	// Validate topic history
+	if c.P2P.TopicHistory.MaxMessages < 0 {
+		return fmt.Errorf("invalid topic history max messages: %d (must be >= 0)", c.P2P.TopicHistory.MaxMessages)
+	}
+	if c.P2P.TopicHistory.MaxAge.Duration < 0 {
+		return fmt.Errorf("invalid topic history max age: %v (must be positive)", c.P2P.TopicHistory.MaxAge)
+	}
+
+	// Validate pubsub settings
+	if err := c.P2P.Pubsub.validate(); err != nil {
+		return err
+	}
+
+	// Validate transports (addresses are parsed by the peer manager)
+	if err := c.P2P.Transports.validate(); err != nil {
+		return err
+	}
+
+	// Validate peer discovery (bootstrap addresses are parsed by the peer manager)
+	switch c.P2P.DHT {
+	case "off", "client", "server", "auto":
+	default:
+		return fmt.Errorf("invalid dht mode: %q (must be off, client, server or auto)", c.P2P.DHT)
+	}
+	if c.P2P.LANOnly && (len(c.P2P.BootstrapPeers) > 0 || c.P2P.DHT == "client" || c.P2P.DHT == "server") {
+		return fmt.Errorf("lanOnly can't be combined with bootstrapPeers or a dht mode (the DHT is off)")
+	}
+	if c.P2P.LANOnly && len(c.P2P.StaticRelays) > 0 {
+		return fmt.Errorf("lanOnly can't be combined with staticRelays (LAN-only peers use no relays)")
+	}
+
+	// Validate relay service limits (0 = libp2p default)
+	if err := c.P2P.RelayService.validate(); err != nil {
+		return err
+	}
+
+	// Validate app namespace (used in mDNS service names, so it must be a DNS label)
+	if ns := c.P2P.Namespace; ns != "" && (len(ns) > 40 || !namespacePattern.MatchString(ns)) {
+		return fmt.Errorf("invalid namespace: %q (up to 40 lowercase letters, digits and dashes)", ns)
+	}
+
 	// Validate index file
 	if c.Files.IndexFile == "" {
 		return fmt.Errorf("index file cannot be empty")
@@ -127,3 +211,82 @@ func (c *Config) Validate() error {
 
 	return nil
 }
+
+// validate checks pubsub settings against the rules the pubsub library enforces
+func (p PubsubConfig) validate() error {
+	switch p.Router {
+	case "gossipsub", "floodsub", "randomsub":
+	default:
+		return fmt.Errorf("invalid pubsub router: %q (must be gossipsub, floodsub or randomsub)", p.Router)
+	}
+	switch p.SignaturePolicy {
+	case "strictSign", "strictNoSign":
+	default:
+		return fmt.Errorf("invalid pubsub signature policy: %q (must be strictSign or strictNoSign)", p.SignaturePolicy)
+	}
+
+	for name, value := range map[string]int{
+		"d": p.D, "dlo": p.Dlo, "dhi": p.Dhi, "dout": p.Dout, "dscore": p.Dscore, "dlazy": p.Dlazy,
+		"maxMessageSize": p.MaxMessageSize, "randomSubSize": p.RandomSubSize,
+	} {
+		if value < 0 {
+			return fmt.Errorf("invalid pubsub %s: %d (must be >= 0)", name, value)
+		}
+	}
+	if p.HeartbeatInitialDelay.Duration < 0 || p.HeartbeatInterval.Duration < 0 {
+		return fmt.Errorf("invalid pubsub heartbeat: %v, %v (must be positive)", p.HeartbeatInitialDelay, p.HeartbeatInterval)
+	}
+
+	// Mesh parameters (0 = library default, so only set values are compared)
+	if p.D > 0 && p.Dlo > 0 && p.Dhi > 0 && !(p.Dlo <= p.D && p.D <= p.Dhi) {
+		return fmt.Errorf("invalid pubsub mesh: d=%d must be between dlo=%d and dhi=%d", p.D, p.Dlo, p.Dhi)
+	}
+	if p.Dscore > 0 && p.Dhi > 0 && p.Dscore > p.Dhi {
+		return fmt.Errorf("invalid pubsub mesh: dscore=%d must be <= dhi=%d", p.Dscore, p.Dhi)
+	}
+	if p.Dout > 0 && p.D > 0 && p.Dlo > 0 && !(p.Dout < p.Dlo && p.Dout < p.D/2) {
+		return fmt.Errorf("invalid pubsub mesh: dout=%d must be less than dlo=%d and d/2=%d", p.Dout, p.Dlo, p.D/2)
+	}
+
+	if s := p.Scoring; s.Enabled {
+		if s.GossipThreshold > 0 || s.PublishThreshold > s.GossipThreshold || s.GraylistThreshold > s.PublishThreshold {
+			return fmt.Errorf("invalid pubsub scoring: thresholds must satisfy graylist <= publish <= gossip <= 0")
+		}
+		if s.AcceptPXThreshold < 0 || s.OpportunisticGraftThreshold < 0 {
+			return fmt.Errorf("invalid pubsub scoring: acceptPX and opportunisticGraft thresholds must be >= 0")
+		}
+	}
+
+	return nil
+}
+
+// validate checks that relay service limits aren't negative
+func (r RelayServiceConfig) validate() error {
+	for name, value := range map[string]int64{
+		"maxReservations": int64(r.MaxReservations), "maxCircuits": int64(r.MaxCircuits),
+		"maxReservationsPerIP": int64(r.MaxReservationsPerIP), "connectionData": r.ConnectionData,
+	} {
+		if value < 0 {
+			return fmt.Errorf("invalid relayService %s: %d (must be >= 0)", name, value)
+		}
+	}
+	if r.ReservationTTL.Duration < 0 || r.ConnectionDuration.Duration < 0 {
+		return fmt.Errorf("invalid relayService durations: %v, %v (must be positive)", r.ReservationTTL, r.ConnectionDuration)
+	}
+	return nil
+}
+
+// validate checks transport names and the port range
+func (t TransportsConfig) validate() error {
+	if t.PortRange < 0 {
+		return fmt.Errorf("invalid transports port range: %d (must be >= 0)", t.PortRange)
+	}
+	for _, name := range t.Enabled {
+		switch name {
+		case "tcp", "quic-v1", "webtransport", "websocket":
+		default:
+			return fmt.Errorf("invalid transport: %q (must be tcp, quic-v1, webtransport or websocket)", name)
+		}
+	}
+	return nil
+}
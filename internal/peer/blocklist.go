@@ -0,0 +1,454 @@
+package peer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// BlocklistFileName is the blocklist file in a site's storage directory
+const BlocklistFileName = "blocklist.json"
+
+// blocklistReloadInterval is how often a running instance checks the blocklist file for edits,
+// so the blocklist command takes effect without a restart
+const blocklistReloadInterval = 2 * time.Second
+
+// BlockedPeer is a blocked peer and why it was blocked
+type BlockedPeer struct {
+	PeerID    string    `json:"peerId"`
+	Reason    string    `json:"reason,omitempty"`
+	BlockedAt time.Time `json:"blockedAt"`
+}
+
+// Blocklist is the stored form of the peer deny and allow lists
+// A non-empty Allowed list only lets those peers connect; Blocked peers are always refused
+type Blocklist struct {
+	Blocked []BlockedPeer `json:"blocked"`
+	Allowed []string      `json:"allowed"`
+}
+
+// LoadBlocklist reads a blocklist file; a missing file is an empty blocklist
+func LoadBlocklist(path string) (*Blocklist, error) {
+	list, _, err := readBlocklist(path)
+	return list, err
+}
+
+// readBlocklist reads a blocklist file and also returns its contents (nil for a missing file)
+func readBlocklist(path string) (*Blocklist, []byte, error) {
+	list := &Blocklist{Blocked: []BlockedPeer{}, Allowed: []string{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return list, nil, nil
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("failed to read blocklist: %w", err)
+	}
+	if err := json.Unmarshal(data, list); err != nil {
+		return nil, nil, fmt.Errorf("invalid blocklist file %s: %w", path, err)
+	}
+	return list, data, nil
+}
+
+// Save writes the blocklist to path, replacing the file atomically so a running instance never reads half of it
+// Use EditBlocklist to change a file another process may be editing
+func (b *Blocklist) Save(path string) error {
+	_, err := b.save(path)
+	return err
+}
+
+// save writes the blocklist to path and returns the written contents
+func (b *Blocklist) save(path string) ([]byte, error) {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal blocklist: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create blocklist directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write blocklist: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("failed to write blocklist: %w", err)
+	}
+	return data, nil
+}
+
+// EditBlocklist applies fn to the blocklist file at path and saves it
+// The file's lock is held meanwhile, so a running instance and the blocklist command never lose each other's edits
+func EditBlocklist(path string, fn func(list *Blocklist) error) error {
+	_, _, err := editBlocklist(path, fn)
+	return err
+}
+
+// editBlocklist is EditBlocklist, returning the saved blocklist and file contents
+func editBlocklist(path string, fn func(list *Blocklist) error) (*Blocklist, []byte, error) {
+	unlock, err := lockBlocklist(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer unlock()
+
+	list, _, err := readBlocklist(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := fn(list); err != nil {
+		return nil, nil, err
+	}
+	data, err := list.save(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return list, data, nil
+}
+
+// lockBlocklist waits for the lock file next to a blocklist file and returns a function releasing it
+// The blocklist file itself is replaced on every save, so it can't hold the lock
+func lockBlocklist(path string) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create blocklist directory: %w", err)
+	}
+	file, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blocklist lock: %w", err)
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return func() {
+		unlockFile(file)
+		file.Close()
+	}, nil
+}
+
+// Block adds a peer to the deny list, replacing its reason if it is already there
+func (b *Blocklist) Block(peerID, reason string) error {
+	if _, err := peer.Decode(peerID); err != nil {
+		return fmt.Errorf("invalid peer ID %s: %w", peerID, err)
+	}
+	entry := BlockedPeer{PeerID: peerID, Reason: reason, BlockedAt: time.Now().UTC()}
+	for i, blocked := range b.Blocked {
+		if blocked.PeerID == peerID {
+			b.Blocked[i] = entry
+			return nil
+		}
+	}
+	b.Blocked = append(b.Blocked, entry)
+	return nil
+}
+
+// Unblock removes a peer from the deny list and reports whether it was there
+func (b *Blocklist) Unblock(peerID string) bool {
+	for i, blocked := range b.Blocked {
+		if blocked.PeerID == peerID {
+			b.Blocked = append(b.Blocked[:i], b.Blocked[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Allow adds a peer to the allow list
+func (b *Blocklist) Allow(peerID string) error {
+	if _, err := peer.Decode(peerID); err != nil {
+		return fmt.Errorf("invalid peer ID %s: %w", peerID, err)
+	}
+	for _, allowed := range b.Allowed {
+		if allowed == peerID {
+			return nil
+		}
+	}
+	b.Allowed = append(b.Allowed, peerID)
+	sort.Strings(b.Allowed)
+	return nil
+}
+
+// Disallow removes a peer from the allow list and reports whether it was there
+func (b *Blocklist) Disallow(peerID string) bool {
+	for i, allowed := range b.Allowed {
+		if allowed == peerID {
+			b.Allowed = append(b.Allowed[:i], b.Allowed[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// blocklist enforces a blocklist file in the connection gater and pubsub validators
+// The server's own peers are never denied, so they still connect to each other in allow-only mode
+type blocklist struct {
+	path    string
+	mu      sync.RWMutex
+	list    *Blocklist
+	blocked map[peer.ID]bool
+	allowed map[peer.ID]bool
+	local   map[peer.ID]bool // The server's own peers
+	data    []byte           // Contents of the file when it was last loaded or saved (nil = no file)
+}
+
+// newBlocklist loads the blocklist file at path
+func newBlocklist(path string) (*blocklist, error) {
+	bl := &blocklist{path: path, local: make(map[peer.ID]bool)}
+	if err := bl.load(); err != nil {
+		return nil, err
+	}
+	return bl, nil
+}
+
+// load reads the file and replaces the enforced lists
+func (bl *blocklist) load() error {
+	list, data, err := readBlocklist(bl.path)
+	if err != nil {
+		return err
+	}
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	bl.set(list, data)
+	return nil
+}
+
+// set replaces the enforced lists; invalid peer IDs in a hand-edited file are skipped
+// Caller must hold bl.mu lock
+func (bl *blocklist) set(list *Blocklist, data []byte) {
+	bl.list = list
+	bl.data = data
+	bl.blocked = make(map[peer.ID]bool, len(list.Blocked))
+	for _, blocked := range list.Blocked {
+		if pid, err := peer.Decode(blocked.PeerID); err == nil {
+			bl.blocked[pid] = true
+		}
+	}
+	bl.allowed = make(map[peer.ID]bool, len(list.Allowed))
+	for _, allowed := range list.Allowed {
+		if pid, err := peer.Decode(allowed); err == nil {
+			bl.allowed[pid] = true
+		}
+	}
+}
+
+// changed reports whether the file's contents differ from when it was last loaded or saved
+// Contents are compared rather than modification times, which can miss edits made within the same tick
+func (bl *blocklist) changed() bool {
+	data, err := os.ReadFile(bl.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false
+	}
+	bl.mu.RLock()
+	defer bl.mu.RUnlock()
+	return !bytes.Equal(data, bl.data)
+}
+
+// update applies fn to the current file contents and saves them with EditBlocklist's lock,
+// keeping edits made by the blocklist command since the last reload
+func (bl *blocklist) update(fn func(list *Blocklist) error) error {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	list, data, err := editBlocklist(bl.path, fn)
+	if err != nil {
+		return err
+	}
+	bl.set(list, data)
+	return nil
+}
+
+// snapshot returns a copy of the enforced lists
+func (bl *blocklist) snapshot() Blocklist {
+	bl.mu.RLock()
+	defer bl.mu.RUnlock()
+	return Blocklist{
+		Blocked: append([]BlockedPeer{}, bl.list.Blocked...),
+		Allowed: append([]string{}, bl.list.Allowed...),
+	}
+}
+
+// setLocal marks one of the server's own peers, which is never denied
+func (bl *blocklist) setLocal(pid peer.ID, local bool) {
+	if bl == nil {
+		return
+	}
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	if local {
+		bl.local[pid] = true
+	} else {
+		delete(bl.local, pid)
+	}
+}
+
+// isLocal reports whether pid is one of the server's own peers
+func (bl *blocklist) isLocal(pid peer.ID) bool {
+	bl.mu.RLock()
+	defer bl.mu.RUnlock()
+	return bl.local[pid]
+}
+
+// denies reports whether connections and messages from pid are refused
+// A nil blocklist denies nothing
+func (bl *blocklist) denies(pid peer.ID) bool {
+	if bl == nil {
+		return false
+	}
+	bl.mu.RLock()
+	defer bl.mu.RUnlock()
+	if bl.local[pid] {
+		return false
+	}
+	return bl.blocked[pid] || (len(bl.allowed) > 0 && !bl.allowed[pid])
+}
+
+// validate is the default pubsub validator of every topic
+// Messages forwarded by or authored by a denied peer are ignored, so they are neither delivered nor propagated
+func (bl *blocklist) validate(ctx context.Context, from peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+	if bl.denies(from) {
+		return pubsub.ValidationIgnore
+	}
+	if author := msg.GetFrom(); author != "" && bl.denies(author) {
+		return pubsub.ValidationIgnore
+	}
+	return pubsub.ValidationAccept
+}
+
+// peerGater is the ConnectionGater of every peer host
+// It allows private/local addresses and refuses peers the blocklist denies
+type peerGater struct {
+	bl *blocklist
+}
+
+// Ensure peerGater implements connmgr.ConnectionGater
+var _ connmgr.ConnectionGater = (*peerGater)(nil)
+
+func (g *peerGater) InterceptPeerDial(p peer.ID) (allow bool) {
+	return !g.bl.denies(p)
+}
+
+func (g *peerGater) InterceptAddrDial(p peer.ID, m multiaddr.Multiaddr) (allow bool) {
+	return !g.bl.denies(p)
+}
+
+func (g *peerGater) InterceptAccept(n network.ConnMultiaddrs) (allow bool) {
+	return true // The remote peer isn't known until the connection is secured
+}
+
+func (g *peerGater) InterceptSecured(dir network.Direction, p peer.ID, n network.ConnMultiaddrs) (allow bool) {
+	return !g.bl.denies(p)
+}
+
+func (g *peerGater) InterceptUpgraded(c network.Conn) (allow bool, reason control.DisconnectReason) {
+	return true, 0
+}
+
+// EnableBlocklist refuses connections and topic messages from peers in the blocklist file at path
+// (storage/blocklist.json), reloading it when it changes
+// Must be called before peers are created
+func (m *Manager) EnableBlocklist(path string) error {
+	bl, err := newBlocklist(path)
+	if err != nil {
+		return err
+	}
+	m.blocklist = bl
+	go m.reloadBlocklistLoop()
+	return nil
+}
+
+// reloadBlocklistLoop picks up edits to the blocklist file until the manager's context ends
+func (m *Manager) reloadBlocklistLoop() {
+	ticker := time.NewTicker(blocklistReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+			if !m.blocklist.changed() {
+				continue
+			}
+			if err := m.blocklist.load(); err != nil {
+				if m.verbosity >= 1 {
+					fmt.Printf("Failed to reload blocklist: %v\n", err)
+				}
+				continue
+			}
+			m.disconnectDenied()
+		}
+	}
+}
+
+// disconnectDenied closes every peer host's connections to peers the blocklist now denies
+func (m *Manager) disconnectDenied() {
+	m.mu.RLock()
+	peers := make([]*Peer, 0, len(m.peers))
+	for _, p := range m.peers {
+		peers = append(peers, p)
+	}
+	m.mu.RUnlock()
+
+	for _, p := range peers {
+		for _, remote := range p.host.Network().Peers() {
+			if m.blocklist.denies(remote) {
+				p.logVerbose(1, "Disconnecting blocked peer %s", remote)
+				p.host.Network().ClosePeer(remote)
+			}
+		}
+	}
+}
+
+// BlockPeer adds a peer to the blocklist and disconnects it from every peer
+// CRC: crc-PeerManager.md
+func (m *Manager) BlockPeer(peerID, reason string) error {
+	if m.blocklist == nil {
+		return fmt.Errorf("blocklist not enabled")
+	}
+	pid, err := peer.Decode(peerID)
+	if err != nil {
+		return fmt.Errorf("invalid peer ID %s: %w", peerID, err)
+	}
+	if m.blocklist.isLocal(pid) {
+		return fmt.Errorf("cannot block a peer of this server: %s", peerID)
+	}
+	if err := m.blocklist.update(func(list *Blocklist) error { return list.Block(peerID, reason) }); err != nil {
+		return err
+	}
+	m.disconnectDenied()
+	return nil
+}
+
+// UnblockPeer removes a peer from the blocklist
+// CRC: crc-PeerManager.md
+func (m *Manager) UnblockPeer(peerID string) error {
+	if m.blocklist == nil {
+		return fmt.Errorf("blocklist not enabled")
+	}
+	return m.blocklist.update(func(list *Blocklist) error {
+		if !list.Unblock(peerID) {
+			return fmt.Errorf("peer not blocked: %s", peerID)
+		}
+		return nil
+	})
+}
+
+// ListBlocked returns the blocked and allowed peers
+// CRC: crc-PeerManager.md
+func (m *Manager) ListBlocked() (Blocklist, error) {
+	if m.blocklist == nil {
+		return Blocklist{}, fmt.Errorf("blocklist not enabled")
+	}
+	return m.blocklist.snapshot(), nil
+}
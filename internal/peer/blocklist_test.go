@@ -0,0 +1,169 @@
+package peer
+
+import (
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// newBlocklistTestPeerID generates a random peer ID
+func newBlocklistTestPeerID(t *testing.T) peer.ID {
+	priv, _, err := crypto.GenerateKeyPairWithReader(crypto.Ed25519, 2048, rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	pid, _ := peer.IDFromPrivateKey(priv)
+	return pid
+}
+
+// TestBlocklistFile tests editing, saving and reloading the blocklist file
+func TestBlocklistFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage", BlocklistFileName)
+	spammer, friend := newBlocklistTestPeerID(t), newBlocklistTestPeerID(t)
+
+	list, err := LoadBlocklist(path)
+	if err != nil || len(list.Blocked) != 0 || len(list.Allowed) != 0 {
+		t.Fatalf("Expected a missing file to be an empty blocklist, got %+v (%v)", list, err)
+	}
+	if err := list.Block("not-a-peer", ""); err == nil {
+		t.Error("Expected an invalid peer ID to be rejected")
+	}
+	list.Block(spammer.String(), "spam")
+	list.Block(spammer.String(), "more spam")
+	list.Allow(friend.String())
+	if err := list.Save(path); err != nil {
+		t.Fatalf("Failed to save blocklist: %v", err)
+	}
+
+	bl, err := newBlocklist(path)
+	if err != nil {
+		t.Fatalf("Failed to load blocklist: %v", err)
+	}
+	snapshot := bl.snapshot()
+	if len(snapshot.Blocked) != 1 || snapshot.Blocked[0].Reason != "more spam" || len(snapshot.Allowed) != 1 {
+		t.Errorf("Expected one blocked and one allowed peer, got %+v", snapshot)
+	}
+	if bl.changed() {
+		t.Error("Expected no change right after loading")
+	}
+
+	// Edits through the running instance keep edits made to the file since it was loaded
+	other := newBlocklistTestPeerID(t)
+	list.Disallow(friend.String())
+	list.Save(path)
+	if err := bl.update(func(list *Blocklist) error { return list.Block(other.String(), "") }); err != nil {
+		t.Fatalf("Failed to update blocklist: %v", err)
+	}
+	if snapshot := bl.snapshot(); len(snapshot.Blocked) != 2 || len(snapshot.Allowed) != 0 {
+		t.Errorf("Expected two blocked peers and no allow list, got %+v", snapshot)
+	}
+	if err := bl.update(func(list *Blocklist) error {
+		if !list.Unblock(spammer.String()) || list.Unblock(spammer.String()) {
+			t.Error("Expected the spammer to be unblocked once")
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("Failed to update blocklist: %v", err)
+	}
+	if !bl.denies(other) || bl.denies(spammer) {
+		t.Error("Expected only the other peer to stay blocked")
+	}
+
+	// An edit is noticed even when it leaves the file's modification time unchanged
+	info, _ := os.Stat(path)
+	EditBlocklist(path, func(list *Blocklist) error { return list.Block(spammer.String(), "") })
+	os.Chtimes(path, info.ModTime(), info.ModTime())
+	if !bl.changed() {
+		t.Error("Expected an edit with the same modification time to be noticed")
+	}
+	if err := bl.load(); err != nil || bl.changed() {
+		t.Errorf("Expected no change after reloading (%v)", err)
+	}
+}
+
+// TestBlocklistConcurrentEdits tests that the running instance and the blocklist command don't lose each other's edits
+func TestBlocklistConcurrentEdits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), BlocklistFileName)
+	bl, err := newBlocklist(path)
+	if err != nil {
+		t.Fatalf("Failed to load blocklist: %v", err)
+	}
+
+	const edits = 20
+	peers := make([]peer.ID, 2*edits)
+	for i := range peers {
+		peers[i] = newBlocklistTestPeerID(t)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < edits; i++ {
+		wg.Add(2)
+		go func(pid peer.ID) {
+			defer wg.Done()
+			if err := bl.update(func(list *Blocklist) error { return list.Block(pid.String(), "instance") }); err != nil {
+				t.Errorf("Failed to update blocklist: %v", err)
+			}
+		}(peers[i])
+		go func(pid peer.ID) {
+			defer wg.Done()
+			if err := EditBlocklist(path, func(list *Blocklist) error { return list.Block(pid.String(), "command") }); err != nil {
+				t.Errorf("Failed to edit blocklist: %v", err)
+			}
+		}(peers[edits+i])
+	}
+	wg.Wait()
+
+	list, err := LoadBlocklist(path)
+	if err != nil || len(list.Blocked) != len(peers) {
+		t.Errorf("Expected all %d edits to be kept, got %d blocked peers (%v)", len(peers), len(list.Blocked), err)
+	}
+}
+
+// TestBlocklistDenies tests deny and allow-only modes, the local peer exemption and the topic validator
+func TestBlocklistDenies(t *testing.T) {
+	path := filepath.Join(t.TempDir(), BlocklistFileName)
+	spammer, friend, stranger, local := newBlocklistTestPeerID(t), newBlocklistTestPeerID(t), newBlocklistTestPeerID(t), newBlocklistTestPeerID(t)
+
+	var disabled *blocklist
+	if disabled.denies(spammer) {
+		t.Error("Expected a disabled blocklist to deny nothing")
+	}
+
+	bl, err := newBlocklist(path)
+	if err != nil {
+		t.Fatalf("Failed to load blocklist: %v", err)
+	}
+	bl.setLocal(local, true)
+	bl.update(func(list *Blocklist) error { return list.Block(spammer.String(), "") })
+	if !bl.denies(spammer) || bl.denies(stranger) || bl.denies(local) {
+		t.Error("Expected only the spammer to be denied")
+	}
+
+	// A non-empty allow list refuses everyone else, except the server's own peers
+	bl.update(func(list *Blocklist) error { return list.Allow(friend.String()) })
+	if bl.denies(friend) || !bl.denies(stranger) || bl.denies(local) {
+		t.Error("Expected only the friend and the local peer to be allowed")
+	}
+	gater := &peerGater{bl: bl}
+	if gater.InterceptPeerDial(stranger) || !gater.InterceptPeerDial(friend) {
+		t.Error("Expected the gater to follow the blocklist")
+	}
+
+	ctx := context.Background()
+	if result := bl.validate(ctx, friend, &pubsub.Message{Message: &pb.Message{From: []byte(friend)}}); result != pubsub.ValidationAccept {
+		t.Errorf("Expected a message from the friend to be accepted, got %v", result)
+	}
+	if result := bl.validate(ctx, friend, &pubsub.Message{Message: &pb.Message{From: []byte(stranger)}}); result != pubsub.ValidationIgnore {
+		t.Errorf("Expected a message authored by a denied peer to be ignored, got %v", result)
+	}
+	if result := bl.validate(ctx, spammer, &pubsub.Message{Message: &pb.Message{}}); result != pubsub.ValidationIgnore {
+		t.Errorf("Expected a message forwarded by a denied peer to be ignored, got %v", result)
+	}
+}
@@ -6,10 +6,12 @@ import (
 	"time"
 
 	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/event"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/peerstore"
 	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
+	"github.com/multiformats/go-multiaddr"
 )
 
 // TestPeerAddPeers tests the AddPeers method
@@ -375,3 +377,80 @@ func triggerPruning(t *testing.T, p *Peer) {
 	// libp2p may have internal delays beyond just the grace period
 	time.Sleep(200 * time.Millisecond)
 }
+
+// TestPeerInfo tests connection introspection and ping
+// CRC: crc-Peer.md
+func TestPeerInfo(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatalf("Failed to create libp2p host: %v", err)
+	}
+	defer h.Close()
+	h2, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatalf("Failed to create second libp2p host: %v", err)
+	}
+	defer h2.Close()
+
+	p := &Peer{host: h, ctx: ctx, alias: "test-peer", manager: &Manager{streamTimeout: 5 * time.Second}}
+
+	if _, err := p.PeerInfo("invalid-peer-id", false); err == nil {
+		t.Error("Expected an invalid peer ID to be rejected")
+	}
+	info, err := p.PeerInfo(h2.ID().String(), false)
+	if err != nil || info.Connected || len(info.Connections) != 0 {
+		t.Errorf("Expected an unconnected peer, got %+v (%v)", info, err)
+	}
+
+	if err := h.Connect(ctx, peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	info, err = p.PeerInfo(h2.ID().String(), true)
+	if err != nil {
+		t.Fatalf("PeerInfo failed: %v", err)
+	}
+	if !info.Connected || len(info.Connections) != 1 || info.Connections[0].Relayed || info.Connections[0].Direction != "outbound" {
+		t.Errorf("Expected one direct outbound connection, got %+v", info.Connections)
+	}
+	if info.RTT <= 0 || info.PingError != "" {
+		t.Errorf("Expected a ping round trip time, got %v (%s)", info.RTT, info.PingError)
+	}
+
+	c := p.Connections()
+	if c.Reachability != "unknown" || len(c.Peers) != 1 || c.Peers[0].PeerID != h2.ID().String() {
+		t.Errorf("Expected unknown reachability and one connected peer, got %+v", c)
+	}
+}
+
+// TestConnectivityEvents tests which event bus events are reported to the client
+// CRC: crc-Peer.md
+func TestConnectivityEvents(t *testing.T) {
+	added, _ := peer.Decode("12D3KooWD3eckifWpRn9wQpMG9R9hX3sD158z7EqHWmweQAJU5SA")
+	other, _ := peer.Decode("12D3KooWJWoaqZhDaoEFshF7Rh1bpY9ohihFhzcW6d69Lr2NASuq")
+	p := &Peer{addedPeers: map[peer.ID]bool{added: true}}
+
+	evt, ok := p.connectivityEvent(event.EvtPeerConnectednessChanged{Peer: added, Connectedness: network.Limited})
+	if !ok || evt.Kind != ConnectivityPeer || !evt.Connected || !evt.Limited || evt.Peer != added.String() {
+		t.Errorf("Expected a limited connection of the added peer, got %+v (%v)", evt, ok)
+	}
+	if _, ok := p.connectivityEvent(event.EvtPeerConnectednessChanged{Peer: other, Connectedness: network.Connected}); ok {
+		t.Error("Expected connections of peers that weren't added to stay on the server")
+	}
+
+	evt, ok = p.connectivityEvent(event.EvtLocalReachabilityChanged{Reachability: network.ReachabilityPrivate})
+	if !ok || evt.Reachability != "private" {
+		t.Errorf("Expected private reachability, got %+v", evt)
+	}
+
+	relayAddr := multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001/p2p/" + other.String() + "/p2p-circuit")
+	evt, ok = p.connectivityEvent(event.EvtLocalAddressesUpdated{Current: []event.UpdatedAddress{
+		{Address: multiaddr.StringCast("/ip4/127.0.0.1/tcp/4001")},
+		{Address: relayAddr},
+	}})
+	if !ok || len(evt.Addrs) != 2 || !evt.Relayed {
+		t.Errorf("Expected two addresses with a relay reservation, got %+v", evt)
+	}
+}
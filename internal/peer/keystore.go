@@ -0,0 +1,281 @@
+package peer
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"golang.org/x/crypto/scrypt"
+)
+
+// KeystoreDirName is the keystore directory in a site's storage directory
+const KeystoreDirName = "identities"
+
+// identityFileExt is the extension of identity files in the keystore directory
+const identityFileExt = ".json"
+
+// scrypt parameters for deriving an identity's encryption key from its passphrase
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32 // AES-256-GCM
+	saltSize     = 16
+)
+
+// validIdentityName matches identity names, which are also file names
+var validIdentityName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Identity describes a stored peer identity without its key
+type Identity struct {
+	Name      string
+	PeerID    string
+	Encrypted bool // The key is encrypted with a passphrase
+}
+
+// identityFile is the stored form of an identity
+// Key is the crypto.ConfigEncodeKey form of the private key; with a passphrase it is
+// sealed with AES-256-GCM under a scrypt-derived key and stored in Ciphertext instead
+type identityFile struct {
+	PeerID     string `json:"peerId"`
+	Key        string `json:"key,omitempty"`
+	Salt       []byte `json:"salt,omitempty"`
+	Nonce      []byte `json:"nonce,omitempty"`
+	Ciphertext []byte `json:"ciphertext,omitempty"`
+}
+
+// keystore keeps named peer identities in a directory, one file per identity
+// Keys never leave the server: clients select an identity by name and only see its peer ID
+type keystore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// newKeystore opens a keystore directory, creating it if needed
+func newKeystore(dir string) (*keystore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create keystore directory: %w", err)
+	}
+	return &keystore{dir: dir}, nil
+}
+
+// path returns the file of a named identity
+func (ks *keystore) path(name string) string {
+	return filepath.Join(ks.dir, name+identityFileExt)
+}
+
+// create stores a new identity from encodedKey (crypto.ConfigEncodeKey output), or a fresh key if it is empty
+// A non-empty passphrase encrypts the key at rest
+func (ks *keystore) create(name, encodedKey, passphrase string) (string, error) {
+	if !validIdentityName.MatchString(name) {
+		return "", fmt.Errorf("invalid identity name %q: use up to 64 letters, digits, dashes and underscores", name)
+	}
+
+	var priv crypto.PrivKey
+	var err error
+	if encodedKey != "" {
+		if priv, err = decodePeerKey(encodedKey); err != nil {
+			return "", err
+		}
+	} else if priv, _, err = crypto.GenerateKeyPairWithReader(crypto.Ed25519, 2048, rand.Reader); err != nil {
+		return "", fmt.Errorf("failed to generate key pair: %w", err)
+	}
+
+	pid, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		return "", fmt.Errorf("failed to derive peer ID: %w", err)
+	}
+	keyBytes, err := crypto.MarshalPrivateKey(priv)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal private key: %w", err)
+	}
+
+	file := identityFile{PeerID: pid.String(), Key: crypto.ConfigEncodeKey(keyBytes)}
+	if passphrase != "" {
+		if err := file.seal(passphrase); err != nil {
+			return "", err
+		}
+	}
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal identity: %w", err)
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	// O_EXCL so an existing identity is never overwritten
+	f, err := os.OpenFile(ks.path(name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, os.ErrExist) {
+		return "", fmt.Errorf("identity already exists: %s", name)
+	} else if err != nil {
+		return "", fmt.Errorf("failed to create identity %s: %w", name, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(ks.path(name))
+		return "", fmt.Errorf("failed to write identity %s: %w", name, err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("failed to write identity %s: %w", name, err)
+	}
+	return file.PeerID, nil
+}
+
+// read loads a named identity's file
+func (ks *keystore) read(name string) (identityFile, error) {
+	var file identityFile
+	if !validIdentityName.MatchString(name) {
+		return file, fmt.Errorf("invalid identity name %q", name)
+	}
+	data, err := os.ReadFile(ks.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return file, fmt.Errorf("unknown identity: %s", name)
+	} else if err != nil {
+		return file, fmt.Errorf("failed to read identity %s: %w", name, err)
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return file, fmt.Errorf("invalid identity file %s: %w", name, err)
+	}
+	return file, nil
+}
+
+// key returns a named identity's key in crypto.ConfigEncodeKey form, decrypting it with passphrase if needed
+func (ks *keystore) key(name, passphrase string) (string, error) {
+	file, err := ks.read(name)
+	if err != nil {
+		return "", err
+	}
+	if file.Ciphertext == nil {
+		return file.Key, nil
+	}
+	if passphrase == "" {
+		return "", fmt.Errorf("identity %s is encrypted: passphrase required", name)
+	}
+	return file.open(passphrase)
+}
+
+// list returns the stored identities sorted by name
+func (ks *keystore) list() ([]Identity, error) {
+	entries, err := os.ReadDir(ks.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keystore: %w", err)
+	}
+	identities := []Identity{}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), identityFileExt)
+		if !ok || entry.IsDir() || !validIdentityName.MatchString(name) {
+			continue
+		}
+		file, err := ks.read(name)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, Identity{Name: name, PeerID: file.PeerID, Encrypted: file.Ciphertext != nil})
+	}
+	sort.Slice(identities, func(i, j int) bool { return identities[i].Name < identities[j].Name })
+	return identities, nil
+}
+
+// passphraseAEAD derives the AES-256-GCM cipher of a passphrase and salt
+func passphraseAEAD(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, scryptKeyLen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal moves the key into Ciphertext, encrypted under passphrase
+// The peer ID is the associated data, so the key can't be swapped into another identity's file
+func (f *identityFile) seal(passphrase string) error {
+	f.Salt = make([]byte, saltSize)
+	if _, err := rand.Read(f.Salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %w", err)
+	}
+	aead, err := passphraseAEAD(passphrase, f.Salt)
+	if err != nil {
+		return err
+	}
+	f.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(f.Nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	f.Ciphertext = aead.Seal(nil, f.Nonce, []byte(f.Key), []byte(f.PeerID))
+	f.Key = ""
+	return nil
+}
+
+// open decrypts the key with passphrase
+func (f *identityFile) open(passphrase string) (string, error) {
+	aead, err := passphraseAEAD(passphrase, f.Salt)
+	if err != nil {
+		return "", err
+	}
+	if len(f.Nonce) != aead.NonceSize() {
+		return "", fmt.Errorf("invalid identity file: bad nonce")
+	}
+	key, err := aead.Open(nil, f.Nonce, f.Ciphertext, []byte(f.PeerID))
+	if err != nil {
+		return "", fmt.Errorf("wrong passphrase")
+	}
+	return string(key), nil
+}
+
+// EnableKeystore stores named peer identities in dir (storage/identities), so clients
+// can select an identity instead of sending a peer key
+// Must be called before peers are created
+func (m *Manager) EnableKeystore(dir string) error {
+	ks, err := newKeystore(dir)
+	if err != nil {
+		return err
+	}
+	m.keystore = ks
+	return nil
+}
+
+// CreateIdentity stores a named identity and returns its peer ID
+// peerKey imports an existing key (crypto.ConfigEncodeKey form, as returned for browser-held keys);
+// empty generates a new one. A non-empty passphrase encrypts the key at rest
+func (m *Manager) CreateIdentity(name, peerKey, passphrase string) (string, error) {
+	if m.keystore == nil {
+		return "", fmt.Errorf("keystore not enabled")
+	}
+	return m.keystore.create(name, peerKey, passphrase)
+}
+
+// ListIdentities returns the stored identities, without their keys
+func (m *Manager) ListIdentities() ([]Identity, error) {
+	if m.keystore == nil {
+		return nil, fmt.Errorf("keystore not enabled")
+	}
+	return m.keystore.list()
+}
+
+// CreatePeerFromIdentity creates a peer with a stored identity's key
+// Unlike CreatePeer, the key isn't returned: it stays on the server
+func (m *Manager) CreatePeerFromIdentity(name, passphrase, rootDirectory string) (string, error) {
+	if m.keystore == nil {
+		return "", fmt.Errorf("keystore not enabled")
+	}
+	key, err := m.keystore.key(name, passphrase)
+	if err != nil {
+		return "", err
+	}
+	peerID, _, err := m.CreatePeer(key, rootDirectory)
+	return peerID, err
+}
@@ -0,0 +1,93 @@
+package peer
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// TestKeystoreIdentities tests creating, importing and listing identities
+func TestKeystoreIdentities(t *testing.T) {
+	ks, err := newKeystore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open keystore: %v", err)
+	}
+
+	// A browser-held key in crypto.ConfigEncodeKey form can be imported
+	priv, _, err := crypto.GenerateKeyPairWithReader(crypto.Ed25519, 2048, rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	keyBytes, _ := crypto.MarshalPrivateKey(priv)
+	browserKey := crypto.ConfigEncodeKey(keyBytes)
+	wantID, _ := peer.IDFromPrivateKey(priv)
+
+	imported, err := ks.create("alice", browserKey, "")
+	if err != nil || imported != wantID.String() {
+		t.Fatalf("Expected imported peer ID %s, got %s (%v)", wantID, imported, err)
+	}
+	if key, err := ks.key("alice", ""); err != nil || key != browserKey {
+		t.Errorf("Expected the imported key back, got %v", err)
+	}
+
+	generated, err := ks.create("bob", "", "")
+	if err != nil || generated == "" || generated == imported {
+		t.Fatalf("Expected a new peer ID, got %q (%v)", generated, err)
+	}
+
+	for _, name := range []string{"alice", "../evil", ""} {
+		if _, err := ks.create(name, "", ""); err == nil {
+			t.Errorf("Expected identity name %q to be rejected", name)
+		}
+	}
+
+	identities, err := ks.list()
+	if err != nil {
+		t.Fatalf("Failed to list identities: %v", err)
+	}
+	if len(identities) != 2 || identities[0].Name != "alice" || identities[0].PeerID != imported || identities[1].Name != "bob" {
+		t.Errorf("Expected alice and bob, got %+v", identities)
+	}
+}
+
+// TestKeystorePassphrase tests that encrypted identities need their passphrase
+func TestKeystorePassphrase(t *testing.T) {
+	ks, err := newKeystore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open keystore: %v", err)
+	}
+
+	peerID, err := ks.create("carol", "", "secret")
+	if err != nil {
+		t.Fatalf("Failed to create encrypted identity: %v", err)
+	}
+	if file, err := ks.read("carol"); err != nil || file.Key != "" || file.Ciphertext == nil {
+		t.Errorf("Expected only ciphertext at rest, got %+v (%v)", file, err)
+	}
+
+	if _, err := ks.key("carol", ""); err == nil {
+		t.Error("Expected an error without the passphrase")
+	}
+	if _, err := ks.key("carol", "wrong"); err == nil {
+		t.Error("Expected an error with the wrong passphrase")
+	}
+	key, err := ks.key("carol", "secret")
+	if err != nil {
+		t.Fatalf("Failed to decrypt identity: %v", err)
+	}
+	keyBytes, _ := crypto.ConfigDecodeKey(key)
+	priv, err := crypto.UnmarshalPrivateKey(keyBytes)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal decrypted key: %v", err)
+	}
+	if id, _ := peer.IDFromPrivateKey(priv); id.String() != peerID {
+		t.Errorf("Expected peer ID %s, got %s", peerID, id)
+	}
+
+	identities, _ := ks.list()
+	if len(identities) != 1 || !identities[0].Encrypted {
+		t.Errorf("Expected carol to be listed as encrypted, got %+v", identities)
+	}
+}
@@ -4,14 +4,17 @@ package peer
 import (
 	"bytes"
 	"context"
+	"crypto/cipher"
 	"crypto/rand"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -21,13 +24,13 @@ import (
 	uio "github.com/ipfs/boxo/ipld/unixfs/io"
 	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
 	ipld "github.com/ipfs/go-ipld-format"
 	"github.com/libp2p/go-libp2p"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
-	"github.com/libp2p/go-libp2p/core/connmgr"
-	"github.com/libp2p/go-libp2p/core/control"
 	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/event"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
@@ -36,6 +39,7 @@ import (
 	"github.com/libp2p/go-libp2p/core/routing"
 	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
 	discoveryrouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
 	"github.com/multiformats/go-multiaddr"
 )
 
@@ -49,13 +53,20 @@ type PeerOperations interface {
 	// Protocol operations
 	Start(protocol string) error
 	Stop(protocol string) error
-	SendToPeer(targetPeerIDStr, protocolStr string, data any) error
+	SendToPeer(targetPeerIDStr, protocolStr string, data any, ack int) error
+	CallPeer(targetPeerIDStr, protocolStr string, data any, timeout time.Duration) (any, error)
+	ResetQueue(targetPeerIDStr, protocolStr string) error
 
 	// Topic operations
-	Subscribe(topic string) error
+	Subscribe(topic string, history TopicHistoryRequest) error
 	Publish(topic string, data any) error
+	PublishWithKey(topic string, data any, keyID string) error
 	Unsubscribe(topic string) error
 	ListPeers(topic string) ([]string, error)
+	SetTopicValidator(topic string, spec TopicValidatorSpec) error
+	AddGroupKey(key []byte) (string, []byte, error)
+	SetTopicKey(topic, keyID string) error
+	RemoveTopicKey(topic, keyID string) error
 	Monitor(topic string) error
 	StopMonitor(topic string) error
 
@@ -90,18 +101,41 @@ type Manager struct {
 	ctx                   context.Context
 	mu                    sync.RWMutex
 	peers                 map[string]*Peer
-	onPeerData            func(receiverPeerID, senderPeerID, protocol string, data any)
-	onTopicData           func(receiverPeerID, topic, senderPeerID string, data any)
+	creating              map[peer.ID]chan struct{} // Peer IDs being created, closed when creation ends
+	onPeerData            func(receiverPeerID, senderPeerID, protocol string, data any, meta MessageMetadata)
+	onTopicData           func(receiverPeerID, topic, senderPeerID string, data any, meta MessageMetadata)
 	onPeerChange          func(receiverPeerID, topic, changedPeerID string, joined bool)
 	onPeerFiles           func(receiverPeerID, targetPeerID, dirCID string, entries map[string]any)
 	onGotFile             func(receiverPeerID string, cid string, success bool, content any)
+	onSendAck             func(senderPeerID string, ack int)
+	onSendFailed          func(senderPeerID, targetPeerID, protocol string, acks []int, reason string)
+	onPeerCall            func(receiverPeerID, senderPeerID, protocol string, data any, timeout time.Duration) (any, error)
+	onConnectivity        func(peerID string, evt ConnectivityEvent)
 	peerAliases           map[string]string // peerID -> alias
 	aliasCounter          int
 	verbosity             int
-	ipfsPeer              *ipfslite.Peer // IPFS peer for file storage
-	fileUpdateNotifyTopic string         // Optional topic for file update notifications
-	ipfsGetTimeout        time.Duration  // Timeout for IPFS Get operations
-	streamTimeout         time.Duration  // Timeout for opening streams to peers
+	ipfsPeer              *ipfslite.Peer           // IPFS peer for file storage
+	fileUpdateNotifyTopic string                   // Optional topic for file update notifications
+	ipfsGetTimeout        time.Duration            // Timeout for IPFS Get operations
+	streamTimeout         time.Duration            // Timeout for opening streams to peers
+	queueStore            *queueStore              // Optional durable store for outbound queues
+	queueTTL              time.Duration            // Default lifetime of queued messages (0 = no expiry)
+	queueTTLs             map[string]time.Duration // Per-protocol queue TTL overrides
+	queueMaxMessages      int                      // Per-queue message limit (0 = unlimited)
+	queueMaxBytes         int64                    // Per-queue payload byte limit (0 = unlimited)
+	queuePolicy           string                   // What a send does when its queue is full (QueuePolicy*)
+	topicSchemas          map[string]*jsonSchema   // JSON Schemas for topic validators, by name
+	historyMaxMessages    int                      // Messages kept per topic for replay (0 = history disabled)
+	historyMaxAge         time.Duration            // How long topic messages are kept for replay (0 = no limit)
+	pubsubSettings        PubsubSettings           // Router and tuning for each peer's pubsub
+	transports            *transportConfig         // Listen addresses, transports and announce filters of each peer's host
+	portSlots             map[int]bool             // Port offsets in use by peers, when listen ports are fixed
+	network               networkConfig            // Bootstrap peers, static relays, DHT mode and LAN-only mode
+	relay                 RelaySettings            // Circuit relay service of every peer host
+	namespace             string                   // App namespace prefixing mDNS, topics and protocols (empty = shared with every app)
+	fileProtocolName      string                   // Overrides the namespaced file protocol (empty = derived from namespace)
+	keystore              *keystore                // Optional server-side named identities
+	blocklist             *blocklist               // Optional persistent deny/allow list (nil = allow every peer)
 }
 
 // Peer represents a single libp2p peer with its own host and state
@@ -120,13 +154,20 @@ type Peer struct {
 	mu              sync.RWMutex
 	protocols       map[protocol.ID]*ProtocolHandler
 	topics          map[string]*TopicHandler
-	monitoredTopics map[string]*TopicMonitor // topics being monitored for join/leave events
+	monitoredTopics map[string]*TopicMonitor      // topics being monitored for join/leave events
+	joinedTopics    map[string]*joinedTopic       // pubsub topic handles shared by subscriptions, monitors and publishes
+	topicValidators map[string]pubsub.ValidatorEx // registered validators by topic, also applied to fetched history
+	history         map[string]*topicHistory      // recent messages of subscribed topics, kept for replay
+	historyRequests historyLimiter                // history requests served to each remote peer
+	groupKeys       map[string]cipher.AEAD        // group keys by ID, for encrypted topics
+	topicKeys       map[string]*topicKeys         // encrypted topics and their accepted keys
 	manager         *Manager
 	vcm             *VirtualConnectionManager // Virtual connection manager for reliability
 	directory       *uio.HAMTDirectory        // Peer's file directory (HAMTDirectory)
 	directoryCID    cid.Cid                   // Current CID of the peer's directory
 	fileListHandler func()                    // Handler for pending listFiles request (single handler per peer)
 	addedPeers      map[peer.ID]bool          // Track peers added via AddPeers (for retry attempts)
+	portSlot        int                       // Offset added to fixed listen ports (see TransportSettings.PortRange)
 }
 
 // TopicMonitor tracks peers in a topic and monitors join/leave events
@@ -134,9 +175,18 @@ type TopicMonitor struct {
 	Topic      string
 	ctx        context.Context
 	cancel     context.CancelFunc
+	events     *pubsub.TopicEventHandler // PeerJoin/PeerLeave events from gossipsub
+	mu         sync.Mutex
 	knownPeers map[string]bool // track which peers we've seen
 }
 
+// joinedTopic is a pubsub topic handle with a count of its users
+// pubsub allows one handle per topic, so Subscribe, Monitor and Publish share it
+type joinedTopic struct {
+	topic *pubsub.Topic
+	refs  int
+}
+
 // ProtocolHandler handles incoming streams for a protocol
 type ProtocolHandler struct {
 	Protocol protocol.ID
@@ -149,6 +199,20 @@ type TopicHandler struct {
 	Subscription *pubsub.Subscription
 	ctx          context.Context
 	cancel       context.CancelFunc
+	replayMu     sync.Mutex    // Protects replaying and held
+	replaying    bool          // History is being replayed, live messages wait in held
+	held         []heldMessage // Live messages received during replay
+}
+
+// MessageMetadata describes how a delivered peer or topic message arrived
+type MessageMetadata struct {
+	ID           string    // pubsub message ID (base64url), or the virtual connection message ID
+	Seqno        uint64    // pubsub sequence number, or the virtual connection sequence number (0 = none)
+	ReceivedFrom string    // Topic only: peer that relayed the message (the author is the sender)
+	SentAt       time.Time // Peer only: when the sender queued the message (zero if unknown)
+	ReceivedAt   time.Time // When this peer received the message
+	History      bool      // Topic only: replayed from topic history rather than received live
+	Subscriber   string    // Topic history only: the client that asked for the replay (empty = every client)
 }
 
 // discoveryNotifee gets notified when we find a new peer via mDNS discovery
@@ -165,7 +229,12 @@ func (n *discoveryNotifee) HandlePeerFound(pi peer.AddrInfo) {
 // NewManager creates a new peer manager
 // CRC: crc-PeerManager.md
 // Sequence: seq-server-startup.md
-func NewManager(ctx context.Context, bootstrapHost host.Host, ipfsPeer *ipfslite.Peer, verbosity int, fileUpdateNotifyTopic string, ipfsGetTimeout time.Duration, streamTimeout time.Duration) (*Manager, error) {
+// pubsubSettings tunes the pubsub router of every peer (see DefaultPubsubSettings)
+func NewManager(ctx context.Context, bootstrapHost host.Host, ipfsPeer *ipfslite.Peer, verbosity int, fileUpdateNotifyTopic string, ipfsGetTimeout time.Duration, streamTimeout time.Duration, pubsubSettings PubsubSettings) (*Manager, error) {
+	transports, err := parseTransportSettings(DefaultTransportSettings())
+	if err != nil {
+		return nil, err
+	}
 	return &Manager{
 		ctx:                   ctx,
 		peers:                 make(map[string]*Peer),
@@ -175,13 +244,16 @@ func NewManager(ctx context.Context, bootstrapHost host.Host, ipfsPeer *ipfslite
 		fileUpdateNotifyTopic: fileUpdateNotifyTopic,
 		ipfsGetTimeout:        ipfsGetTimeout,
 		streamTimeout:         streamTimeout,
+		pubsubSettings:        pubsubSettings,
+		transports:            transports,
+		portSlots:             make(map[int]bool),
 	}, nil
 }
 
 // SetCallbacks sets the callback functions for events
 func (m *Manager) SetCallbacks(
-	onPeerData func(receiverPeerID, senderPeerID, protocol string, data any),
-	onTopicData func(receiverPeerID, topic, senderPeerID string, data any),
+	onPeerData func(receiverPeerID, senderPeerID, protocol string, data any, meta MessageMetadata),
+	onTopicData func(receiverPeerID, topic, senderPeerID string, data any, meta MessageMetadata),
 	onPeerChange func(receiverPeerID, topic, changedPeerID string, joined bool),
 ) {
 	m.onPeerData = onPeerData
@@ -235,67 +307,74 @@ func (m *Manager) getOrCreateAlias(peerID string) string {
 	return m.getOrCreateAliasLocked(peerID)
 }
 
-// allowPrivateGater is a ConnectionGater that allows all connections,
-// including those on private/local addresses
-type allowPrivateGater struct{}
-
-// Ensure allowPrivateGater implements connmgr.ConnectionGater
-var _ connmgr.ConnectionGater = (*allowPrivateGater)(nil)
-
-func (g *allowPrivateGater) InterceptPeerDial(p peer.ID) (allow bool) {
-	return true
-}
-
-func (g *allowPrivateGater) InterceptAddrDial(p peer.ID, m multiaddr.Multiaddr) (allow bool) {
-	return true
-}
-
-func (g *allowPrivateGater) InterceptAccept(n network.ConnMultiaddrs) (allow bool) {
-	return true
-}
-
-func (g *allowPrivateGater) InterceptSecured(dir network.Direction, p peer.ID, n network.ConnMultiaddrs) (allow bool) {
-	return true
-}
-
-func (g *allowPrivateGater) InterceptUpgraded(c network.Conn) (allow bool, reason control.DisconnectReason) {
-	return true, 0
+// decodePeerKey parses a peer key in crypto.ConfigEncodeKey form
+func decodePeerKey(peerKey string) (crypto.PrivKey, error) {
+	keyBytes, err := crypto.ConfigDecodeKey(peerKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode peer key: %w", err)
+	}
+	priv, err := crypto.UnmarshalPrivateKey(keyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal peer key: %w", err)
+	}
+	return priv, nil
 }
 
-// prepareCreatePeer generates/parses the private key and checks for duplicates
-// Must be called with m.mu locked
-func (m *Manager) prepareCreatePeer(requestedPeerKey string) (priv crypto.PrivKey, err error) {
+// prepareCreatePeer generates/parses the private key and finds an existing peer with the same ID
+// A peer being created by another request is waited for, so both requests share it
+// Must be called with m.mu locked (it is released while waiting)
+func (m *Manager) prepareCreatePeer(requestedPeerKey string) (priv crypto.PrivKey, pid peer.ID, existing *Peer, err error) {
 	// Generate or parse peer identity
 	if requestedPeerKey != "" {
 		// Decode and unmarshal the private key
-		keyBytes, err := crypto.ConfigDecodeKey(requestedPeerKey)
+		priv, err = decodePeerKey(requestedPeerKey)
 		if err != nil {
-			return nil, fmt.Errorf("failed to decode peer key: %w", err)
-		}
-		priv, err = crypto.UnmarshalPrivateKey(keyBytes)
-		if err != nil {
-			return nil, fmt.Errorf("failed to unmarshal peer key: %w", err)
+			return nil, "", nil, err
 		}
 	} else {
 		// Generate new identity
 		priv, _, err = crypto.GenerateKeyPairWithReader(crypto.Ed25519, 2048, rand.Reader)
 		if err != nil {
-			return nil, fmt.Errorf("failed to generate key pair: %w", err)
+			return nil, "", nil, fmt.Errorf("failed to generate key pair: %w", err)
 		}
 	}
 
-	// Derive peer ID to check for duplicates
-	pid, err := peer.IDFromPublicKey(priv.GetPublic())
+	// Derive peer ID to check for an existing peer
+	pid, err = peer.IDFromPublicKey(priv.GetPublic())
 	if err != nil {
-		return nil, fmt.Errorf("failed to derive peer ID: %w", err)
+		return nil, "", nil, fmt.Errorf("failed to derive peer ID: %w", err)
 	}
 
-	// Check if peer ID is already registered (duplicate)
-	if _, exists := m.peers[pid.String()]; exists {
-		return nil, fmt.Errorf("peer ID already in use (possible duplicate browser tab)")
+	for {
+		// Another connection (e.g. a second browser tab) already has this peer: share it
+		if p, exists := m.peers[pid.String()]; exists {
+			return priv, pid, p, nil
+		}
+		creating, busy := m.creating[pid]
+		if !busy {
+			break
+		}
+		m.mu.Unlock()
+		<-creating
+		m.mu.Lock()
 	}
 
-	return priv, nil
+	// Claim the peer ID until CreatePeer finishes
+	if m.creating == nil {
+		m.creating = make(map[peer.ID]chan struct{})
+	}
+	m.creating[pid] = make(chan struct{})
+	return priv, pid, nil, nil
+}
+
+// finishCreatingPeer releases a peer ID claimed by prepareCreatePeer, waking requests waiting for it
+func (m *Manager) finishCreatingPeer(pid peer.ID) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if creating, exists := m.creating[pid]; exists {
+		close(creating)
+		delete(m.creating, pid)
+	}
 }
 
 // CreatePeer creates a new peer with its own libp2p host
@@ -306,12 +385,24 @@ func (m *Manager) CreatePeer(requestedPeerKey string, rootDirectory string) (pee
 	// PHASE 1: Validate and get peer snapshot (minimal lock)
 	// ============================================================
 	m.mu.Lock()
-	// Prepare and validate peer creation (checks for duplicates)
-	priv, err := m.prepareCreatePeer(requestedPeerKey)
+	// Prepare and validate peer creation (finds an existing peer with the same key)
+	priv, pid, existing, err := m.prepareCreatePeer(requestedPeerKey)
 	if err != nil {
 		m.mu.Unlock()
 		return "", "", err
 	}
+	if existing != nil {
+		m.mu.Unlock()
+		return existing.peerID.String(), requestedPeerKey, nil
+	}
+	defer m.finishCreatingPeer(pid)
+	// The blocklist never denies the server's own peers, so they can connect to each other
+	m.blocklist.setLocal(pid, true)
+	defer func() {
+		if err != nil {
+			m.blocklist.setLocal(pid, false)
+		}
+	}()
 
 	// Get snapshot of existing peers for later connection
 	existingPeers := make([]*Peer, 0, len(m.peers))
@@ -331,26 +422,42 @@ func (m *Manager) CreatePeer(requestedPeerKey string, rootDirectory string) (pee
 	}
 	encodedKey := crypto.ConfigEncodeKey(keyBytes)
 
+	// Reserve a port offset when listen ports are fixed, so peers don't collide
+	portSlot, err := m.acquirePortSlot()
+	if err != nil {
+		return "", "", err
+	}
+	defer func() {
+		if err != nil {
+			m.releasePortSlot(portSlot)
+		}
+	}()
+	transportOpts, err := m.transports.hostOptions(portSlot)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to configure transports: %w", err)
+	}
+
 	// Variable to store DHT reference
 	var kdht *dht.IpfsDHT
 
 	// Create libp2p host
-	h, err := libp2p.New(
+	// Listen addresses, transports and announced addresses come from the manager's transport settings
+	hostOpts := append(transportOpts,
 		libp2p.Identity(priv),
-		libp2p.ListenAddrStrings("/ip4/0.0.0.0/tcp/0"),            // Random port
-		libp2p.ConnectionGater(&allowPrivateGater{}),              // Allow private/local addresses
-		libp2p.EnableRelay(),                                      // Enable relay for NAT traversal
-		libp2p.EnableAutoRelayWithStaticRelays([]peer.AddrInfo{}), // Use public relays
-		libp2p.NATPortMap(),                                       // Try NAT port mapping
-		libp2p.EnableNATService(),                                 // Help other peers with NAT detection
-		libp2p.EnableHolePunching(),                               // Enable hole punching for direct connections
-		libp2p.Routing(func(h host.Host) (routing.PeerRouting, error) {
+		libp2p.ConnectionGater(&peerGater{bl: m.blocklist}), // Allow private/local addresses, refuse blocked peers
+		libp2p.EnableRelay(),                                // Enable relay for NAT traversal
+	)
+	hostOpts = append(hostOpts, m.network.natOptions()...)
+	hostOpts = append(hostOpts, m.relay.hostOptions()...)
+	if dhtOpts, enabled := m.network.dhtOptions(); enabled {
+		hostOpts = append(hostOpts, libp2p.Routing(func(h host.Host) (routing.PeerRouting, error) {
 			// Create DHT for global discovery
 			var err error
-			kdht, err = dht.New(m.ctx, h, dht.Mode(dht.ModeAutoServer))
+			kdht, err = dht.New(m.ctx, h, dhtOpts...)
 			return kdht, err
-		}),
-	)
+		}))
+	}
+	h, err := libp2p.New(hostOpts...)
 	if err != nil {
 		return "", "", fmt.Errorf("failed to create host: %w", err)
 	}
@@ -358,7 +465,7 @@ func (m *Manager) CreatePeer(requestedPeerKey string, rootDirectory string) (pee
 	// Note: DHT bootstrap is started later after peer creation (see below)
 
 	// Setup mDNS for local discovery
-	mdnsService := mdns.NewMdnsService(h, "p2p-webapp", &discoveryNotifee{h: h})
+	mdnsService := mdns.NewMdnsService(h, m.mdnsServiceName(), &discoveryNotifee{h: h})
 	if err := mdnsService.Start(); err != nil {
 		if kdht != nil {
 			kdht.Close()
@@ -368,13 +475,7 @@ func (m *Manager) CreatePeer(requestedPeerKey string, rootDirectory string) (pee
 	}
 
 	// Create pubsub with DHT-based discovery for global peer finding
-	// Configure GossipSub with faster heartbeat for quicker mesh formation
-	var ps *pubsub.PubSub
-	gossipSubParams := pubsub.DefaultGossipSubParams()
-	// Use default mesh parameters (D=6, Dlo=5, Dhi=12, Dout=2) which are validated and work well
-	// Only adjust heartbeat timing for faster mesh formation in local networks
-	gossipSubParams.HeartbeatInitialDelay = 50 * time.Millisecond // Faster initial heartbeat for quick mesh formation
-	gossipSubParams.HeartbeatInterval = 500 * time.Millisecond    // More frequent heartbeats (default: 1s)
+	// The router and its tuning come from the manager's pubsub settings
 
 	// Build direct peer list from existing peers in the same Manager
 	// This guarantees localhost peers are always in each other's mesh
@@ -385,31 +486,9 @@ func (m *Manager) CreatePeer(requestedPeerKey string, rootDirectory string) (pee
 			Addrs: otherPeer.host.Addrs(),
 		})
 	}
-	m.LogVerbose(h.ID().String(), 2, "Configuring GossipSub with %d direct peers", len(directPeerInfos))
+	m.LogVerbose(h.ID().String(), 2, "Configuring %s with %d direct peers", m.pubsubSettings.Router, len(directPeerInfos))
 
-	if kdht != nil {
-		// Use DHT for topic-based peer discovery (enables global connectivity)
-		routingDiscovery := discoveryrouting.NewRoutingDiscovery(kdht)
-		ps, err = pubsub.NewGossipSub(
-			m.ctx,
-			h,
-			pubsub.WithDiscovery(routingDiscovery),
-			pubsub.WithPeerExchange(true), // Enable peer exchange
-			pubsub.WithFloodPublish(true), // Flood publish for reliability in small networks
-			pubsub.WithGossipSubParams(gossipSubParams),
-			pubsub.WithDirectPeers(directPeerInfos), // Guarantee mesh inclusion for localhost peers
-		)
-	} else {
-		// Fallback without discovery
-		ps, err = pubsub.NewGossipSub(
-			m.ctx,
-			h,
-			pubsub.WithPeerExchange(true), // Enable peer exchange
-			pubsub.WithFloodPublish(true), // Flood publish for reliability in small networks
-			pubsub.WithGossipSubParams(gossipSubParams),
-			pubsub.WithDirectPeers(directPeerInfos), // Guarantee mesh inclusion for localhost peers
-		)
-	}
+	ps, err := m.newPubsub(h, kdht, directPeerInfos)
 	if err != nil {
 		mdnsService.Close()
 		if kdht != nil {
@@ -432,8 +511,14 @@ func (m *Manager) CreatePeer(requestedPeerKey string, rootDirectory string) (pee
 		protocols:       make(map[protocol.ID]*ProtocolHandler),
 		topics:          make(map[string]*TopicHandler),
 		monitoredTopics: make(map[string]*TopicMonitor),
+		joinedTopics:    make(map[string]*joinedTopic),
+		topicValidators: make(map[string]pubsub.ValidatorEx),
+		history:         make(map[string]*topicHistory),
+		groupKeys:       make(map[string]cipher.AEAD),
+		topicKeys:       make(map[string]*topicKeys),
 		manager:         m,
 		addedPeers:      make(map[peer.ID]bool),
+		portSlot:        portSlot,
 	}
 
 	// Initialize virtual connection manager
@@ -576,7 +661,7 @@ func (m *Manager) CreatePeer(requestedPeerKey string, rootDirectory string) (pee
 	}
 
 	// Register protocol handler for file list queries
-	h.SetStreamHandler(protocol.ID(P2PWebAppProtocol), p.handleP2PWebAppStream)
+	h.SetStreamHandler(m.fileProtocol(), p.handleP2PWebAppStream)
 
 	// ============================================================
 	// PHASE 3: Update Manager state (minimal lock)
@@ -601,15 +686,24 @@ func (m *Manager) CreatePeer(requestedPeerKey string, rootDirectory string) (pee
 		}
 	}
 
+	// Resume delivery of messages queued before the last shutdown
+	if err := p.vcm.RestoreQueues(); err != nil {
+		m.LogVerbose(p.peerID.String(), 1, "Failed to restore queued messages: %v", err)
+	}
+
 	// Start background retry goroutine for added peers that haven't connected yet
 	go p.retryAddedPeersLoop()
 
+	// Report added peers connecting and dropping, and NAT and address changes
+	go p.watchConnectivity()
+
 	// Start DHT bootstrap goroutine (signals readiness and processes queued operations)
 	if kdht != nil {
-		go p.bootstrapDHT(kdht, h)
+		go p.bootstrapDHT(kdht)
 	} else {
 		// No DHT - close dhtReady immediately so operations don't wait
 		close(p.dhtReady)
+		go p.connectBootstrapPeers()
 	}
 
 	return p.peerID.String(), encodedKey, nil
@@ -650,12 +744,23 @@ func (m *Manager) Stop(peerID, protocolStr string) error {
 // Send sends data to a peer on a protocol
 // CRC: crc-PeerManager.md
 // Sequence: seq-protocol-communication.md
-func (m *Manager) Send(peerID, targetPeerID, protocolStr string, data any) error {
+func (m *Manager) Send(peerID, targetPeerID, protocolStr string, data any, ack int) error {
 	p, err := m.getPeer(peerID)
 	if err != nil {
 		return err
 	}
-	return p.SendToPeer(targetPeerID, protocolStr, data)
+	return p.SendToPeer(targetPeerID, protocolStr, data, ack)
+}
+
+// Call sends a request from one peer to another and waits for the reply
+// CRC: crc-PeerManager.md
+// Sequence: seq-protocol-communication.md
+func (m *Manager) Call(peerID, targetPeerID, protocolStr string, data any, timeout time.Duration) (any, error) {
+	p, err := m.getPeer(peerID)
+	if err != nil {
+		return nil, err
+	}
+	return p.CallPeer(targetPeerID, protocolStr, data, timeout)
 }
 
 // Subscribe subscribes a peer to a pub/sub topic
@@ -666,7 +771,7 @@ func (m *Manager) Subscribe(peerID, topic string) error {
 	if err != nil {
 		return err
 	}
-	return p.Subscribe(topic)
+	return p.Subscribe(topic, TopicHistoryRequest{})
 }
 
 // Publish publishes data to a topic from a peer
@@ -728,7 +833,10 @@ func (m *Manager) RemovePeer(peerID string) error {
 	m.mu.Unlock()
 
 	// Clean up peer resources
-	return p.Close()
+	err := p.Close()
+	m.releasePortSlot(p.portSlot)
+	m.blocklist.setLocal(p.peerID, false)
+	return err
 }
 
 // AddPeers protects and tags peer connections to ensure they remain active
@@ -753,6 +861,26 @@ func (m *Manager) RemovePeers(peerID string, targetPeerIDs []string) error {
 	return peer.RemovePeers(targetPeerIDs)
 }
 
+// PeerInfo returns what a peer's host knows about a remote peer, pinging it first if ping is set
+// CRC: crc-PeerManager.md
+func (m *Manager) PeerInfo(peerID, targetPeerID string, ping bool) (PeerInfo, error) {
+	peer, err := m.getPeer(peerID)
+	if err != nil {
+		return PeerInfo{}, err
+	}
+	return peer.PeerInfo(targetPeerID, ping)
+}
+
+// Connections returns a peer's reachability and the peers it is connected to
+// CRC: crc-PeerManager.md
+func (m *Manager) Connections(peerID string) (Connectivity, error) {
+	peer, err := m.getPeer(peerID)
+	if err != nil {
+		return Connectivity{}, err
+	}
+	return peer.Connections(), nil
+}
+
 // Shutdown closes all peers
 // CRC: crc-PeerManager.md
 func (m *Manager) Shutdown() error {
@@ -780,6 +908,7 @@ func (m *Manager) Shutdown() error {
 		if err := p.Close(); err != nil {
 			fmt.Printf("Warning: failed to close peer: %v\n", err)
 		}
+		m.releasePortSlot(p.portSlot)
 		if m.verbosity >= 3 {
 			fmt.Printf("[DEBUG] Peer %d/%d closed\n", i+1, len(peers))
 		}
@@ -1066,10 +1195,12 @@ func (p *Peer) Start(protocolStr string) error {
 	}
 	p.protocols[pid] = handler
 
-	// Set stream handler
-	p.host.SetStreamHandler(pid, func(s network.Stream) {
-		p.handleIncomingStream(s)
-	})
+	// Set stream handlers on the namespaced protocol, framed and legacy
+	for _, streamPID := range p.manager.streamProtocols(protocolStr) {
+		p.host.SetStreamHandler(streamPID, func(s network.Stream) {
+			p.handleIncomingStream(s)
+		})
+	}
 
 	return nil
 }
@@ -1085,36 +1216,65 @@ func (p *Peer) Stop(protocolStr string) error {
 	}
 
 	delete(p.protocols, pid)
-	p.host.RemoveStreamHandler(pid)
+	for _, streamPID := range p.manager.streamProtocols(protocolStr) {
+		p.host.RemoveStreamHandler(streamPID)
+	}
 
 	return nil
 }
 
 // SendToPeer sends data to a peer on a protocol using the virtual connection manager
-func (p *Peer) SendToPeer(targetPeerIDStr, protocolStr string, data any) error {
+// If ack >= 0, the send ack callback fires after the remote peer acknowledges delivery
+func (p *Peer) SendToPeer(targetPeerIDStr, protocolStr string, data any, ack int) error {
 	// Use virtual connection manager for reliable delivery with queuing, retry, and ACK
-	return p.vcm.SendToQueue(targetPeerIDStr, protocolStr, data)
+	return p.vcm.SendToQueue(targetPeerIDStr, protocolStr, data, ack)
+}
+
+// CallPeer sends a request to a peer on a protocol and returns the remote application's reply
+func (p *Peer) CallPeer(targetPeerIDStr, protocolStr string, data any, timeout time.Duration) (any, error) {
+	if _, err := peer.Decode(targetPeerIDStr); err != nil {
+		return nil, fmt.Errorf("invalid peer ID: %w", err)
+	}
+	return p.vcm.Call(targetPeerIDStr, protocolStr, data, timeout)
+}
+
+// ResetQueue clears the unreachable state of the queues to a peer so sends are retried
+// An empty protocol resets the queues for every protocol
+func (p *Peer) ResetQueue(targetPeerIDStr, protocolStr string) error {
+	if _, err := peer.Decode(targetPeerIDStr); err != nil {
+		return fmt.Errorf("invalid peer ID: %w", err)
+	}
+	p.vcm.ResetQueues(targetPeerIDStr, protocolStr)
+	return nil
 }
 
-func (p *Peer) Subscribe(topic string) error {
+// Subscribe subscribes to a topic
+// A non-empty history request replays the topic's recent messages before live ones
+// Sequence: seq-pubsub-communication.md
+func (p *Peer) Subscribe(topic string, history TopicHistoryRequest) error {
 	p.mu.Lock()
-	defer p.mu.Unlock()
 
-	// If already subscribed, return success (idempotent)
-	if _, exists := p.topics[topic]; exists {
+	// If already subscribed (e.g. by another client of the peer), return success (idempotent)
+	// A client asking for history still gets it, before Subscribe returns so it precedes live messages
+	if handler, exists := p.topics[topic]; exists {
+		p.mu.Unlock()
+		if !history.empty() {
+			p.replayHistoryTo(handler, history)
+		}
 		return nil
 	}
+	defer p.mu.Unlock()
 
 	// Join topic
-	t, err := p.pubsub.Join(topic)
+	t, err := p.joinTopicLocked(topic)
 	if err != nil {
-		return fmt.Errorf("failed to join topic: %w", err)
+		return err
 	}
 
 	// Subscribe
 	sub, err := t.Subscribe()
 	if err != nil {
-		t.Close()
+		p.leaveTopicLocked(topic)
 		return fmt.Errorf("failed to subscribe to topic: %w", err)
 	}
 
@@ -1126,6 +1286,7 @@ func (p *Peer) Subscribe(topic string) error {
 		Subscription: sub,
 		ctx:          ctx,
 		cancel:       cancel,
+		replaying:    !history.empty(),
 	}
 	p.topics[topic] = handler
 
@@ -1142,34 +1303,43 @@ func (p *Peer) Subscribe(topic string) error {
 	// This ensures peers can communicate immediately after Subscribe() returns
 	p.waitForMeshFormation(t)
 
+	// Replay history once topic peers are known to fetch it from
+	if !history.empty() {
+		go p.replayHistory(handler, history)
+	}
+
 	return nil
 }
 
+// Publish publishes to a topic, encrypted with the topic's current group key if it has one
 func (p *Peer) Publish(topic string, data any) error {
-	p.mu.RLock()
-	handler, exists := p.topics[topic]
-	p.mu.RUnlock()
-
-	var t *pubsub.Topic
-	var err error
+	return p.PublishWithKey(topic, data, "")
+}
 
-	if exists {
-		// Use existing topic
-		t = handler.PubsubTopic
-	} else {
-		// Join new topic
-		t, err = p.pubsub.Join(topic)
-		if err != nil {
-			return fmt.Errorf("failed to join topic: %w", err)
-		}
-		defer t.Close()
+// PublishWithKey publishes to a topic encrypted with a stored group key
+// An empty key ID uses the topic's current key, or publishes unencrypted if the topic has none
+func (p *Peer) PublishWithKey(topic string, data any, keyID string) error {
+	// Use the shared topic handle, joining the topic if nothing else has
+	p.mu.Lock()
+	t, err := p.joinTopicLocked(topic)
+	p.mu.Unlock()
+	if err != nil {
+		return err
 	}
+	defer func() {
+		p.mu.Lock()
+		p.leaveTopicLocked(topic)
+		p.mu.Unlock()
+	}()
 
 	// Encode data
 	jsonData, err := json.Marshal(data)
 	if err != nil {
 		return fmt.Errorf("failed to marshal data: %w", err)
 	}
+	if jsonData, err = p.sealTopicMessage(topic, jsonData, keyID); err != nil {
+		return err
+	}
 
 	// Publish
 	if err := t.Publish(p.ctx, jsonData); err != nil {
@@ -1188,15 +1358,75 @@ func (p *Peer) Unsubscribe(topic string) error {
 		return nil
 	}
 	delete(p.topics, topic)
-	p.mu.Unlock()
-
 	handler.cancel()
 	handler.Subscription.Cancel()
-	handler.PubsubTopic.Close()
+	p.leaveTopicLocked(topic)
+	p.mu.Unlock()
 
 	return nil
 }
 
+// SetTopicValidator registers gossipsub validation for a topic, replacing any previous validator
+// An empty spec removes the topic's validator
+func (p *Peer) SetTopicValidator(topic string, spec TopicValidatorSpec) error {
+	validate, err := p.newTopicValidator(topic, spec)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.topicValidators[topic] != nil {
+		if err := p.pubsub.UnregisterTopicValidator(p.manager.wireTopic(topic)); err != nil {
+			return fmt.Errorf("failed to remove topic validator: %w", err)
+		}
+		delete(p.topicValidators, topic)
+	}
+	if validate == nil {
+		return nil
+	}
+
+	if err := p.pubsub.RegisterTopicValidator(p.manager.wireTopic(topic), validate); err != nil {
+		return fmt.Errorf("failed to register topic validator: %w", err)
+	}
+	p.topicValidators[topic] = validate
+	return nil
+}
+
+// joinTopicLocked returns the shared handle for a topic, joining it on first use (caller must hold mu)
+// Each call must be paired with leaveTopicLocked
+func (p *Peer) joinTopicLocked(topic string) (*pubsub.Topic, error) {
+	if joined, exists := p.joinedTopics[topic]; exists {
+		joined.refs++
+		return joined.topic, nil
+	}
+
+	t, err := p.pubsub.Join(p.manager.wireTopic(topic))
+	if err != nil {
+		return nil, fmt.Errorf("failed to join topic: %w", err)
+	}
+	p.joinedTopics[topic] = &joinedTopic{topic: t, refs: 1}
+	return t, nil
+}
+
+// leaveTopicLocked releases a handle from joinTopicLocked and closes the topic when unused (caller must hold mu)
+// Subscriptions and event handlers on the handle must be cancelled first
+func (p *Peer) leaveTopicLocked(topic string) {
+	joined, exists := p.joinedTopics[topic]
+	if !exists {
+		return
+	}
+	joined.refs--
+	if joined.refs > 0 {
+		return
+	}
+	delete(p.joinedTopics, topic)
+	if err := joined.topic.Close(); err != nil {
+		p.logVerbose(1, "Failed to close topic %s: %v", topic, err)
+	}
+}
+
 // waitForMeshFormation waits for the gossip mesh to form after subscribing to a topic
 // This ensures peers can communicate immediately after Subscribe() returns
 func (p *Peer) waitForMeshFormation(t *pubsub.Topic) {
@@ -1239,7 +1469,7 @@ func (p *Peer) advertiseTopic(topic string, handler *TopicHandler) {
 		var ttl time.Duration
 		var err error
 		for attempt := 1; attempt <= 3; attempt++ {
-			ttl, err = routingDiscovery.Advertise(p.ctx, topic)
+			ttl, err = routingDiscovery.Advertise(p.ctx, p.manager.wireTopic(topic))
 			if err == nil {
 				break
 			}
@@ -1274,7 +1504,7 @@ func (p *Peer) advertiseTopic(topic string, handler *TopicHandler) {
 				// Topic unsubscribed, stop advertising
 				return
 			case <-ticker.C:
-				ttl, err = routingDiscovery.Advertise(p.ctx, topic)
+				ttl, err = routingDiscovery.Advertise(p.ctx, p.manager.wireTopic(topic))
 				if err != nil {
 					p.logVerbose(2, "Failed to re-advertise topic %s to DHT: %v", topic, err)
 				} else {
@@ -1295,7 +1525,7 @@ func (p *Peer) discoverTopicPeers(topic string) {
 		p.logVerbose(2, "Discovering peers for topic %s via DHT...", topic)
 
 		// FindPeers queries the DHT for peers advertising this topic
-		peerChan, err := routingDiscovery.FindPeers(p.ctx, topic)
+		peerChan, err := routingDiscovery.FindPeers(p.ctx, p.manager.wireTopic(topic))
 		if err != nil {
 			p.logVerbose(1, "Failed to start DHT peer discovery for topic %s: %v", topic, err)
 			return
@@ -1334,6 +1564,8 @@ func (p *Peer) ListPeers(topic string) ([]string, error) {
 
 	// If monitoring this topic, return the monitored peer list
 	if isMonitored {
+		monitor.mu.Lock()
+		defer monitor.mu.Unlock()
 		peerStrs := make([]string, 0, len(monitor.knownPeers))
 		for peerID := range monitor.knownPeers {
 			peerStrs = append(peerStrs, peerID)
@@ -1342,7 +1574,7 @@ func (p *Peer) ListPeers(topic string) ([]string, error) {
 	}
 
 	// Otherwise, query gossipsub directly
-	peers := p.pubsub.ListPeers(topic)
+	peers := p.pubsub.ListPeers(p.manager.wireTopic(topic))
 
 	// Convert peer.ID slice to string slice
 	peerStrs := make([]string, len(peers))
@@ -1451,6 +1683,222 @@ func (p *Peer) RemovePeers(targetPeerIDs []string) error {
 	return nil
 }
 
+// ConnectionInfo describes one open libp2p connection to a remote peer
+type ConnectionInfo struct {
+	Addr      string // Remote multiaddr
+	Direction string // "inbound" or "outbound"
+	Relayed   bool   // Through a circuit relay instead of direct
+	Limited   bool   // Limited in time or bytes by the relay
+	Opened    time.Time
+	Streams   int // Open streams
+}
+
+// PeerInfo describes what a peer's host knows about a remote peer
+type PeerInfo struct {
+	PeerID       string
+	Connected    bool
+	Connections  []ConnectionInfo
+	Addrs        []string      // Known addresses of the remote peer
+	Protocols    []string      // Protocols the remote peer supports (app names, see appProtocol)
+	AgentVersion string        // From identify
+	Latency      time.Duration // Smoothed latency seen by the host (0 = unknown)
+	RTT          time.Duration // Round trip time of a ping, when requested
+	PingError    string        // Why the requested ping failed
+}
+
+// Kinds of connectivity events
+const (
+	ConnectivityPeer         = "peer"         // A peer added with AddPeers connected or disconnected
+	ConnectivityReachability = "reachability" // AutoNAT changed its reachability verdict
+	ConnectivityAddresses    = "addresses"    // The peer's own addresses changed, e.g. a relay reservation was obtained
+)
+
+// ConnectivityEvent is a connectivity change from a peer's host event bus
+type ConnectivityEvent struct {
+	Kind         string
+	Peer         string   // ConnectivityPeer: the added peer
+	Connected    bool     // ConnectivityPeer
+	Limited      bool     // ConnectivityPeer: connected only through a limited relay connection
+	Reachability string   // ConnectivityReachability: "public", "private" or "unknown"
+	Addrs        []string // ConnectivityAddresses: the current addresses
+	Relayed      bool     // ConnectivityAddresses: a relay reservation gives the peer a /p2p-circuit address
+}
+
+// Connectivity describes a peer's own reachability and the peers it is connected to
+type Connectivity struct {
+	Reachability string     // AutoNAT result: "public", "private" or "unknown"
+	Addrs        []string   // The peer's own addresses
+	Peers        []PeerInfo // Connected peers
+}
+
+// PeerInfo returns what the host knows about a remote peer
+// With ping, the round trip time is measured first with the libp2p ping protocol
+// CRC: crc-Peer.md
+func (p *Peer) PeerInfo(targetPeerIDStr string, ping bool) (PeerInfo, error) {
+	targetPeerID, err := peer.Decode(targetPeerIDStr)
+	if err != nil {
+		return PeerInfo{}, fmt.Errorf("invalid peer ID: %w", err)
+	}
+
+	var rtt time.Duration
+	var pingErr string
+	if ping {
+		rtt, err = p.ping(targetPeerID)
+		if err != nil {
+			pingErr = err.Error()
+		}
+	}
+	info := p.peerInfo(targetPeerID)
+	info.RTT = rtt
+	info.PingError = pingErr
+	return info, nil
+}
+
+// Connections returns the peer's reachability, its addresses and the peers it is connected to
+// CRC: crc-Peer.md
+func (p *Peer) Connections() Connectivity {
+	c := Connectivity{Reachability: p.reachability(), Addrs: []string{}, Peers: []PeerInfo{}}
+	for _, addr := range p.host.Addrs() {
+		c.Addrs = append(c.Addrs, addr.String())
+	}
+	for _, pid := range p.host.Network().Peers() {
+		c.Peers = append(c.Peers, p.peerInfo(pid))
+	}
+	return c
+}
+
+// peerInfo gathers the host's connections and peerstore data about a remote peer
+func (p *Peer) peerInfo(pid peer.ID) PeerInfo {
+	info := PeerInfo{
+		PeerID:      pid.String(),
+		Connected:   p.host.Network().Connectedness(pid) == network.Connected,
+		Connections: []ConnectionInfo{},
+		Addrs:       []string{},
+		Protocols:   []string{},
+		Latency:     p.host.Peerstore().LatencyEWMA(pid),
+	}
+	for _, conn := range p.host.Network().ConnsToPeer(pid) {
+		stat := conn.Stat()
+		_, err := conn.RemoteMultiaddr().ValueForProtocol(multiaddr.P_CIRCUIT)
+		info.Connections = append(info.Connections, ConnectionInfo{
+			Addr:      conn.RemoteMultiaddr().String(),
+			Direction: strings.ToLower(stat.Direction.String()),
+			Relayed:   err == nil,
+			Limited:   stat.Limited,
+			Opened:    stat.Opened,
+			Streams:   stat.NumStreams,
+		})
+	}
+	for _, addr := range p.host.Peerstore().Addrs(pid) {
+		info.Addrs = append(info.Addrs, addr.String())
+	}
+	if protocols, err := p.host.Peerstore().GetProtocols(pid); err == nil {
+		for _, proto := range protocols {
+			// Framed protocols duplicate their legacy protocol
+			if !isFramed(proto) {
+				info.Protocols = append(info.Protocols, p.manager.appProtocol(proto))
+			}
+		}
+		sort.Strings(info.Protocols)
+	}
+	if agent, err := p.host.Peerstore().Get(pid, "AgentVersion"); err == nil {
+		info.AgentVersion, _ = agent.(string)
+	}
+	return info
+}
+
+// ping measures the round trip time to a remote peer, connecting first if needed
+func (p *Peer) ping(pid peer.ID) (time.Duration, error) {
+	timeout := p.manager.streamTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(p.ctx, timeout)
+	defer cancel()
+	result := <-ping.Ping(ctx, p.host, pid)
+	if result.Error != nil {
+		return 0, fmt.Errorf("ping failed: %w", result.Error)
+	}
+	return result.RTT, nil
+}
+
+// reachability returns the host's NAT reachability as last reported by AutoNAT
+func (p *Peer) reachability() string {
+	// AutoNAT's emitter is stateful: a new subscription gets the last event at once, if any
+	sub, err := p.host.EventBus().Subscribe(new(event.EvtLocalReachabilityChanged))
+	if err != nil {
+		return strings.ToLower(network.ReachabilityUnknown.String())
+	}
+	defer sub.Close()
+	select {
+	case evt := <-sub.Out():
+		return strings.ToLower(evt.(event.EvtLocalReachabilityChanged).Reachability.String())
+	default:
+		return strings.ToLower(network.ReachabilityUnknown.String())
+	}
+}
+
+// watchConnectivity reports connectivity changes from the host's event bus until the peer closes
+// Only peers added with AddPeers are reported, so DHT and gossip connection churn stays on the server
+func (p *Peer) watchConnectivity() {
+	sub, err := p.host.EventBus().Subscribe([]any{
+		new(event.EvtPeerConnectednessChanged),
+		new(event.EvtLocalReachabilityChanged),
+		new(event.EvtLocalAddressesUpdated),
+	})
+	if err != nil {
+		p.logVerbose(1, "Failed to subscribe to connectivity events: %v", err)
+		return
+	}
+	defer sub.Close()
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case e, ok := <-sub.Out():
+			if !ok {
+				return
+			}
+			if evt, ok := p.connectivityEvent(e); ok {
+				p.manager.notifyConnectivity(p.peerID.String(), evt)
+			}
+		}
+	}
+}
+
+// connectivityEvent converts an event bus event, reporting false for events the client doesn't get
+func (p *Peer) connectivityEvent(e any) (ConnectivityEvent, bool) {
+	switch evt := e.(type) {
+	case event.EvtPeerConnectednessChanged:
+		p.mu.RLock()
+		added := p.addedPeers[evt.Peer]
+		p.mu.RUnlock()
+		limited := evt.Connectedness == network.Limited
+		return ConnectivityEvent{
+			Kind:      ConnectivityPeer,
+			Peer:      evt.Peer.String(),
+			Connected: evt.Connectedness == network.Connected || limited,
+			Limited:   limited,
+		}, added
+	case event.EvtLocalReachabilityChanged:
+		return ConnectivityEvent{
+			Kind:         ConnectivityReachability,
+			Reachability: strings.ToLower(evt.Reachability.String()),
+		}, true
+	case event.EvtLocalAddressesUpdated:
+		c := ConnectivityEvent{Kind: ConnectivityAddresses, Addrs: []string{}}
+		for _, addr := range evt.Current {
+			c.Addrs = append(c.Addrs, addr.Address.String())
+			if _, err := addr.Address.ValueForProtocol(multiaddr.P_CIRCUIT); err == nil {
+				c.Relayed = true
+			}
+		}
+		return c, true
+	}
+	return ConnectivityEvent{}, false
+}
+
 // retryAddedPeersLoop periodically retries connecting to added peers that are disconnected
 // Runs every 5 seconds and attempts DHT lookup + connection for disconnected added peers
 func (p *Peer) retryAddedPeersLoop() {
@@ -1530,21 +1978,9 @@ func (p *Peer) retryDisconnectedPeers() {
 // CRC: crc-Peer.md
 // Spec: main.md
 // Sequence: seq-dht-bootstrap.md
-func (p *Peer) bootstrapDHT(kdht *dht.IpfsDHT, h host.Host) {
-	// Connect to bootstrap peers
-	bootstrapPeers := dht.GetDefaultBootstrapPeerAddrInfos()
-	connected := 0
-	for _, peerinfo := range bootstrapPeers {
-		if err := h.Connect(p.ctx, peerinfo); err == nil {
-			connected++
-			p.logVerbose(3, "Connected to bootstrap peer %s", peerinfo.ID.String())
-		}
-		// Stop after connecting to 3 bootstrap nodes (sufficient for DHT)
-		if connected >= 3 {
-			break
-		}
-	}
-
+func (p *Peer) bootstrapDHT(kdht *dht.IpfsDHT) {
+	// Connect to bootstrap peers (custom or public, see SetNetwork)
+	connected := p.connectBootstrapPeers()
 	if connected == 0 {
 		p.logVerbose(1, "Warning: Failed to connect to any bootstrap peers")
 	} else {
@@ -1556,6 +1992,15 @@ func (p *Peer) bootstrapDHT(kdht *dht.IpfsDHT, h host.Host) {
 		p.logVerbose(1, "DHT bootstrap warning: %v", err)
 	}
 
+	// Without reachable bootstrap peers (air-gapped or test networks) the routing table is
+	// unlikely to fill, so don't make queued operations wait for the timeout
+	if connected == 0 && kdht.RoutingTable().Size() == 0 {
+		p.logVerbose(1, "No bootstrap peers reachable, proceeding without waiting for the DHT")
+		close(p.dhtReady)
+		p.processQueuedDHTOperations()
+		return
+	}
+
 	// Wait for DHT to have peers in routing table (up to 30 seconds)
 	// This ensures DHT operations (Advertise, FindPeers) will succeed
 	p.logVerbose(2, "Waiting for DHT routing table to populate...")
@@ -1641,12 +2086,24 @@ func (p *Peer) Monitor(topic string) error {
 		return nil
 	}
 
+	// Register for peer events on the topic (existing peers arrive as joins)
+	t, err := p.joinTopicLocked(topic)
+	if err != nil {
+		return err
+	}
+	events, err := t.EventHandler()
+	if err != nil {
+		p.leaveTopicLocked(topic)
+		return fmt.Errorf("failed to monitor topic: %w", err)
+	}
+
 	// Create monitor
 	ctx, cancel := context.WithCancel(p.ctx)
 	monitor := &TopicMonitor{
 		Topic:      topic,
 		ctx:        ctx,
 		cancel:     cancel,
+		events:     events,
 		knownPeers: make(map[string]bool),
 	}
 	p.monitoredTopics[topic] = monitor
@@ -1666,9 +2123,10 @@ func (p *Peer) StopMonitor(topic string) error {
 		return nil
 	}
 	delete(p.monitoredTopics, topic)
-	p.mu.Unlock()
-
 	monitor.cancel()
+	monitor.events.Cancel()
+	p.leaveTopicLocked(topic)
+	p.mu.Unlock()
 
 	return nil
 }
@@ -1684,13 +2142,15 @@ func (p *Peer) Close() error {
 	for _, handler := range p.topics {
 		handler.cancel()
 		handler.Subscription.Cancel()
-		handler.PubsubTopic.Close()
+		p.leaveTopicLocked(handler.Topic)
 	}
 	p.topics = make(map[string]*TopicHandler)
 
 	// Close all monitors
 	for _, monitor := range p.monitoredTopics {
 		monitor.cancel()
+		monitor.events.Cancel()
+		p.leaveTopicLocked(monitor.Topic)
 	}
 	p.monitoredTopics = make(map[string]*TopicMonitor)
 	p.mu.Unlock()
@@ -1773,7 +2233,7 @@ func (p *Peer) ListFiles(targetPeerID string) error {
 	}
 
 	p.logVerbose(2, "Opening stream to %s", targetPeerID)
-	stream, err := p.host.NewStream(p.ctx, targetPeer, protocol.ID(P2PWebAppProtocol))
+	stream, err := p.host.NewStream(p.ctx, targetPeer, p.manager.fileProtocol())
 	if err != nil {
 		// Remove handler on error
 		p.mu.Lock()
@@ -1877,12 +2337,12 @@ func (p *Peer) GetFile(cidStr, fallbackPeerID string) error {
 			// Detect MIME type
 			mimeType := http.DetectContentType(content)
 
-			// Return file content (base64-encoded for safe JSON transmission)
+			// Return raw file content (the protocol layer decides how to frame it)
 			if p.manager.onGotFile != nil {
 				p.manager.onGotFile(p.peerID.String(), cidStr, true, map[string]any{
 					"type":     "file",
 					"mimeType": mimeType,
-					"content":  base64.StdEncoding.EncodeToString(content),
+					"content":  content,
 				})
 			}
 
@@ -2127,7 +2587,7 @@ func (p *Peer) requestFileFromPeer(cidStr, fallbackPeerID string) error {
 	// Open stream to fallback peer with timeout
 	p.logVerbose(2, "Opening stream to fallback peer %s", fallbackPeerID)
 	streamCtx, streamCancel := context.WithTimeout(p.ctx, p.manager.streamTimeout)
-	stream, err := p.host.NewStream(streamCtx, targetPeer, protocol.ID(P2PWebAppProtocol))
+	stream, err := p.host.NewStream(streamCtx, targetPeer, p.manager.fileProtocol())
 	streamCancel()
 	if err != nil {
 		p.logVerbose(1, "Failed to open stream to fallback peer %s: %v", fallbackPeerID, err)
@@ -2143,8 +2603,8 @@ func (p *Peer) requestFileFromPeer(cidStr, fallbackPeerID string) error {
 		return fmt.Errorf("failed to write message type: %w", err)
 	}
 
-	// Send CID as JSON
-	msg := map[string]string{"cid": cidStr}
+	// Send CID as JSON, asking for raw binary frames instead of base64 content
+	msg := map[string]any{"cid": cidStr, "binary": true}
 	data, err := json.Marshal(msg)
 	if err != nil {
 		stream.Close()
@@ -2493,55 +2953,58 @@ func (p *Peer) readFromTopic(handler *TopicHandler) {
 			return
 		}
 
+		// Decrypt, dropping messages on encrypted topics that fail before they reach the client
+		plaintext, err := p.openTopicMessage(handler.Topic, msg.Data)
+		if err != nil {
+			p.logVerbose(2, "Dropped message on topic %s from %s: %v", handler.Topic, p.manager.getOrCreateAlias(msg.GetFrom().String()), err)
+			continue
+		}
+
 		// Decode JSON
 		var decoded any
-		if err := json.Unmarshal(msg.Data, &decoded); err != nil {
+		if err := json.Unmarshal(plaintext, &decoded); err != nil {
 			fmt.Printf("Error unmarshaling topic data: %v\n", err)
 			continue
 		}
 
-		if p.manager.onTopicData != nil {
-			p.manager.onTopicData(p.peerID.String(), handler.Topic, msg.GetFrom().String(), decoded)
+		meta := MessageMetadata{
+			ID:           base64.RawURLEncoding.EncodeToString([]byte(msg.ID)), // Raw IDs are binary
+			ReceivedFrom: msg.ReceivedFrom.String(),
+			ReceivedAt:   time.Now(),
+		}
+		if seqno := msg.GetSeqno(); len(seqno) == 8 {
+			meta.Seqno = binary.BigEndian.Uint64(seqno)
 		}
+
+		p.recordHistory(handler.Topic, msg, meta)
+		p.deliverTopicMessage(handler, heldMessage{from: msg.GetFrom().String(), data: decoded, meta: meta})
 	}
 }
 
+// monitorTopic reports gossipsub PeerJoin/PeerLeave events for a topic as they happen
 func (p *Peer) monitorTopic(monitor *TopicMonitor) {
-	ticker := time.NewTicker(1 * time.Second)
-	defer ticker.Stop()
-
 	for {
-		select {
-		case <-monitor.ctx.Done():
+		event, err := monitor.events.NextPeerEvent(monitor.ctx)
+		if err != nil {
+			// Monitor stopped or the peer closed
 			return
-		case <-ticker.C:
-			// Get current peers in topic
-			currentPeers := p.pubsub.ListPeers(monitor.Topic)
-
-			// Build current peer set
-			currentPeerSet := make(map[string]bool)
-			for _, pid := range currentPeers {
-				peerIDStr := pid.String()
-				currentPeerSet[peerIDStr] = true
-
-				// Check if this is a new peer (joined)
-				if !monitor.knownPeers[peerIDStr] {
-					monitor.knownPeers[peerIDStr] = true
-					if p.manager.onPeerChange != nil {
-						p.manager.onPeerChange(p.peerID.String(), monitor.Topic, peerIDStr, true)
-					}
-				}
-			}
+		}
 
-			// Check for peers that left
-			for peerIDStr := range monitor.knownPeers {
-				if !currentPeerSet[peerIDStr] {
-					delete(monitor.knownPeers, peerIDStr)
-					if p.manager.onPeerChange != nil {
-						p.manager.onPeerChange(p.peerID.String(), monitor.Topic, peerIDStr, false)
-					}
-				}
-			}
+		peerIDStr := event.Peer.String()
+		joined := event.Type == pubsub.PeerJoin
+
+		// Events can repeat (e.g. a join for a peer that was already seen), only report changes
+		monitor.mu.Lock()
+		changed := monitor.knownPeers[peerIDStr] != joined
+		if joined {
+			monitor.knownPeers[peerIDStr] = true
+		} else {
+			delete(monitor.knownPeers, peerIDStr)
+		}
+		monitor.mu.Unlock()
+
+		if changed && p.manager.onPeerChange != nil {
+			p.manager.onPeerChange(p.peerID.String(), monitor.Topic, peerIDStr, joined)
 		}
 	}
 }
@@ -2601,7 +3064,173 @@ func (m *Manager) SetGotFileCallback(cb func(receiverPeerID string, cid string,
 	m.onGotFile = cb
 }
 
-// Bootstrap connects to a bootstrap peer (helper method)
+// SetSendAckCallback sets the callback for remote delivery acknowledgements
+func (m *Manager) SetSendAckCallback(cb func(senderPeerID string, ack int)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onSendAck = cb
+}
+
+// notifySendAck reports that a remote peer acknowledged a message sent with an ack number
+func (m *Manager) notifySendAck(senderPeerID string, ack int) {
+	m.mu.RLock()
+	cb := m.onSendAck
+	m.mu.RUnlock()
+
+	if cb != nil {
+		cb(senderPeerID, ack)
+	}
+}
+
+// SetSendFailedCallback sets the callback for abandoned sends to unreachable peers
+func (m *Manager) SetSendFailedCallback(cb func(senderPeerID, targetPeerID, protocol string, acks []int, reason string)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onSendFailed = cb
+}
+
+// notifySendFailed reports messages abandoned after a peer became unreachable
+func (m *Manager) notifySendFailed(senderPeerID, targetPeerID, protocol string, acks []int, reason string) {
+	m.mu.RLock()
+	cb := m.onSendFailed
+	m.mu.RUnlock()
+
+	if cb != nil {
+		cb(senderPeerID, targetPeerID, protocol, acks, reason)
+	}
+}
+
+// SetPeerCallCallback sets the callback that answers calls from remote peers
+// The callback blocks until the receiving application replies or timeout expires
+func (m *Manager) SetPeerCallCallback(cb func(receiverPeerID, senderPeerID, protocol string, data any, timeout time.Duration) (any, error)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onPeerCall = cb
+}
+
+// handlePeerCall asks the receiving peer's application to answer a call
+func (m *Manager) handlePeerCall(receiverPeerID, senderPeerID, protocol string, data any, timeout time.Duration) (any, error) {
+	m.mu.RLock()
+	cb := m.onPeerCall
+	m.mu.RUnlock()
+
+	if cb == nil {
+		return nil, fmt.Errorf("no call handler for protocol %s", protocol)
+	}
+	// The timeout comes from the remote peer: cap it so callers can't hold a pending call indefinitely
+	if timeout <= 0 || (m.streamTimeout > 0 && timeout > m.streamTimeout) {
+		timeout = m.streamTimeout
+	}
+	return cb(receiverPeerID, senderPeerID, protocol, data, timeout)
+}
+
+// SetConnectivityCallback sets the callback for peer connection, reachability and address changes
+func (m *Manager) SetConnectivityCallback(cb func(peerID string, evt ConnectivityEvent)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onConnectivity = cb
+}
+
+// notifyConnectivity reports a connectivity change of a peer
+func (m *Manager) notifyConnectivity(peerID string, evt ConnectivityEvent) {
+	m.mu.RLock()
+	cb := m.onConnectivity
+	m.mu.RUnlock()
+
+	if cb != nil {
+		cb(peerID, evt)
+	}
+}
+
+// EnableQueuePersistence stores outbound message queues in ds so they survive restarts
+// Must be called before peers are created
+func (m *Manager) EnableQueuePersistence(ds datastore.Datastore) {
+	m.queueStore = newQueueStore(ds)
+}
+
+// SetQueueTTL sets how long queued messages are kept before they expire
+// perProtocol overrides defaultTTL for specific protocols; 0 disables expiry
+// Must be called before peers are created
+func (m *Manager) SetQueueTTL(defaultTTL time.Duration, perProtocol map[string]time.Duration) {
+	m.queueTTL = defaultTTL
+	m.queueTTLs = perProtocol
+}
+
+// SetQueueLimits bounds each (peer, protocol) queue and chooses what a send does when it is full
+// A limit of 0 is unlimited; policy is one of the QueuePolicy constants (empty = reject)
+// Must be called before peers are created
+func (m *Manager) SetQueueLimits(maxMessages int, maxBytes int64, policy string) {
+	m.queueMaxMessages = maxMessages
+	m.queueMaxBytes = maxBytes
+	m.queuePolicy = policy
+}
+
+// SendsMayBlock reports whether a send can wait for room in a full queue (the block policy)
+func (m *Manager) SendsMayBlock() bool {
+	return m.queuePolicy == QueuePolicyBlock
+}
+
+// SetTopicSchemas compiles the JSON Schemas that topic validators can reference by name
+// Must be called before peers are created
+func (m *Manager) SetTopicSchemas(schemas map[string]string) error {
+	compiled := make(map[string]*jsonSchema, len(schemas))
+	for name, schema := range schemas {
+		s, err := compileSchema([]byte(schema))
+		if err != nil {
+			return fmt.Errorf("invalid topic schema %s: %w", name, err)
+		}
+		compiled[name] = s
+	}
+	m.topicSchemas = compiled
+	return nil
+}
+
+// SetTopicHistory sets how many recent messages of each subscribed topic are kept for replay, and for how long
+// maxMessages 0 disables the history buffer; maxAge 0 keeps messages until they are pushed out
+// Must be called before peers are created
+func (m *Manager) SetTopicHistory(maxMessages int, maxAge time.Duration) {
+	m.historyMaxMessages = maxMessages
+	m.historyMaxAge = maxAge
+}
+
+// SetTransports sets the listen addresses, transports and announced addresses of peer hosts
+// Must be called before peers are created
+func (m *Manager) SetTransports(settings TransportSettings) error {
+	transports, err := parseTransportSettings(settings)
+	if err != nil {
+		return err
+	}
+	m.transports = transports
+	return nil
+}
+
+// SetNetwork sets the bootstrap peers, static relays, DHT mode and LAN-only mode of new peers
+// Must be called before peers are created
+func (m *Manager) SetNetwork(settings NetworkSettings) error {
+	network, err := parseNetworkSettings(settings)
+	if err != nil {
+		return err
+	}
+	m.network = network
+	return nil
+}
+
+// SetRelayService makes peer hosts serve as circuit relays for peers behind NATs
+// Must be called before peers are created
+func (m *Manager) SetRelayService(settings RelaySettings) {
+	m.relay = settings
+}
+
+// queueTTLFor returns the queue TTL for a protocol
+func (m *Manager) queueTTLFor(protocolStr string) time.Duration {
+	if ttl, ok := m.queueTTLs[protocolStr]; ok {
+		return ttl
+	}
+	return m.queueTTL
+}
+
+// Bootstrap connects a peer to a bootstrap peer given its multiaddr (ending in /p2p/<id>)
+// CRC: crc-PeerManager.md
 func (m *Manager) Bootstrap(peerID, bootstrapAddr string) error {
 	p, err := m.getPeer(peerID)
 	if err != nil {
@@ -2610,15 +3239,18 @@ func (m *Manager) Bootstrap(peerID, bootstrapAddr string) error {
 
 	addr, err := multiaddr.NewMultiaddr(bootstrapAddr)
 	if err != nil {
-		return err
+		return fmt.Errorf("invalid bootstrap address: %w", err)
 	}
 
 	peerInfo, err := peer.AddrInfoFromP2pAddr(addr)
 	if err != nil {
-		return err
+		return fmt.Errorf("invalid bootstrap address: %w", err)
 	}
 
-	return p.host.Connect(p.ctx, *peerInfo)
+	if err := p.host.Connect(p.ctx, *peerInfo); err != nil {
+		return fmt.Errorf("failed to connect to bootstrap peer: %w", err)
+	}
+	return nil
 }
 
 // handleP2PWebAppStream handles incoming streams on the p2p-webapp protocol
@@ -2626,7 +3258,8 @@ func (m *Manager) Bootstrap(peerID, bootstrapAddr string) error {
 func (p *Peer) handleP2PWebAppStream(stream network.Stream) {
 	defer stream.Close()
 
-	// Read message type (first byte: 0 = GetFileList, 1 = FileList, 2 = GetFile, 3 = FileContent)
+	// Read message type (first byte: 0 = GetFileList, 1 = FileList, 2 = GetFile, 3 = FileContent,
+	// 4 = GetTopicHistory, 5 = TopicHistory)
 	msgType := make([]byte, 1)
 	if _, err := io.ReadFull(stream, msgType); err != nil {
 		return
@@ -2643,6 +3276,11 @@ func (p *Peer) handleP2PWebAppStream(stream network.Stream) {
 		// Type 3 is handled by handleFileContent which is called from requestFileFromPeer
 		// This case should not be reached in normal flow
 		p.logVerbose(1, "handleP2PWebAppStream: unexpected FileContent message (type 3)")
+	case 4: // GetTopicHistory
+		p.handleGetTopicHistory(stream)
+	case 5: // TopicHistory
+		// Type 5 is read by requestTopicHistory on the stream it opened
+		p.logVerbose(1, "handleP2PWebAppStream: unexpected TopicHistory message (type 5)")
 	}
 }
 
@@ -2864,7 +3502,8 @@ func (p *Peer) handleGetFile(stream network.Stream) {
 
 	// Parse GetFile message
 	var msg struct {
-		CID string `json:"cid"`
+		CID    string `json:"cid"`
+		Binary bool   `json:"binary"` // Requester accepts raw binary frames (older peers send base64)
 	}
 	if err := json.Unmarshal(data, &msg); err != nil {
 		p.logVerbose(1, "handleGetFile: failed to unmarshal message: %v", err)
@@ -2926,7 +3565,7 @@ func (p *Peer) handleGetFile(stream network.Stream) {
 		mimeType := http.DetectContentType(content)
 
 		// Send file content response with raw node data for caching
-		p.sendFileContent(stream, msg.CID, content, mimeType, rawData, false, nil)
+		p.sendFileContent(stream, msg.CID, content, mimeType, rawData, false, nil, msg.Binary)
 
 	case unixfs.TDirectory, unixfs.THAMTShard:
 		// Build directory entries for client display
@@ -2950,7 +3589,7 @@ func (p *Peer) handleGetFile(stream network.Stream) {
 		}
 
 		// Send directory content response with raw node data for caching
-		p.sendFileContent(stream, msg.CID, nil, "", rawData, true, entries)
+		p.sendFileContent(stream, msg.CID, nil, "", rawData, true, entries, msg.Binary)
 
 	default:
 		p.logVerbose(1, "handleGetFile: unsupported file type")
@@ -2959,20 +3598,27 @@ func (p *Peer) handleGetFile(stream network.Stream) {
 }
 
 // sendFileContent sends a file content response (type 3) to the requesting peer
-func (p *Peer) sendFileContent(stream network.Stream, cidStr string, content []byte, mimeType string, rawNodeData []byte, isDirectory bool, entries map[string]string) {
-	p.logVerbose(2, "sendFileContent: sending response for CID=%s, isDirectory=%v, rawDataSize=%d", cidStr, isDirectory, len(rawNodeData))
+// In binary mode the raw node and content follow the JSON as raw frames instead of base64 fields
+func (p *Peer) sendFileContent(stream network.Stream, cidStr string, content []byte, mimeType string, rawNodeData []byte, isDirectory bool, entries map[string]string, binary bool) {
+	p.logVerbose(2, "sendFileContent: sending response for CID=%s, isDirectory=%v, rawDataSize=%d, binary=%v", cidStr, isDirectory, len(rawNodeData), binary)
 
 	// Create response message
 	response := map[string]any{
 		"cid":         cidStr,
 		"isDirectory": isDirectory,
-		"rawNode":     base64.StdEncoding.EncodeToString(rawNodeData), // Raw IPFS node data for caching
+	}
+	if binary {
+		response["binary"] = true
+	} else {
+		response["rawNode"] = base64.StdEncoding.EncodeToString(rawNodeData) // Raw IPFS node data for caching
 	}
 
 	if isDirectory {
 		response["entries"] = entries
 	} else {
-		response["content"] = base64.StdEncoding.EncodeToString(content)
+		if !binary {
+			response["content"] = base64.StdEncoding.EncodeToString(content)
+		}
 		response["mimeType"] = mimeType
 	}
 
@@ -2995,6 +3641,18 @@ func (p *Peer) sendFileContent(stream network.Stream, cidStr string, content []b
 		return
 	}
 
+	// Send raw node and content frames
+	if binary {
+		if err := writeMessage(stream, rawNodeData); err != nil {
+			p.logVerbose(1, "sendFileContent: failed to write raw node: %v", err)
+			return
+		}
+		if err := writeMessage(stream, content); err != nil {
+			p.logVerbose(1, "sendFileContent: failed to write content: %v", err)
+			return
+		}
+	}
+
 	p.logVerbose(2, "sendFileContent: successfully sent file content for %s", cidStr)
 }
 
@@ -3030,6 +3688,38 @@ func (p *Peer) sendFileError(stream network.Stream, cidStr, errorMsg string) {
 	p.logVerbose(2, "sendFileError: successfully sent error response")
 }
 
+// readFileContentData extracts the raw node and file content from a file content response
+// Binary responses carry them in the following frames, older peers send base64 fields
+// content is nil when the response carries none (directories)
+func readFileContentData(stream io.Reader, response map[string]any) (rawNodeData, content []byte, err error) {
+	if binary, _ := response["binary"].(bool); binary {
+		if rawNodeData, err = readMessage(stream); err != nil {
+			return nil, nil, fmt.Errorf("failed to read node data: %w", err)
+		}
+		if content, err = readMessage(stream); err != nil {
+			return nil, nil, fmt.Errorf("failed to read content: %w", err)
+		}
+		if isDirectory, _ := response["isDirectory"].(bool); isDirectory {
+			content = nil
+		}
+		return rawNodeData, content, nil
+	}
+
+	rawNodeStr, ok := response["rawNode"].(string)
+	if !ok {
+		return nil, nil, fmt.Errorf("missing node data in response")
+	}
+	if rawNodeData, err = base64.StdEncoding.DecodeString(rawNodeStr); err != nil {
+		return nil, nil, fmt.Errorf("invalid node data: %w", err)
+	}
+	if contentStr, ok := response["content"].(string); ok {
+		if content, err = base64.StdEncoding.DecodeString(contentStr); err != nil {
+			return nil, nil, fmt.Errorf("invalid content: %w", err)
+		}
+	}
+	return rawNodeData, content, nil
+}
+
 // handleFileContent processes a file content response from a fallback peer
 // Spec: main.md
 // CRC: crc-Peer.md
@@ -3084,21 +3774,12 @@ func (p *Peer) handleFileContent(stream network.Stream, originalCID string) {
 		return
 	}
 
-	// Get and decode raw IPFS node data for caching
-	rawNodeStr, ok := response["rawNode"].(string)
-	if !ok {
-		p.logVerbose(1, "handleFileContent: missing rawNode field in response")
-		if p.manager.onGotFile != nil {
-			p.manager.onGotFile(p.peerID.String(), originalCID, false, map[string]any{"error": "missing node data in response"})
-		}
-		return
-	}
-
-	rawNodeData, err := base64.StdEncoding.DecodeString(rawNodeStr)
+	// Get raw IPFS node data for caching, and file content
+	rawNodeData, content, err := readFileContentData(stream, response)
 	if err != nil {
-		p.logVerbose(1, "handleFileContent: failed to decode rawNode: %v", err)
+		p.logVerbose(1, "handleFileContent: %v", err)
 		if p.manager.onGotFile != nil {
-			p.manager.onGotFile(p.peerID.String(), originalCID, false, map[string]any{"error": fmt.Sprintf("invalid node data: %v", err)})
+			p.manager.onGotFile(p.peerID.String(), originalCID, false, map[string]any{"error": err.Error()})
 		}
 		return
 	}
@@ -3151,8 +3832,7 @@ func (p *Peer) handleFileContent(stream network.Stream, originalCID string) {
 		}
 	} else {
 		// File content - forward to callback
-		contentStr, ok := response["content"].(string)
-		if !ok {
+		if content == nil {
 			p.logVerbose(1, "handleFileContent: missing content field for file")
 			if p.manager.onGotFile != nil {
 				p.manager.onGotFile(p.peerID.String(), originalCID, false, map[string]any{"error": "missing content in response"})
@@ -3169,7 +3849,7 @@ func (p *Peer) handleFileContent(stream network.Stream, originalCID string) {
 			p.manager.onGotFile(p.peerID.String(), originalCID, true, map[string]any{
 				"type":     "file",
 				"mimeType": mimeType,
-				"content":  contentStr, // Send base64-encoded content to client
+				"content":  content, // Raw bytes, the protocol layer decides how to frame them
 			})
 		}
 	}
@@ -0,0 +1,130 @@
+package peer
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// GlobalPrefix marks a topic or protocol name that is shared with other apps instead of namespaced
+// "global:news" is the pubsub topic "news" whatever the app's namespace
+const GlobalPrefix = "global:"
+
+// maxNamespaceLength keeps the mDNS service name within a DNS label
+const maxNamespaceLength = 40
+
+// validNamespace matches lowercase DNS-label-safe namespaces
+var validNamespace = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// ValidateNamespace checks that a namespace can prefix mDNS service names, topics and protocols
+func ValidateNamespace(namespace string) error {
+	if len(namespace) > maxNamespaceLength || !validNamespace.MatchString(namespace) {
+		return fmt.Errorf("invalid namespace %q: use up to %d lowercase letters, digits and dashes", namespace, maxNamespaceLength)
+	}
+	return nil
+}
+
+// SetNamespace isolates this manager's peers from other apps
+// The namespace prefixes the mDNS service name, topics, user protocols and the file protocol;
+// fileProtocol (if not empty) replaces the namespaced file protocol name
+// An empty namespace shares everything with every other app
+// Must be called before peers are created
+func (m *Manager) SetNamespace(namespace, fileProtocol string) error {
+	if namespace != "" {
+		if err := ValidateNamespace(namespace); err != nil {
+			return err
+		}
+	}
+	m.namespace = namespace
+	m.fileProtocolName = fileProtocol
+	return nil
+}
+
+// mdnsServiceName returns the mDNS service name peers advertise and look for
+func (m *Manager) mdnsServiceName() string {
+	if m.namespace == "" {
+		return "p2p-webapp"
+	}
+	return m.namespace + "-p2p-webapp"
+}
+
+// fileProtocol returns the reserved protocol for file lists, files and topic history
+func (m *Manager) fileProtocol() protocol.ID {
+	if m.fileProtocolName != "" {
+		return protocol.ID(m.fileProtocolName)
+	}
+	if m.namespace == "" {
+		return P2PWebAppProtocol
+	}
+	return protocol.ID("/" + m.namespace + P2PWebAppProtocol)
+}
+
+// wireTopic returns the pubsub topic of an app topic
+func (m *Manager) wireTopic(topic string) string {
+	if global, ok := strings.CutPrefix(topic, GlobalPrefix); ok {
+		return global
+	}
+	if m.namespace == "" {
+		return topic
+	}
+	return m.namespace + "/" + topic
+}
+
+// wireProtocol returns the libp2p protocol of an app protocol
+// "chat" and "/chat/1.0.0" become /<namespace>/chat and /<namespace>/chat/1.0.0
+func (m *Manager) wireProtocol(protocolStr string) protocol.ID {
+	if global, ok := strings.CutPrefix(protocolStr, GlobalPrefix); ok {
+		return protocol.ID(global)
+	}
+	if m.namespace == "" {
+		return protocol.ID(protocolStr)
+	}
+	return protocol.ID("/" + m.namespace + "/" + strings.TrimPrefix(protocolStr, "/"))
+}
+
+// framedProtocolSuffix versions the libp2p protocol of streams whose messages are a header frame and a body frame
+// Streams on the bare wire protocol carry one JSON frame per message, as peers predating binary payloads send
+const framedProtocolSuffix = "/p2p-webapp-frames/1"
+
+// streamProtocols returns the libp2p protocols of an app protocol's streams, framed first so it is preferred
+func (m *Manager) streamProtocols(protocolStr string) []protocol.ID {
+	pid := m.wireProtocol(protocolStr)
+	return []protocol.ID{pid + framedProtocolSuffix, pid}
+}
+
+// isFramed reports whether a stream's messages are a header frame and a body frame
+func isFramed(pid protocol.ID) bool {
+	return strings.HasSuffix(string(pid), framedProtocolSuffix)
+}
+
+// appProtocol returns the app protocol of an incoming stream's libp2p protocol (the reverse of wireProtocol)
+// Namespaced protocols come back with a leading slash, since "chat" and "/chat" share a libp2p protocol
+func (m *Manager) appProtocol(pid protocol.ID) string {
+	pid = protocol.ID(strings.TrimSuffix(string(pid), framedProtocolSuffix))
+	if m.namespace == "" {
+		return string(pid)
+	}
+	if local, ok := strings.CutPrefix(string(pid), "/"+m.namespace+"/"); ok {
+		return "/" + local
+	}
+	return GlobalPrefix + string(pid)
+}
+
+// startedProtocol returns the app protocol of an incoming stream, spelled the way this peer started it
+// Falls back to appProtocol when the peer didn't start the protocol without its leading slash
+func (p *Peer) startedProtocol(pid protocol.ID) string {
+	app := p.manager.appProtocol(pid)
+	bare, ok := strings.CutPrefix(app, "/")
+	if !ok || p.manager.namespace == "" {
+		return app
+	}
+	p.mu.RLock()
+	_, startedBare := p.protocols[protocol.ID(bare)]
+	p.mu.RUnlock()
+	if startedBare {
+		return bare
+	}
+	return app
+}
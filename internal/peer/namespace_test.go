@@ -0,0 +1,74 @@
+package peer
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// TestNamespaceNames tests how app topics and protocols map to network names
+func TestNamespaceNames(t *testing.T) {
+	shared := &Manager{}
+	if shared.wireTopic("room") != "room" || shared.wireProtocol("/chat/1.0.0") != "/chat/1.0.0" ||
+		shared.fileProtocol() != P2PWebAppProtocol || shared.mdnsServiceName() != "p2p-webapp" {
+		t.Error("Expected unchanged names without a namespace")
+	}
+
+	m := &Manager{}
+	if err := m.SetNamespace("chat-app", ""); err != nil {
+		t.Fatalf("Failed to set namespace: %v", err)
+	}
+	for topic, want := range map[string]string{
+		"room":                 "chat-app/room",
+		GlobalPrefix + "news":  "news",
+		GlobalPrefix + "x/abc": "x/abc",
+	} {
+		if got := m.wireTopic(topic); got != want {
+			t.Errorf("Expected topic %q to be %q, got %q", topic, want, got)
+		}
+	}
+
+	for app, wire := range map[string]protocol.ID{
+		"/chat":                       "/chat-app/chat",
+		"/chat/1.0.0":                 "/chat-app/chat/1.0.0",
+		GlobalPrefix + "/files/1.0.0": "/files/1.0.0",
+	} {
+		if got := m.wireProtocol(app); got != wire {
+			t.Errorf("Expected protocol %q to be %q, got %q", app, wire, got)
+		}
+		if got := m.appProtocol(wire); got != app {
+			t.Errorf("Expected incoming protocol %q to be %q, got %q", wire, app, got)
+		}
+		if got := m.appProtocol(wire + framedProtocolSuffix); got != app {
+			t.Errorf("Expected incoming framed protocol %q to be %q, got %q", wire+framedProtocolSuffix, app, got)
+		}
+	}
+
+	// "chat" shares /chat-app/chat with "/chat"; incoming streams use the spelling the peer started
+	if m.wireProtocol("chat") != "/chat-app/chat" {
+		t.Errorf("Expected protocol chat to be /chat-app/chat, got %q", m.wireProtocol("chat"))
+	}
+	p := &Peer{manager: m, protocols: map[protocol.ID]*ProtocolHandler{"chat": {}}}
+	if got := p.startedProtocol("/chat-app/chat" + framedProtocolSuffix); got != "chat" {
+		t.Errorf("Expected the started protocol chat, got %q", got)
+	}
+	if got := p.startedProtocol("/chat-app/chat/1.0.0"); got != "/chat/1.0.0" {
+		t.Errorf("Expected /chat/1.0.0 for a protocol the peer didn't start, got %q", got)
+	}
+
+	if m.fileProtocol() != "/chat-app/p2p-webapp/1.0.0" || m.mdnsServiceName() != "chat-app-p2p-webapp" {
+		t.Errorf("Expected namespaced file protocol and mDNS name, got %q and %q", m.fileProtocol(), m.mdnsServiceName())
+	}
+	if err := m.SetNamespace("chat-app", "/custom/1.0.0"); err != nil || m.fileProtocol() != "/custom/1.0.0" {
+		t.Errorf("Expected the configured file protocol, got %q (%v)", m.fileProtocol(), err)
+	}
+}
+
+// TestNamespaceValidation tests namespace checks
+func TestNamespaceValidation(t *testing.T) {
+	for _, ns := range []string{"Chat", "chat/app", "-chat", "chat-", "a.b"} {
+		if err := (&Manager{}).SetNamespace(ns, ""); err == nil {
+			t.Errorf("Expected namespace %q to be rejected", ns)
+		}
+	}
+}
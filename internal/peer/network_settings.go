@@ -0,0 +1,198 @@
+package peer
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/peer"
+	relayv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// DHT modes
+const (
+	DHTOff    = "off"    // No DHT: peers are found with mDNS, the manager's own peers and bootstrap peers
+	DHTClient = "client" // Query the DHT without serving it
+	DHTServer = "server" // Query and serve the DHT
+	DHTAuto   = "auto"   // Serve the DHT once the host is publicly reachable (default)
+)
+
+// maxBootstrapConnections is how many bootstrap peers a new peer connects to
+const maxBootstrapConnections = 3
+
+// NetworkSettings selects how peers find each other
+type NetworkSettings struct {
+	BootstrapPeers []string // Multiaddrs ending in /p2p/<id> (empty = the public IPFS bootstrap nodes)
+	DHTMode        string   // DHTOff, DHTClient, DHTServer or DHTAuto (empty = DHTAuto)
+	LANOnly        bool     // Only mDNS and the manager's own peers: no DHT, bootstrap peers, relays or NAT traversal
+	StaticRelays   []string // Multiaddrs ending in /p2p/<id> of relays AutoRelay reserves slots on
+}
+
+// RelaySettings configure the circuit relay v2 service peers run for peers behind NATs
+// Zero limits use the libp2p defaults
+type RelaySettings struct {
+	Enabled              bool
+	ForcePublic          bool // Serve at once instead of waiting for AutoNAT to report the host publicly reachable
+	MaxReservations      int  // Peers holding a relay slot at once
+	MaxCircuits          int  // Open relayed connections per peer
+	MaxReservationsPerIP int
+	ReservationTTL       time.Duration
+	ConnectionDuration   time.Duration // How long a relayed connection lasts before it is reset
+	ConnectionData       int64         // Bytes relayed in each direction before the connection is reset
+}
+
+// networkConfig is NetworkSettings parsed by SetNetwork
+// The zero value is the default: public bootstrap nodes and an auto DHT
+type networkConfig struct {
+	bootstrap    []peer.AddrInfo // Custom bootstrap peers (nil = public IPFS bootstrap nodes)
+	staticRelays []peer.AddrInfo
+	dhtMode      string
+	lanOnly      bool
+}
+
+// parseNetworkSettings checks settings and parses the bootstrap addresses
+func parseNetworkSettings(s NetworkSettings) (networkConfig, error) {
+	c := networkConfig{dhtMode: s.DHTMode, lanOnly: s.LANOnly}
+	switch s.DHTMode {
+	case "":
+		c.dhtMode = DHTAuto
+	case DHTOff, DHTClient, DHTServer, DHTAuto:
+	default:
+		return networkConfig{}, fmt.Errorf("unknown DHT mode: %s", s.DHTMode)
+	}
+	if s.LANOnly {
+		c.dhtMode = DHTOff
+		if len(s.BootstrapPeers) > 0 {
+			return networkConfig{}, fmt.Errorf("bootstrap peers can't be used in LAN-only mode")
+		}
+		if len(s.StaticRelays) > 0 {
+			return networkConfig{}, fmt.Errorf("static relays can't be used in LAN-only mode")
+		}
+	}
+
+	var err error
+	if c.bootstrap, err = parsePeerAddrs(s.BootstrapPeers, "bootstrap"); err != nil {
+		return networkConfig{}, err
+	}
+	if c.staticRelays, err = parsePeerAddrs(s.StaticRelays, "relay"); err != nil {
+		return networkConfig{}, err
+	}
+	return c, nil
+}
+
+// parsePeerAddrs parses multiaddrs ending in /p2p/<id>, returning nil for none
+func parsePeerAddrs(strs []string, kind string) ([]peer.AddrInfo, error) {
+	var infos []peer.AddrInfo
+	for _, str := range strs {
+		addr, err := multiaddr.NewMultiaddr(str)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s address %q: %w", kind, str, err)
+		}
+		info, err := peer.AddrInfoFromP2pAddr(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s address %q: %w", kind, str, err)
+		}
+		infos = append(infos, *info)
+	}
+	return infos, nil
+}
+
+// bootstrapPeers returns the peers a new peer connects to at startup
+// Without a DHT, only custom bootstrap peers are worth connecting to
+func (c networkConfig) bootstrapPeers() []peer.AddrInfo {
+	switch {
+	case c.lanOnly:
+		return nil
+	case c.bootstrap != nil:
+		return c.bootstrap
+	case c.dhtMode == DHTOff:
+		return nil
+	default:
+		return dht.GetDefaultBootstrapPeerAddrInfos()
+	}
+}
+
+// dhtOptions returns the DHT options for a new peer, or false when the DHT is off
+func (c networkConfig) dhtOptions() ([]dht.Option, bool) {
+	var opts []dht.Option
+	switch c.dhtMode {
+	case DHTOff:
+		return nil, false
+	case DHTClient:
+		opts = append(opts, dht.Mode(dht.ModeClient))
+	case DHTServer:
+		opts = append(opts, dht.Mode(dht.ModeServer))
+	default:
+		opts = append(opts, dht.Mode(dht.ModeAutoServer))
+	}
+	if c.bootstrap != nil {
+		opts = append(opts, dht.BootstrapPeers(c.bootstrap...))
+	}
+	return opts, true
+}
+
+// natOptions returns the libp2p options for reaching peers behind NATs
+// LAN-only peers don't need them and never contact public relays
+func (c networkConfig) natOptions() []libp2p.Option {
+	if c.lanOnly {
+		return nil
+	}
+	return []libp2p.Option{
+		libp2p.EnableAutoRelayWithStaticRelays(c.staticRelays), // Reserve slots on the configured relays
+		libp2p.NATPortMap(),         // Try NAT port mapping
+		libp2p.EnableNATService(),   // Help other peers with NAT detection
+		libp2p.EnableHolePunching(), // Enable hole punching for direct connections
+	}
+}
+
+// hostOptions returns the libp2p options for serving as a relay, or none when the service is disabled
+func (s RelaySettings) hostOptions() []libp2p.Option {
+	if !s.Enabled {
+		return nil
+	}
+	resources := relayv2.DefaultResources()
+	if s.MaxReservations > 0 {
+		resources.MaxReservations = s.MaxReservations
+	}
+	if s.MaxCircuits > 0 {
+		resources.MaxCircuits = s.MaxCircuits
+	}
+	if s.MaxReservationsPerIP > 0 {
+		resources.MaxReservationsPerIP = s.MaxReservationsPerIP
+	}
+	if s.ReservationTTL > 0 {
+		resources.ReservationTTL = s.ReservationTTL
+	}
+	if s.ConnectionDuration > 0 {
+		resources.Limit.Duration = s.ConnectionDuration
+	}
+	if s.ConnectionData > 0 {
+		resources.Limit.Data = s.ConnectionData
+	}
+
+	opts := []libp2p.Option{libp2p.EnableRelayService(relayv2.WithResources(resources))}
+	if s.ForcePublic {
+		// The relay service only starts once the host is known to be publicly reachable
+		opts = append(opts, libp2p.ForceReachabilityPublic())
+	}
+	return opts
+}
+
+// connectBootstrapPeers connects to up to maxBootstrapConnections of the configured bootstrap peers
+// Returns the number of peers connected
+func (p *Peer) connectBootstrapPeers() int {
+	connected := 0
+	for _, peerinfo := range p.manager.network.bootstrapPeers() {
+		if err := p.host.Connect(p.ctx, peerinfo); err == nil {
+			connected++
+			p.logVerbose(3, "Connected to bootstrap peer %s", peerinfo.ID.String())
+		}
+		// Stop after connecting to 3 bootstrap nodes (sufficient for DHT)
+		if connected >= maxBootstrapConnections {
+			break
+		}
+	}
+	return connected
+}
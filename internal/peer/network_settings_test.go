@@ -0,0 +1,87 @@
+package peer
+
+import (
+	"testing"
+)
+
+const testBootstrapAddr = "/ip4/192.168.1.20/tcp/4001/p2p/12D3KooWGzxzKZYveHXtpG6AsrUJBcWxHBFS2HsEoGTxrMLvKXtf"
+
+// TestParseNetworkSettings tests DHT mode, bootstrap and LAN-only checks
+func TestParseNetworkSettings(t *testing.T) {
+	for _, settings := range []NetworkSettings{
+		{DHTMode: "sometimes"},
+		{BootstrapPeers: []string{"/ip4/192.168.1.20/tcp/4001"}}, // No peer ID
+		{LANOnly: true, BootstrapPeers: []string{testBootstrapAddr}},
+		{StaticRelays: []string{"/ip4/192.168.1.20/tcp/4001"}}, // No peer ID
+		{LANOnly: true, StaticRelays: []string{testBootstrapAddr}},
+	} {
+		if _, err := parseNetworkSettings(settings); err == nil {
+			t.Errorf("Expected an error for %+v", settings)
+		}
+	}
+
+	c, err := parseNetworkSettings(NetworkSettings{})
+	if err != nil {
+		t.Fatalf("Failed to parse empty settings: %v", err)
+	}
+	if _, enabled := c.dhtOptions(); !enabled || c.dhtMode != DHTAuto {
+		t.Errorf("Expected an auto DHT by default, got %q", c.dhtMode)
+	}
+	if len(c.bootstrapPeers()) == 0 {
+		t.Error("Expected the public bootstrap nodes by default")
+	}
+	if len(c.natOptions()) == 0 {
+		t.Error("Expected NAT traversal by default")
+	}
+}
+
+// TestBootstrapPeerSelection tests which bootstrap peers each mode dials
+func TestBootstrapPeerSelection(t *testing.T) {
+	custom, err := parseNetworkSettings(NetworkSettings{BootstrapPeers: []string{testBootstrapAddr}, DHTMode: DHTOff})
+	if err != nil {
+		t.Fatalf("Failed to parse settings: %v", err)
+	}
+	if _, enabled := custom.dhtOptions(); enabled {
+		t.Error("Expected no DHT in off mode")
+	}
+	if peers := custom.bootstrapPeers(); len(peers) != 1 || peers[0].ID.String() != "12D3KooWGzxzKZYveHXtpG6AsrUJBcWxHBFS2HsEoGTxrMLvKXtf" {
+		t.Errorf("Expected only the custom bootstrap peer, got %v", peers)
+	}
+
+	off, _ := parseNetworkSettings(NetworkSettings{DHTMode: DHTOff})
+	if peers := off.bootstrapPeers(); len(peers) != 0 {
+		t.Errorf("Expected no public bootstrap nodes without a DHT, got %d", len(peers))
+	}
+
+	lan, err := parseNetworkSettings(NetworkSettings{LANOnly: true, DHTMode: DHTServer})
+	if err != nil {
+		t.Fatalf("Failed to parse LAN-only settings: %v", err)
+	}
+	if _, enabled := lan.dhtOptions(); enabled {
+		t.Error("Expected LAN-only mode to turn off the DHT")
+	}
+	if len(lan.bootstrapPeers()) != 0 || len(lan.natOptions()) != 0 {
+		t.Error("Expected LAN-only mode to skip bootstrap nodes and NAT traversal")
+	}
+}
+
+// TestRelaySettings tests static relays and the relay service options
+func TestRelaySettings(t *testing.T) {
+	c, err := parseNetworkSettings(NetworkSettings{StaticRelays: []string{testBootstrapAddr}})
+	if err != nil {
+		t.Fatalf("Failed to parse static relays: %v", err)
+	}
+	if len(c.staticRelays) != 1 || c.staticRelays[0].ID.String() != "12D3KooWGzxzKZYveHXtpG6AsrUJBcWxHBFS2HsEoGTxrMLvKXtf" {
+		t.Errorf("Expected one static relay, got %v", c.staticRelays)
+	}
+
+	if opts := (RelaySettings{MaxReservations: 10}).hostOptions(); len(opts) != 0 {
+		t.Errorf("Expected no relay service unless enabled, got %d options", len(opts))
+	}
+	if opts := (RelaySettings{Enabled: true}).hostOptions(); len(opts) != 1 {
+		t.Errorf("Expected the relay service option, got %d options", len(opts))
+	}
+	if opts := (RelaySettings{Enabled: true, ForcePublic: true}).hostOptions(); len(opts) != 2 {
+		t.Errorf("Expected the relay service with forced public reachability, got %d options", len(opts))
+	}
+}
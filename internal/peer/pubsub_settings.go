@@ -0,0 +1,190 @@
+package peer
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	discoveryrouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
+)
+
+// Pubsub routers
+const (
+	RouterGossipSub = "gossipsub"
+	RouterFloodSub  = "floodsub"
+	RouterRandomSub = "randomsub"
+)
+
+// Message signature policies
+const (
+	SignStrict   = "strictSign"   // Messages are signed by their author, unsigned messages are dropped
+	SignStrictNo = "strictNoSign" // Messages are anonymous, signed messages are dropped
+)
+
+// PubsubSettings tunes the pubsub router of every peer
+// Zero numbers use the pubsub library defaults
+type PubsubSettings struct {
+	Router                string             // RouterGossipSub (default), RouterFloodSub or RouterRandomSub
+	D                     int                // GossipSub: target mesh degree
+	Dlo                   int                // GossipSub: mesh degree below which peers are grafted
+	Dhi                   int                // GossipSub: mesh degree above which peers are pruned
+	Dout                  int                // GossipSub: outbound connections kept in the mesh
+	Dscore                int                // GossipSub: peers kept by score when pruning
+	Dlazy                 int                // GossipSub: peers gossiped to outside the mesh
+	HeartbeatInitialDelay time.Duration      // GossipSub: delay before the first heartbeat
+	HeartbeatInterval     time.Duration      // GossipSub: time between heartbeats
+	FloodPublish          bool               // GossipSub: publish to every topic peer, not just the mesh
+	PeerExchange          bool               // GossipSub: send peer lists when pruning
+	SignaturePolicy       string             // SignStrict (default) or SignStrictNo
+	MaxMessageSize        int                // Largest message accepted or published in bytes
+	RandomSubSize         int                // RandomSub: expected network size
+	Scoring               *PeerScoreSettings // GossipSub: peer scoring thresholds (nil = scoring off)
+}
+
+// PeerScoreSettings are the gossipsub score thresholds below which peers lose privileges
+type PeerScoreSettings struct {
+	GossipThreshold             float64 // No gossip to or from the peer (<= 0)
+	PublishThreshold            float64 // Not flood published to (<= GossipThreshold)
+	GraylistThreshold           float64 // Messages from the peer are ignored (<= PublishThreshold)
+	AcceptPXThreshold           float64 // Peer exchange is accepted from peers above this (>= 0)
+	OpportunisticGraftThreshold float64 // Median mesh score that triggers grafting better peers (>= 0)
+}
+
+// DefaultPubsubSettings returns GossipSub tuned for quick mesh formation in small networks
+func DefaultPubsubSettings() PubsubSettings {
+	params := pubsub.DefaultGossipSubParams()
+	return PubsubSettings{
+		Router:                RouterGossipSub,
+		D:                     params.D,
+		Dlo:                   params.Dlo,
+		Dhi:                   params.Dhi,
+		Dout:                  params.Dout,
+		Dscore:                params.Dscore,
+		Dlazy:                 params.Dlazy,
+		HeartbeatInitialDelay: 50 * time.Millisecond,
+		HeartbeatInterval:     500 * time.Millisecond,
+		FloodPublish:          true,
+		PeerExchange:          true,
+		SignaturePolicy:       SignStrict,
+		RandomSubSize:         pubsub.RandomSubD,
+	}
+}
+
+// gossipSubParams returns the library defaults overridden by the non-zero settings
+func (s PubsubSettings) gossipSubParams() pubsub.GossipSubParams {
+	params := pubsub.DefaultGossipSubParams()
+	override := func(value int, param *int) {
+		if value > 0 {
+			*param = value
+		}
+	}
+	override(s.D, &params.D)
+	override(s.Dlo, &params.Dlo)
+	override(s.Dhi, &params.Dhi)
+	override(s.Dout, &params.Dout)
+	override(s.Dscore, &params.Dscore)
+	override(s.Dlazy, &params.Dlazy)
+	if s.HeartbeatInitialDelay > 0 {
+		params.HeartbeatInitialDelay = s.HeartbeatInitialDelay
+	}
+	if s.HeartbeatInterval > 0 {
+		params.HeartbeatInterval = s.HeartbeatInterval
+	}
+	return params
+}
+
+// contentMessageID identifies anonymous messages by their content, since they have no author and sequence number
+func contentMessageID(msg *pb.Message) string {
+	sum := sha256.Sum256(msg.Data)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// messageID returns the ID pubsub gives a message under the manager's signature policy
+func (m *Manager) messageID(msg *pb.Message) string {
+	if m.pubsubSettings.SignaturePolicy == SignStrictNo {
+		return contentMessageID(msg)
+	}
+	return pubsub.DefaultMsgIdFn(msg)
+}
+
+// newPubsub creates a peer's pubsub router from the manager's settings
+// kdht (if not nil) is used for topic peer discovery; directPeers are kept in every GossipSub mesh
+func (m *Manager) newPubsub(h host.Host, kdht *dht.IpfsDHT, directPeers []peer.AddrInfo) (*pubsub.PubSub, error) {
+	s := m.pubsubSettings
+	var opts []pubsub.Option
+
+	if kdht != nil {
+		// Use DHT for topic-based peer discovery (enables global connectivity)
+		opts = append(opts, pubsub.WithDiscovery(discoveryrouting.NewRoutingDiscovery(kdht)))
+	}
+	if s.MaxMessageSize > 0 {
+		opts = append(opts, pubsub.WithMaxMessageSize(s.MaxMessageSize))
+	}
+	if m.blocklist != nil {
+		// Ignore messages from blocked peers on every topic, alongside the topic's own validator
+		opts = append(opts, pubsub.WithDefaultValidator(m.blocklist.validate))
+	}
+
+	switch s.SignaturePolicy {
+	case "", SignStrict:
+		opts = append(opts, pubsub.WithMessageSignaturePolicy(pubsub.StrictSign))
+	case SignStrictNo:
+		opts = append(opts,
+			pubsub.WithMessageSignaturePolicy(pubsub.StrictNoSign),
+			pubsub.WithNoAuthor(),
+			pubsub.WithMessageIdFn(contentMessageID),
+		)
+	default:
+		return nil, fmt.Errorf("unknown signature policy: %s", s.SignaturePolicy)
+	}
+
+	switch s.Router {
+	case "", RouterGossipSub:
+		opts = append(opts,
+			pubsub.WithPeerExchange(s.PeerExchange),
+			pubsub.WithFloodPublish(s.FloodPublish),
+			pubsub.WithGossipSubParams(s.gossipSubParams()),
+			pubsub.WithDirectPeers(directPeers), // Guarantee mesh inclusion for localhost peers
+		)
+		if s.Scoring != nil {
+			opts = append(opts, pubsub.WithPeerScore(peerScoreParams(), &pubsub.PeerScoreThresholds{
+				GossipThreshold:             s.Scoring.GossipThreshold,
+				PublishThreshold:            s.Scoring.PublishThreshold,
+				GraylistThreshold:           s.Scoring.GraylistThreshold,
+				AcceptPXThreshold:           s.Scoring.AcceptPXThreshold,
+				OpportunisticGraftThreshold: s.Scoring.OpportunisticGraftThreshold,
+			}))
+		}
+		return pubsub.NewGossipSub(m.ctx, h, opts...)
+	case RouterFloodSub:
+		return pubsub.NewFloodSub(m.ctx, h, opts...)
+	case RouterRandomSub:
+		size := s.RandomSubSize
+		if size <= 0 {
+			size = pubsub.RandomSubD
+		}
+		return pubsub.NewRandomSub(m.ctx, h, size, opts...)
+	default:
+		return nil, fmt.Errorf("unknown pubsub router: %s", s.Router)
+	}
+}
+
+// peerScoreParams scores peers on protocol misbehaviour (broken promises, early re-grafts)
+// IP colocation is not penalized because every peer of a manager shares the server's address
+func peerScoreParams() *pubsub.PeerScoreParams {
+	return &pubsub.PeerScoreParams{
+		AppSpecificScore:          func(peer.ID) float64 { return 0 },
+		BehaviourPenaltyWeight:    -10,
+		BehaviourPenaltyThreshold: 6,
+		BehaviourPenaltyDecay:     pubsub.ScoreParameterDecay(10 * time.Minute),
+		DecayInterval:             pubsub.DefaultDecayInterval,
+		DecayToZero:               pubsub.DefaultDecayToZero,
+		RetainScore:               time.Hour,
+	}
+}
@@ -0,0 +1,61 @@
+package peer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+)
+
+// TestGossipSubParams tests that set mesh parameters override the library defaults
+func TestGossipSubParams(t *testing.T) {
+	params := PubsubSettings{D: 3, Dlo: 2, Dhi: 4, Dscore: 2, HeartbeatInterval: time.Second}.gossipSubParams()
+	if params.D != 3 || params.Dlo != 2 || params.Dhi != 4 || params.Dscore != 2 || params.HeartbeatInterval != time.Second {
+		t.Errorf("Expected overridden mesh parameters, got D=%d Dlo=%d Dhi=%d Dscore=%d heartbeat=%v",
+			params.D, params.Dlo, params.Dhi, params.Dscore, params.HeartbeatInterval)
+	}
+	if defaults := DefaultPubsubSettings().gossipSubParams(); defaults.Dout != 2 || defaults.HeartbeatInitialDelay != 50*time.Millisecond {
+		t.Errorf("Expected default Dout=2 and initial heartbeat 50ms, got %d and %v", defaults.Dout, defaults.HeartbeatInitialDelay)
+	}
+}
+
+// TestNewPubsubRouters tests that each configured router can be created
+func TestNewPubsubRouters(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	scoring := &PeerScoreSettings{GossipThreshold: -100, PublishThreshold: -500, GraylistThreshold: -1000}
+	for _, settings := range []PubsubSettings{
+		DefaultPubsubSettings(),
+		{Router: RouterGossipSub, Scoring: scoring, SignaturePolicy: SignStrictNo},
+		{Router: RouterFloodSub, MaxMessageSize: 4096},
+		{Router: RouterRandomSub, RandomSubSize: 10},
+	} {
+		h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+		if err != nil {
+			t.Fatalf("Failed to create libp2p host: %v", err)
+		}
+		defer h.Close()
+
+		manager := &Manager{ctx: ctx, pubsubSettings: settings}
+		ps, err := manager.newPubsub(h, nil, nil)
+		if err != nil {
+			t.Errorf("Failed to create %s router: %v", settings.Router, err)
+			continue
+		}
+		if _, err := ps.Join("room"); err != nil {
+			t.Errorf("Failed to join a topic with the %s router: %v", settings.Router, err)
+		}
+	}
+
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatalf("Failed to create libp2p host: %v", err)
+	}
+	defer h.Close()
+	manager := &Manager{ctx: ctx, pubsubSettings: PubsubSettings{Router: "carrier-pigeon"}}
+	if _, err := manager.newPubsub(h, nil, nil); err == nil {
+		t.Errorf("Expected an unknown router to fail")
+	}
+}
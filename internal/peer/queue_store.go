@@ -0,0 +1,95 @@
+package peer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+)
+
+// QueueStoreDirName is the datastore directory of persisted outbound queues in a site's storage directory
+const QueueStoreDirName = "queues"
+
+// queueStoreNamespace is the datastore prefix for persisted outbound messages
+const queueStoreNamespace = "/p2p-webapp/queues"
+
+// queueStore persists queued messages in the datastore so they survive restarts
+// Keys: /<sender peer>/<target peer>/<escaped protocol>/<message ID>
+type queueStore struct {
+	ds datastore.Datastore
+}
+
+// persistedMessage is the stored form of a QueuedMessage
+type persistedMessage struct {
+	Peer      string    `json:"peer"`
+	Protocol  string    `json:"protocol"`
+	ID        string    `json:"id"`
+	Data      []byte    `json:"data"`
+	Timestamp time.Time `json:"timestamp"`
+	Binary    bool      `json:"binary,omitempty"`
+}
+
+// newQueueStore wraps a datastore in the queue namespace
+func newQueueStore(ds datastore.Datastore) *queueStore {
+	return &queueStore{ds: namespace.Wrap(ds, datastore.NewKey(queueStoreNamespace))}
+}
+
+// messageKey builds the datastore key for a queued message
+func (s *queueStore) messageKey(senderPeerID, targetPeerID, protocolStr, msgID string) datastore.Key {
+	return datastore.NewKey(senderPeerID).
+		ChildString(targetPeerID).
+		ChildString(url.PathEscape(protocolStr)).
+		ChildString(msgID)
+}
+
+// put stores a queued message
+func (s *queueStore) put(ctx context.Context, senderPeerID, targetPeerID, protocolStr string, msg QueuedMessage) error {
+	data, err := json.Marshal(persistedMessage{
+		Peer:      targetPeerID,
+		Protocol:  protocolStr,
+		ID:        msg.id,
+		Data:      msg.data,
+		Timestamp: msg.timestamp,
+		Binary:    msg.binary,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal queued message: %w", err)
+	}
+	return s.ds.Put(ctx, s.messageKey(senderPeerID, targetPeerID, protocolStr, msg.id), data)
+}
+
+// delete removes a queued message once it is delivered, abandoned or expired
+func (s *queueStore) delete(ctx context.Context, senderPeerID, targetPeerID, protocolStr, msgID string) error {
+	return s.ds.Delete(ctx, s.messageKey(senderPeerID, targetPeerID, protocolStr, msgID))
+}
+
+// load returns every stored message for a sending peer (callers order by timestamp)
+func (s *queueStore) load(ctx context.Context, senderPeerID string) ([]persistedMessage, error) {
+	results, err := s.ds.Query(ctx, query.Query{
+		Prefix: datastore.NewKey(senderPeerID).String(),
+		Orders: []query.Order{query.OrderByKey{}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query queued messages: %w", err)
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read queued messages: %w", err)
+	}
+
+	messages := make([]persistedMessage, 0, len(entries))
+	for _, entry := range entries {
+		var msg persistedMessage
+		if err := json.Unmarshal(entry.Value, &msg); err != nil {
+			// Skip corrupt entries rather than failing the whole reload
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
@@ -0,0 +1,174 @@
+package peer
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// GroupKeySize is the size of a group key in bytes (AES-256-GCM)
+const GroupKeySize = 32
+
+// encryptedPayload is the pubsub payload of a message on an encrypted topic
+// The topic name is the AEAD associated data, so a message can't be replayed onto another topic
+type encryptedPayload struct {
+	KeyID      string `json:"p2pWebappKeyId"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// topicKeys holds the group keys of an encrypted topic
+type topicKeys struct {
+	current  string          // Key ID used to publish
+	accepted map[string]bool // Key IDs messages are decrypted with, including current
+}
+
+// GroupKeyID derives a group key's ID, so every peer holding the key agrees on it
+func GroupKeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// AddGroupKey stores a group key on this peer and returns its ID and the key
+// A nil key generates a random one; share the returned key with the other members out of band
+func (p *Peer) AddGroupKey(key []byte) (string, []byte, error) {
+	if key == nil {
+		key = make([]byte, GroupKeySize)
+		if _, err := rand.Read(key); err != nil {
+			return "", nil, fmt.Errorf("failed to generate group key: %w", err)
+		}
+	}
+	if len(key) != GroupKeySize {
+		return "", nil, fmt.Errorf("group key must be %d bytes, got %d", GroupKeySize, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create AEAD: %w", err)
+	}
+
+	keyID := GroupKeyID(key)
+	p.mu.Lock()
+	p.groupKeys[keyID] = aead
+	p.mu.Unlock()
+	return keyID, key, nil
+}
+
+// SetTopicKey encrypts a topic with a stored group key
+// Keys set before stay accepted for decryption, so members can rotate to a new key
+// without losing messages published with the old one
+func (p *Peer) SetTopicKey(topic, keyID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, exists := p.groupKeys[keyID]; !exists {
+		return fmt.Errorf("unknown group key: %s", keyID)
+	}
+
+	keys, exists := p.topicKeys[topic]
+	if !exists {
+		keys = &topicKeys{accepted: make(map[string]bool)}
+		p.topicKeys[topic] = keys
+	}
+	keys.current = keyID
+	keys.accepted[keyID] = true
+	return nil
+}
+
+// RemoveTopicKey stops accepting a retired group key on a topic
+// An empty key ID removes every key, so the topic is no longer encrypted
+// The current key can only be removed once it is the last one
+func (p *Peer) RemoveTopicKey(topic, keyID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	keys, exists := p.topicKeys[topic]
+	if !exists {
+		return nil
+	}
+	if keyID == "" {
+		delete(p.topicKeys, topic)
+		return nil
+	}
+	if keyID == keys.current && len(keys.accepted) > 1 {
+		return fmt.Errorf("key %s is the current key of topic %s, set another key first", keyID, topic)
+	}
+
+	delete(keys.accepted, keyID)
+	if len(keys.accepted) == 0 {
+		delete(p.topicKeys, topic)
+	}
+	return nil
+}
+
+// sealTopicMessage encrypts a topic message
+// An empty key ID uses the topic's current key; data is returned unchanged if the topic has none
+func (p *Peer) sealTopicMessage(topic string, data []byte, keyID string) ([]byte, error) {
+	p.mu.RLock()
+	if keyID == "" {
+		keys, exists := p.topicKeys[topic]
+		if !exists {
+			p.mu.RUnlock()
+			return data, nil
+		}
+		keyID = keys.current
+	}
+	aead, exists := p.groupKeys[keyID]
+	p.mu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("unknown group key: %s", keyID)
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return json.Marshal(encryptedPayload{
+		KeyID:      keyID,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, data, []byte(topic)),
+	})
+}
+
+// openTopicMessage decrypts a message received on a topic
+// Messages on unencrypted topics are returned unchanged; on encrypted topics, anything that
+// isn't encrypted with an accepted key fails
+func (p *Peer) openTopicMessage(topic string, data []byte) ([]byte, error) {
+	p.mu.RLock()
+	keys, encrypted := p.topicKeys[topic]
+	if !encrypted {
+		p.mu.RUnlock()
+		return data, nil
+	}
+
+	var payload encryptedPayload
+	if err := json.Unmarshal(data, &payload); err != nil || payload.KeyID == "" {
+		p.mu.RUnlock()
+		return nil, fmt.Errorf("message is not encrypted")
+	}
+	accepted := keys.accepted[payload.KeyID]
+	aead := p.groupKeys[payload.KeyID]
+	p.mu.RUnlock()
+
+	if !accepted || aead == nil {
+		return nil, fmt.Errorf("message key %s is not accepted on this topic", payload.KeyID)
+	}
+	if len(payload.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce")
+	}
+
+	plaintext, err := aead.Open(nil, payload.Nonce, payload.Ciphertext, []byte(topic))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt message: %w", err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,113 @@
+package peer
+
+import (
+	"crypto/cipher"
+	"strings"
+	"testing"
+)
+
+// newTestKeyPeer creates a Peer with just the state topic encryption uses
+func newTestKeyPeer() *Peer {
+	return &Peer{
+		groupKeys: make(map[string]cipher.AEAD),
+		topicKeys: make(map[string]*topicKeys),
+	}
+}
+
+// TestTopicEncryption tests sealing and opening topic messages with group keys
+func TestTopicEncryption(t *testing.T) {
+	sender := newTestKeyPeer()
+	receiver := newTestKeyPeer()
+
+	keyID, key, err := sender.AddGroupKey(nil)
+	if err != nil {
+		t.Fatalf("AddGroupKey failed: %v", err)
+	}
+	if len(key) != GroupKeySize || keyID != GroupKeyID(key) {
+		t.Fatalf("Expected a %d byte key with ID %s, got %d bytes and %s", GroupKeySize, GroupKeyID(key), len(key), keyID)
+	}
+	if _, _, err := sender.AddGroupKey([]byte("short")); err == nil {
+		t.Errorf("Expected a short key to be rejected")
+	}
+
+	// Unencrypted topics pass messages through
+	if sealed, err := sender.sealTopicMessage("room", []byte(`"hi"`), ""); err != nil || string(sealed) != `"hi"` {
+		t.Errorf("Expected plaintext on an unencrypted topic, got %s (%v)", sealed, err)
+	}
+
+	if err := sender.SetTopicKey("room", keyID); err != nil {
+		t.Fatalf("SetTopicKey failed: %v", err)
+	}
+	sealed, err := sender.sealTopicMessage("room", []byte(`"hi"`), "")
+	if err != nil {
+		t.Fatalf("sealTopicMessage failed: %v", err)
+	}
+	if strings.Contains(string(sealed), "hi") {
+		t.Errorf("Expected ciphertext, got %s", sealed)
+	}
+
+	// The receiver needs the key on the topic
+	if _, err := receiver.openTopicMessage("room", sealed); err != nil {
+		t.Errorf("Expected ciphertext to pass through an unencrypted topic, got %v", err)
+	}
+	if _, _, err := receiver.AddGroupKey(key); err != nil {
+		t.Fatalf("AddGroupKey failed: %v", err)
+	}
+	if err := receiver.SetTopicKey("room", keyID); err != nil {
+		t.Fatalf("SetTopicKey failed: %v", err)
+	}
+	if plaintext, err := receiver.openTopicMessage("room", sealed); err != nil || string(plaintext) != `"hi"` {
+		t.Errorf("Expected \"hi\", got %s (%v)", plaintext, err)
+	}
+
+	// Plaintext and messages sealed for another topic are dropped
+	if _, err := receiver.openTopicMessage("room", []byte(`"plain"`)); err == nil {
+		t.Errorf("Expected plaintext to fail on an encrypted topic")
+	}
+	if err := receiver.SetTopicKey("other", keyID); err != nil {
+		t.Fatalf("SetTopicKey failed: %v", err)
+	}
+	if _, err := receiver.openTopicMessage("other", sealed); err == nil {
+		t.Errorf("Expected a message sealed for another topic to fail")
+	}
+}
+
+// TestTopicKeyRotation tests that retired keys stay accepted until removed
+func TestTopicKeyRotation(t *testing.T) {
+	p := newTestKeyPeer()
+	oldID, _, _ := p.AddGroupKey(nil)
+	newID, _, _ := p.AddGroupKey(nil)
+
+	if err := p.SetTopicKey("room", "missing"); err == nil {
+		t.Errorf("Expected an unknown key to be rejected")
+	}
+	if err := p.SetTopicKey("room", oldID); err != nil {
+		t.Fatalf("SetTopicKey failed: %v", err)
+	}
+	inFlight, _ := p.sealTopicMessage("room", []byte(`1`), "")
+
+	// Rotate
+	if err := p.SetTopicKey("room", newID); err != nil {
+		t.Fatalf("SetTopicKey failed: %v", err)
+	}
+	if _, err := p.openTopicMessage("room", inFlight); err != nil {
+		t.Errorf("Expected the old key to still be accepted after rotation, got %v", err)
+	}
+	if err := p.RemoveTopicKey("room", newID); err == nil {
+		t.Errorf("Expected removing the current key to fail while another key is accepted")
+	}
+	if err := p.RemoveTopicKey("room", oldID); err != nil {
+		t.Fatalf("RemoveTopicKey failed: %v", err)
+	}
+	if _, err := p.openTopicMessage("room", inFlight); err == nil {
+		t.Errorf("Expected the removed key to be rejected")
+	}
+
+	// Removing every key leaves the topic unencrypted
+	if err := p.RemoveTopicKey("room", ""); err != nil {
+		t.Fatalf("RemoveTopicKey failed: %v", err)
+	}
+	if plaintext, err := p.openTopicMessage("room", []byte(`"plain"`)); err != nil || string(plaintext) != `"plain"` {
+		t.Errorf("Expected plaintext after removing every key, got %s (%v)", plaintext, err)
+	}
+}
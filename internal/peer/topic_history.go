@@ -0,0 +1,559 @@
+package peer
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// historyFetchPeers is how many topic peers a subscriber asks for missing history
+const historyFetchPeers = 3
+
+// maxServedHistory caps the messages exchanged in one history request, whatever the requester asks for
+const maxServedHistory = 100
+
+// Each remote peer may ask for history historyRequestLimit times per historyRequestWindow
+const (
+	historyRequestLimit  = 10
+	historyRequestWindow = time.Minute
+)
+
+// TopicHistoryRequest asks Subscribe to replay a topic's recent messages before live ones
+// The zero value replays nothing
+type TopicHistoryRequest struct {
+	MaxMessages int           // Most recent messages to replay (0 = no count limit)
+	MaxAge      time.Duration // Oldest message to replay (0 = no age limit)
+	Subscriber  string        // Client the replay is for, passed on in MessageMetadata.Subscriber (empty = every client)
+}
+
+// empty returns true if the request replays nothing
+func (r TopicHistoryRequest) empty() bool {
+	return r.MaxMessages <= 0 && r.MaxAge <= 0
+}
+
+// historyEntry is a topic message kept for replay
+// Only the pubsub record travels between peers: the receiver checks it and derives the other fields from it
+type historyEntry struct {
+	Record       []byte `json:"record"` // The pubsub message as published, with its author's signature
+	ReceivedFrom string `json:"receivedFrom,omitempty"`
+	ReceivedAt   int64  `json:"receivedAt"` // Unix milliseconds
+
+	ID    string `json:"-"`
+	From  string `json:"-"` // Author of the message
+	Seqno uint64 `json:"-"`
+	Data  []byte `json:"-"`
+}
+
+// GetTopicHistoryMessage asks a peer for its recent messages on a topic (message type 4)
+type GetTopicHistoryMessage struct {
+	Topic       string `json:"topic"`
+	MaxMessages int    `json:"maxMessages,omitempty"`
+	MaxAge      int64  `json:"maxAge,omitempty"` // Milliseconds
+}
+
+// TopicHistoryMessage answers a GetTopicHistoryMessage, oldest message first (message type 5)
+type TopicHistoryMessage struct {
+	Topic    string         `json:"topic"`
+	Messages []historyEntry `json:"messages"`
+}
+
+// heldMessage is a live topic message waiting for history replay to finish
+type heldMessage struct {
+	from string
+	data any
+	meta MessageMetadata
+}
+
+// historyLimiter counts the history requests each remote peer makes in fixed windows
+type historyLimiter struct {
+	mu      sync.Mutex
+	windows map[peer.ID]*historyWindow
+}
+
+// historyWindow is a peer's current request window
+type historyWindow struct {
+	start time.Time
+	count int
+}
+
+// allow counts a request from a peer, returning false once the peer is over its limit for the window
+func (l *historyLimiter) allow(from peer.ID, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.windows == nil {
+		l.windows = make(map[peer.ID]*historyWindow)
+	}
+	for id, w := range l.windows {
+		if now.Sub(w.start) >= historyRequestWindow {
+			delete(l.windows, id)
+		}
+	}
+	w, exists := l.windows[from]
+	if !exists {
+		w = &historyWindow{start: now}
+		l.windows[from] = w
+	}
+	if w.count >= historyRequestLimit {
+		return false
+	}
+	w.count++
+	return true
+}
+
+// topicHistory is a bounded buffer of a topic's recent messages, oldest first
+type topicHistory struct {
+	mu      sync.Mutex
+	entries []historyEntry
+}
+
+// add appends a message and drops whatever no longer fits the limits
+func (h *topicHistory) add(entry historyEntry, maxMessages int, maxAge time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries = append(h.entries, entry)
+	h.entries = selectHistory(h.entries, TopicHistoryRequest{MaxMessages: maxMessages, MaxAge: maxAge}, time.Now())
+}
+
+// recent returns a copy of the messages a request selects
+func (h *topicHistory) recent(req TopicHistoryRequest, now time.Time) []historyEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]historyEntry(nil), selectHistory(h.entries, req, now)...)
+}
+
+// selectHistory returns the tail of entries (oldest first) within a request's age and count limits
+func selectHistory(entries []historyEntry, req TopicHistoryRequest, now time.Time) []historyEntry {
+	if req.MaxAge > 0 {
+		cutoff := now.Add(-req.MaxAge).UnixMilli()
+		first := sort.Search(len(entries), func(i int) bool { return entries[i].ReceivedAt >= cutoff })
+		entries = entries[first:]
+	}
+	if req.MaxMessages > 0 && len(entries) > req.MaxMessages {
+		entries = entries[len(entries)-req.MaxMessages:]
+	}
+	return entries
+}
+
+// mergeHistory combines history from several sources into one oldest-first list
+// Messages with IDs in skip (or without an ID) are dropped, as are repeats
+func mergeHistory(sources [][]historyEntry, skip map[string]bool, req TopicHistoryRequest, now time.Time) []historyEntry {
+	seen := make(map[string]bool)
+	var merged []historyEntry
+	for _, entries := range sources {
+		for _, entry := range entries {
+			if entry.ID == "" || skip[entry.ID] || seen[entry.ID] {
+				continue
+			}
+			seen[entry.ID] = true
+			merged = append(merged, entry)
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].ReceivedAt < merged[j].ReceivedAt
+	})
+	return selectHistory(merged, req, now)
+}
+
+// recordHistory adds a received topic message to the topic's history buffer
+// Does nothing when topic history is disabled
+func (p *Peer) recordHistory(topic string, msg *pubsub.Message, meta MessageMetadata) {
+	if p.manager.historyMaxMessages <= 0 {
+		return
+	}
+	record, err := msg.Message.Marshal()
+	if err != nil {
+		return
+	}
+	entry := historyEntry{
+		Record:       record,
+		ReceivedFrom: meta.ReceivedFrom,
+		ReceivedAt:   meta.ReceivedAt.UnixMilli(),
+		ID:           meta.ID,
+		From:         msg.GetFrom().String(),
+		Seqno:        meta.Seqno,
+		Data:         msg.Data,
+	}
+
+	p.mu.Lock()
+	h, exists := p.history[topic]
+	if !exists {
+		h = &topicHistory{}
+		p.history[topic] = h
+	}
+	p.mu.Unlock()
+
+	h.add(entry, p.manager.historyMaxMessages, p.manager.historyMaxAge)
+}
+
+// localHistory returns the buffered messages of a topic that a request selects
+func (p *Peer) localHistory(topic string, req TopicHistoryRequest, now time.Time) []historyEntry {
+	p.mu.RLock()
+	h, exists := p.history[topic]
+	p.mu.RUnlock()
+	if !exists {
+		return nil
+	}
+	return h.recent(req, now)
+}
+
+// deliverTopicMessage hands a live topic message to the client
+// While history is being replayed, live messages are held so they arrive after it
+func (p *Peer) deliverTopicMessage(handler *TopicHandler, msg heldMessage) {
+	handler.replayMu.Lock()
+	if handler.replaying {
+		handler.held = append(handler.held, msg)
+		handler.replayMu.Unlock()
+		return
+	}
+	handler.replayMu.Unlock()
+
+	if p.manager.onTopicData != nil {
+		p.manager.onTopicData(p.peerID.String(), handler.Topic, msg.from, msg.data, msg.meta)
+	}
+}
+
+// replayHistory delivers a topic's recent messages to a new subscriber, then releases held live messages
+// History comes from this peer's buffer and from up to historyFetchPeers other subscribers
+func (p *Peer) replayHistory(handler *TopicHandler, req TopicHistoryRequest) {
+	replayed := make(map[string]bool)
+	defer p.finishReplay(handler, replayed)
+
+	now := time.Now()
+	sources := [][]historyEntry{p.localHistory(handler.Topic, req, now)}
+	sources = append(sources, p.fetchTopicHistory(handler, req)...)
+
+	// Messages that already arrived live are delivered live
+	handler.replayMu.Lock()
+	skip := make(map[string]bool, len(handler.held))
+	for _, msg := range handler.held {
+		skip[msg.meta.ID] = true
+	}
+	handler.replayMu.Unlock()
+
+	p.deliverHistory(handler, mergeHistory(sources, skip, req, now), req.Subscriber, replayed)
+}
+
+// replayHistoryTo delivers a topic's recent messages to another client subscribing to a topic the peer already has
+// Live messages aren't held: the subscriber only gets them once Subscribe returns, after the replay
+func (p *Peer) replayHistoryTo(handler *TopicHandler, req TopicHistoryRequest) {
+	now := time.Now()
+	fetched := p.fetchTopicHistory(handler, req)
+
+	// The local buffer is read after the fetch so it includes messages received meanwhile
+	sources := append([][]historyEntry{p.localHistory(handler.Topic, req, time.Now())}, fetched...)
+	p.deliverHistory(handler, mergeHistory(sources, nil, req, now), req.Subscriber, make(map[string]bool))
+}
+
+// deliverHistory hands replayed messages to a subscriber, recording their IDs in replayed
+func (p *Peer) deliverHistory(handler *TopicHandler, entries []historyEntry, subscriber string, replayed map[string]bool) {
+	p.logVerbose(2, "Replaying %d history messages on topic %s", len(entries), handler.Topic)
+
+	for _, entry := range entries {
+		if handler.ctx.Err() != nil {
+			return
+		}
+
+		// History of an encrypted topic is kept encrypted
+		plaintext, err := p.openTopicMessage(handler.Topic, entry.Data)
+		if err != nil {
+			continue
+		}
+		var decoded any
+		if err := json.Unmarshal(plaintext, &decoded); err != nil {
+			continue
+		}
+		replayed[entry.ID] = true
+		if p.manager.onTopicData != nil {
+			p.manager.onTopicData(p.peerID.String(), handler.Topic, entry.From, decoded, MessageMetadata{
+				ID:           entry.ID,
+				Seqno:        entry.Seqno,
+				ReceivedFrom: entry.ReceivedFrom,
+				ReceivedAt:   time.UnixMilli(entry.ReceivedAt),
+				History:      true,
+				Subscriber:   subscriber,
+			})
+		}
+	}
+}
+
+// finishReplay delivers the live messages held during replay, in order, and ends the replay
+// Held messages that were already replayed from history are skipped
+func (p *Peer) finishReplay(handler *TopicHandler, replayed map[string]bool) {
+	for {
+		handler.replayMu.Lock()
+		held := handler.held
+		handler.held = nil
+		if len(held) == 0 {
+			handler.replaying = false
+			handler.replayMu.Unlock()
+			return
+		}
+		handler.replayMu.Unlock()
+
+		for _, msg := range held {
+			if replayed[msg.meta.ID] || handler.ctx.Err() != nil {
+				continue
+			}
+			if p.manager.onTopicData != nil {
+				p.manager.onTopicData(p.peerID.String(), handler.Topic, msg.from, msg.data, msg.meta)
+			}
+		}
+	}
+}
+
+// fetchTopicHistory asks up to historyFetchPeers topic peers for their history
+// Peers that fail to answer are skipped, and so are entries that fail verifyHistoryEntry
+func (p *Peer) fetchTopicHistory(handler *TopicHandler, req TopicHistoryRequest) [][]historyEntry {
+	peers := handler.PubsubTopic.ListPeers()
+	if len(peers) > historyFetchPeers {
+		peers = peers[:historyFetchPeers]
+	}
+
+	results := make([][]historyEntry, len(peers))
+	var wg sync.WaitGroup
+	for i, target := range peers {
+		wg.Add(1)
+		go func(i int, target peer.ID) {
+			defer wg.Done()
+			entries, err := p.requestTopicHistory(target, handler.Topic, req)
+			if err != nil {
+				p.logVerbose(1, "Failed to fetch history of topic %s from %s: %v", handler.Topic, p.manager.getOrCreateAlias(target.String()), err)
+				return
+			}
+			if len(entries) > maxServedHistory {
+				entries = entries[len(entries)-maxServedHistory:]
+			}
+			for _, entry := range entries {
+				verified, err := p.verifyHistoryEntry(handler, target, entry)
+				if err != nil {
+					p.logVerbose(1, "Dropped history message on topic %s from %s: %v", handler.Topic, p.manager.getOrCreateAlias(target.String()), err)
+					continue
+				}
+				results[i] = append(results[i], verified)
+			}
+		}(i, target)
+	}
+	wg.Wait()
+	return results
+}
+
+// requestTopicHistory asks a peer for its recent messages on a topic using the reserved p2p-webapp protocol
+// The entries are marked as received from the peer that supplied them
+func (p *Peer) requestTopicHistory(target peer.ID, topic string, req TopicHistoryRequest) ([]historyEntry, error) {
+	ctx, cancel := context.WithTimeout(p.ctx, p.manager.streamTimeout)
+	defer cancel()
+
+	stream, err := p.host.NewStream(ctx, target, p.manager.fileProtocol())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stream: %w", err)
+	}
+	defer stream.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+
+	maxMessages := req.MaxMessages
+	if maxMessages <= 0 || maxMessages > maxServedHistory {
+		maxMessages = maxServedHistory
+	}
+	data, err := json.Marshal(GetTopicHistoryMessage{
+		Topic:       topic,
+		MaxMessages: maxMessages,
+		MaxAge:      req.MaxAge.Milliseconds(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal GetTopicHistory message: %w", err)
+	}
+
+	// Send message type (4 = GetTopicHistory)
+	if _, err := stream.Write([]byte{4}); err != nil {
+		return nil, fmt.Errorf("failed to write message type: %w", err)
+	}
+	if err := writeMessage(stream, data); err != nil {
+		return nil, fmt.Errorf("failed to write message: %w", err)
+	}
+
+	// Read response (5 = TopicHistory)
+	msgType := make([]byte, 1)
+	if _, err := io.ReadFull(stream, msgType); err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if msgType[0] != 5 {
+		return nil, fmt.Errorf("expected message type 5 (TopicHistory), got %d", msgType[0])
+	}
+	data, err = readMessage(stream)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	var response TopicHistoryMessage
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	if response.Topic != topic {
+		return nil, fmt.Errorf("response is for topic %s", response.Topic)
+	}
+
+	for i := range response.Messages {
+		response.Messages[i].ReceivedFrom = target.String()
+	}
+	return response.Messages, nil
+}
+
+// verifyHistoryEntry checks a fetched history message the way gossipsub checks a live one:
+// its signature (or its lack of one on anonymous pubsub), its topic, the blocklist and the topic's validator
+// The returned entry's ID, author, seqno and data come from the checked record, never from the supplier
+func (p *Peer) verifyHistoryEntry(handler *TopicHandler, source peer.ID, entry historyEntry) (historyEntry, error) {
+	var record pb.Message
+	if err := record.Unmarshal(entry.Record); err != nil {
+		return historyEntry{}, fmt.Errorf("invalid record: %w", err)
+	}
+	if record.GetTopic() != p.manager.wireTopic(handler.Topic) {
+		return historyEntry{}, fmt.Errorf("record is for topic %s", record.GetTopic())
+	}
+
+	var author peer.ID
+	if p.manager.pubsubSettings.SignaturePolicy == SignStrictNo {
+		if record.Signature != nil || record.Key != nil || record.From != nil || record.Seqno != nil {
+			return historyEntry{}, fmt.Errorf("anonymous topic record carries an author")
+		}
+	} else {
+		var err error
+		if author, err = verifyRecordSignature(&record); err != nil {
+			return historyEntry{}, err
+		}
+		if p.manager.blocklist.denies(author) {
+			return historyEntry{}, fmt.Errorf("author %s is blocked", author)
+		}
+	}
+
+	p.mu.RLock()
+	validate := p.topicValidators[handler.Topic]
+	p.mu.RUnlock()
+	if validate != nil {
+		msg := &pubsub.Message{Message: &record, ReceivedFrom: source}
+		if result := validate(handler.ctx, source, msg); result != pubsub.ValidationAccept {
+			return historyEntry{}, fmt.Errorf("rejected by the topic validator")
+		}
+	}
+
+	entry.ID = base64.RawURLEncoding.EncodeToString([]byte(p.manager.messageID(&record)))
+	entry.From = ""
+	if author != "" {
+		entry.From = author.String()
+	}
+	entry.Seqno = 0
+	if seqno := record.GetSeqno(); len(seqno) == 8 {
+		entry.Seqno = binary.BigEndian.Uint64(seqno)
+	}
+	entry.Data = record.Data
+	return entry, nil
+}
+
+// verifyRecordSignature checks a signed pubsub message against its author's key and returns the author
+func verifyRecordSignature(record *pb.Message) (peer.ID, error) {
+	author, err := peer.IDFromBytes(record.From)
+	if err != nil {
+		return "", fmt.Errorf("invalid author: %w", err)
+	}
+	if record.Signature == nil {
+		return "", fmt.Errorf("record is not signed")
+	}
+
+	var key crypto.PubKey
+	if record.Key == nil {
+		key, err = author.ExtractPublicKey()
+	} else if key, err = crypto.UnmarshalPublicKey(record.Key); err == nil && !author.MatchesPublicKey(key) {
+		err = fmt.Errorf("key does not match author %s", author)
+	}
+	if err == nil && key == nil {
+		err = fmt.Errorf("author ID does not embed a key")
+	}
+	if err != nil {
+		return "", fmt.Errorf("no signing key: %w", err)
+	}
+
+	// The signature covers the record without its signature and key
+	unsigned := *record
+	unsigned.Signature = nil
+	unsigned.Key = nil
+	data, err := unsigned.Marshal()
+	if err != nil {
+		return "", err
+	}
+	if valid, err := key.Verify(append([]byte(pubsub.SignPrefix), data...), record.Signature); err != nil || !valid {
+		return "", fmt.Errorf("invalid signature")
+	}
+	return author, nil
+}
+
+// handleGetTopicHistory answers a history request with this peer's buffered messages
+// Only topics this peer is subscribed to are served; others get an empty list
+// Each requester is limited to historyRequestLimit requests per historyRequestWindow, of at most maxServedHistory messages
+func (p *Peer) handleGetTopicHistory(stream network.Stream) {
+	requester := stream.Conn().RemotePeer()
+	requesterPeerID := requester.String()
+	if !p.historyRequests.allow(requester, time.Now()) {
+		p.logVerbose(1, "handleGetTopicHistory: too many requests from %s", p.manager.getOrCreateAlias(requesterPeerID))
+		return
+	}
+
+	data, err := readMessage(stream)
+	if err != nil {
+		p.logVerbose(1, "handleGetTopicHistory: failed to read message: %v", err)
+		return
+	}
+
+	var request GetTopicHistoryMessage
+	if err := json.Unmarshal(data, &request); err != nil {
+		p.logVerbose(1, "handleGetTopicHistory: failed to parse message: %v", err)
+		return
+	}
+
+	response := TopicHistoryMessage{Topic: request.Topic, Messages: []historyEntry{}}
+	p.mu.RLock()
+	_, subscribed := p.topics[request.Topic]
+	p.mu.RUnlock()
+	if subscribed {
+		req := TopicHistoryRequest{
+			MaxMessages: request.MaxMessages,
+			MaxAge:      time.Duration(request.MaxAge) * time.Millisecond,
+		}
+		if req.MaxMessages <= 0 || req.MaxMessages > maxServedHistory {
+			req.MaxMessages = maxServedHistory
+		}
+		if entries := p.localHistory(request.Topic, req, time.Now()); entries != nil {
+			response.Messages = entries
+		}
+	}
+
+	data, err = json.Marshal(response)
+	if err != nil {
+		p.logVerbose(1, "handleGetTopicHistory: failed to marshal response: %v", err)
+		return
+	}
+
+	p.logVerbose(2, "handleGetTopicHistory: sending %d messages of topic %s to %s", len(response.Messages), request.Topic, requesterPeerID)
+
+	// Send message type (5 = TopicHistory)
+	if _, err := stream.Write([]byte{5}); err != nil {
+		p.logVerbose(1, "handleGetTopicHistory: failed to write message type: %v", err)
+		return
+	}
+	if err := writeMessage(stream, data); err != nil {
+		p.logVerbose(1, "handleGetTopicHistory: failed to write message data: %v", err)
+	}
+}
@@ -0,0 +1,263 @@
+package peer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// TestMergeHistory tests that history sources are de-duplicated, ordered and limited
+func TestMergeHistory(t *testing.T) {
+	now := time.Now()
+	entry := func(id string, age time.Duration) historyEntry {
+		return historyEntry{ID: id, ReceivedAt: now.Add(-age).UnixMilli(), Data: json.RawMessage(`{}`)}
+	}
+
+	local := []historyEntry{entry("a", 50*time.Minute), entry("c", 3*time.Minute)}
+	remote := []historyEntry{entry("b", 5*time.Minute), entry("c", 3*time.Minute), entry("d", time.Minute), entry("", 0)}
+	skip := map[string]bool{"d": true}
+
+	ids := func(entries []historyEntry) string {
+		s := ""
+		for _, e := range entries {
+			s += e.ID
+		}
+		return s
+	}
+
+	if got := ids(mergeHistory([][]historyEntry{local, remote}, skip, TopicHistoryRequest{MaxMessages: 10}, now)); got != "abc" {
+		t.Errorf("Expected abc, got %s", got)
+	}
+	if got := ids(mergeHistory([][]historyEntry{local, remote}, skip, TopicHistoryRequest{MaxMessages: 2}, now)); got != "bc" {
+		t.Errorf("Expected the last 2 messages bc, got %s", got)
+	}
+	if got := ids(mergeHistory([][]historyEntry{local, remote}, nil, TopicHistoryRequest{MaxAge: 10 * time.Minute}, now)); got != "bcd" {
+		t.Errorf("Expected messages from the last 10 minutes bcd, got %s", got)
+	}
+
+	// The buffer drops messages past its limits as new ones arrive
+	var h topicHistory
+	for i, age := range []time.Duration{2 * time.Hour, 3 * time.Minute, 2 * time.Minute, time.Minute} {
+		h.add(entry(fmt.Sprint(i), age), 2, time.Hour)
+	}
+	if got := ids(h.recent(TopicHistoryRequest{}, now)); got != "23" {
+		t.Errorf("Expected buffer 23, got %s", got)
+	}
+}
+
+// signTestRecord builds a pubsub message signed the way pubsub signs published messages
+func signTestRecord(t *testing.T, key crypto.PrivKey, from peer.ID, topic, data string) *pb.Message {
+	t.Helper()
+	record := &pb.Message{From: []byte(from), Data: []byte(data), Seqno: []byte{0, 0, 0, 0, 0, 0, 0, 7}, Topic: &topic}
+	unsigned, err := record.Marshal()
+	if err != nil {
+		t.Fatalf("Failed to marshal record: %v", err)
+	}
+	if record.Signature, err = key.Sign(append([]byte(pubsub.SignPrefix), unsigned...)); err != nil {
+		t.Fatalf("Failed to sign record: %v", err)
+	}
+	return record
+}
+
+// TestVerifyHistoryEntry tests that fetched history is only replayed with a valid signature, topic and validator result
+func TestVerifyHistoryEntry(t *testing.T) {
+	key, _, _ := crypto.GenerateKeyPairWithReader(crypto.Ed25519, 2048, rand.Reader)
+	author, _ := peer.IDFromPrivateKey(key)
+	otherKey, _, _ := crypto.GenerateKeyPairWithReader(crypto.Ed25519, 2048, rand.Reader)
+	source := newBlocklistTestPeerID(t)
+
+	manager := &Manager{peerAliases: make(map[string]string)}
+	p := &Peer{manager: manager, topicValidators: make(map[string]pubsub.ValidatorEx)}
+	handler := &TopicHandler{Topic: "room", ctx: context.Background()}
+	validate, err := p.newTopicValidator("room", TopicValidatorSpec{MaxSize: 8})
+	if err != nil {
+		t.Fatalf("Failed to build validator: %v", err)
+	}
+	p.topicValidators["room"] = validate
+
+	encode := func(record *pb.Message) historyEntry {
+		data, err := record.Marshal()
+		if err != nil {
+			t.Fatalf("Failed to marshal record: %v", err)
+		}
+		// The supplier's claims about the message are ignored
+		return historyEntry{Record: data, ID: "forged", From: source.String(), Data: []byte(`"forged"`)}
+	}
+
+	valid := signTestRecord(t, key, author, "room", `"hi"`)
+	entry, err := p.verifyHistoryEntry(handler, source, encode(valid))
+	if err != nil {
+		t.Fatalf("Expected a valid record to pass, got %v", err)
+	}
+	wantID := base64.RawURLEncoding.EncodeToString([]byte(pubsub.DefaultMsgIdFn(valid)))
+	if entry.From != author.String() || string(entry.Data) != `"hi"` || entry.ID != wantID || entry.Seqno != 7 {
+		t.Errorf("Expected the entry to come from the record, got %+v", entry)
+	}
+
+	tampered := signTestRecord(t, key, author, "room", `"hi"`)
+	tampered.Data = []byte(`"bye"`)
+	forged := signTestRecord(t, otherKey, author, "room", `"hi"`)
+	unsigned := signTestRecord(t, key, author, "room", `"hi"`)
+	unsigned.Signature = nil
+	for name, record := range map[string]*pb.Message{
+		"tampered data":     tampered,
+		"forged author":     forged,
+		"unsigned":          unsigned,
+		"other topic":       signTestRecord(t, key, author, "lobby", `"hi"`),
+		"rejected by topic": signTestRecord(t, key, author, "room", `"much too long"`),
+	} {
+		if _, err := p.verifyHistoryEntry(handler, source, encode(record)); err == nil {
+			t.Errorf("Expected the %s record to be dropped", name)
+		}
+	}
+}
+
+// TestHistoryLimiter tests that each peer's history requests are limited per window
+func TestHistoryLimiter(t *testing.T) {
+	var limiter historyLimiter
+	busy, other := newBlocklistTestPeerID(t), newBlocklistTestPeerID(t)
+	now := time.Now()
+
+	for i := 0; i < historyRequestLimit; i++ {
+		if !limiter.allow(busy, now) {
+			t.Fatalf("Expected request %d to be allowed", i+1)
+		}
+	}
+	if limiter.allow(busy, now) {
+		t.Error("Expected a request over the limit to be refused")
+	}
+	if !limiter.allow(other, now) {
+		t.Error("Expected another peer's request to be allowed")
+	}
+	if !limiter.allow(busy, now.Add(historyRequestWindow)) {
+		t.Error("Expected requests to be allowed again in the next window")
+	}
+}
+
+// TestFinishReplay tests that live messages held during replay are released in order, without replayed repeats
+func TestFinishReplay(t *testing.T) {
+	var delivered []string
+	manager := &Manager{}
+	manager.onTopicData = func(receiverPeerID, topic, senderPeerID string, data any, meta MessageMetadata) {
+		delivered = append(delivered, data.(string))
+	}
+	p := &Peer{manager: manager}
+	handler := &TopicHandler{Topic: "room", ctx: context.Background(), replaying: true}
+
+	for _, id := range []string{"a", "b", "c"} {
+		p.deliverTopicMessage(handler, heldMessage{data: id, meta: MessageMetadata{ID: id}})
+	}
+	if len(delivered) != 0 {
+		t.Fatalf("Expected live messages to be held during replay, got %v", delivered)
+	}
+
+	p.finishReplay(handler, map[string]bool{"b": true})
+	p.deliverTopicMessage(handler, heldMessage{data: "d", meta: MessageMetadata{ID: "d"}})
+	if fmt.Sprint(delivered) != "[a c d]" {
+		t.Errorf("Expected [a c d], got %v", delivered)
+	}
+}
+
+// TestPeerSubscribeHistory tests that a late subscriber gets history from another subscriber before live messages,
+// and that a second client subscribing through the same peer gets its own replay
+func TestPeerSubscribeHistory(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	manager := &Manager{
+		ctx:                ctx,
+		peers:              make(map[string]*Peer),
+		peerAliases:        make(map[string]string),
+		streamTimeout:      5 * time.Second,
+		historyMaxMessages: 10,
+		historyMaxAge:      time.Hour,
+	}
+
+	author := newTestPubsubPeer(t, manager)
+	late := newTestPubsubPeer(t, manager)
+	for _, p := range []*Peer{author, late} {
+		p.host.SetStreamHandler(protocol.ID(P2PWebAppProtocol), p.handleP2PWebAppStream)
+	}
+
+	type delivery struct {
+		text       string
+		history    bool
+		subscriber string
+	}
+	deliveries := make(chan delivery, 10)
+	manager.onTopicData = func(receiverPeerID, topic, senderPeerID string, data any, meta MessageMetadata) {
+		if receiverPeerID != late.peerID.String() {
+			return
+		}
+		if senderPeerID != author.peerID.String() {
+			t.Errorf("Expected author %s, got %s", author.peerID, senderPeerID)
+		}
+		deliveries <- delivery{data.(string), meta.History, meta.Subscriber}
+	}
+
+	if err := late.host.Connect(ctx, peer.AddrInfo{ID: author.peerID, Addrs: author.host.Addrs()}); err != nil {
+		t.Fatalf("Failed to connect peers: %v", err)
+	}
+
+	if err := author.Subscribe("room", TopicHistoryRequest{}); err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	for _, text := range []string{"one", "two", "three"} {
+		if err := author.Publish("room", text); err != nil {
+			t.Fatalf("Publish failed: %v", err)
+		}
+	}
+
+	// The author records its own messages as they are delivered to it
+	deadline := time.Now().Add(5 * time.Second)
+	for len(author.localHistory("room", TopicHistoryRequest{}, time.Now())) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Author did not record its messages")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	expect := func(want delivery) {
+		t.Helper()
+		select {
+		case got := <-deliveries:
+			if got != want {
+				t.Errorf("Expected %+v, got %+v", want, got)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Timed out waiting for %+v", want)
+		}
+	}
+
+	if err := late.Subscribe("room", TopicHistoryRequest{MaxMessages: 2, Subscriber: "tab-1"}); err != nil {
+		t.Fatalf("Subscribe with history failed: %v", err)
+	}
+	expect(delivery{"two", true, "tab-1"})
+	expect(delivery{"three", true, "tab-1"})
+
+	if err := author.Publish("room", "four"); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+	expect(delivery{"four", false, ""})
+
+	// The peer is already subscribed, so the replay is delivered before Subscribe returns
+	if err := late.Subscribe("room", TopicHistoryRequest{MaxMessages: 3, Subscriber: "tab-2"}); err != nil {
+		t.Fatalf("Second subscribe with history failed: %v", err)
+	}
+	if len(deliveries) != 3 {
+		t.Fatalf("Expected 3 history messages when Subscribe returned, got %d", len(deliveries))
+	}
+	expect(delivery{"two", true, "tab-2"})
+	expect(delivery{"three", true, "tab-2"})
+	expect(delivery{"four", true, "tab-2"})
+}
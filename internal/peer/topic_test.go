@@ -0,0 +1,113 @@
+package peer
+
+import (
+	"context"
+	"crypto/cipher"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// newTestPubsubPeer creates a Peer with its own host and gossipsub router
+func newTestPubsubPeer(t *testing.T, manager *Manager) *Peer {
+	t.Helper()
+
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatalf("Failed to create libp2p host: %v", err)
+	}
+	t.Cleanup(func() { h.Close() })
+
+	ps, err := pubsub.NewGossipSub(manager.ctx, h)
+	if err != nil {
+		t.Fatalf("Failed to create gossipsub: %v", err)
+	}
+
+	return &Peer{
+		ctx:             manager.ctx,
+		host:            h,
+		pubsub:          ps,
+		peerID:          h.ID(),
+		topics:          make(map[string]*TopicHandler),
+		monitoredTopics: make(map[string]*TopicMonitor),
+		joinedTopics:    make(map[string]*joinedTopic),
+		topicValidators: make(map[string]pubsub.ValidatorEx),
+		history:         make(map[string]*topicHistory),
+		groupKeys:       make(map[string]cipher.AEAD),
+		topicKeys:       make(map[string]*topicKeys),
+		manager:         manager,
+	}
+}
+
+// TestPeerMonitorEvents tests that topic joins and leaves are reported from pubsub events
+func TestPeerMonitorEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	manager := &Manager{
+		ctx:         ctx,
+		peers:       make(map[string]*Peer),
+		peerAliases: make(map[string]string),
+		verbosity:   0,
+	}
+
+	type change struct {
+		peer   string
+		joined bool
+	}
+	changes := make(chan change, 4)
+	manager.onPeerChange = func(receiverPeerID, topic, changedPeerID string, joined bool) {
+		changes <- change{changedPeerID, joined}
+	}
+
+	watcher := newTestPubsubPeer(t, manager)
+	member := newTestPubsubPeer(t, manager)
+	if err := watcher.host.Connect(ctx, peer.AddrInfo{ID: member.peerID, Addrs: member.host.Addrs()}); err != nil {
+		t.Fatalf("Failed to connect peers: %v", err)
+	}
+
+	if err := watcher.Monitor("room"); err != nil {
+		t.Fatalf("Monitor failed: %v", err)
+	}
+	// Publishing shares the monitor's topic handle instead of failing to join again
+	if err := watcher.Publish("room", "hello"); err != nil {
+		t.Fatalf("Publish on a monitored topic failed: %v", err)
+	}
+
+	expect := func(want change) {
+		t.Helper()
+		select {
+		case got := <-changes:
+			if got != want {
+				t.Errorf("Expected %+v, got %+v", want, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("No peer change reported, expected %+v", want)
+		}
+	}
+
+	if err := member.Subscribe("room", TopicHistoryRequest{}); err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	expect(change{member.peerID.String(), true})
+
+	peers, err := watcher.ListPeers("room")
+	if err != nil || len(peers) != 1 || peers[0] != member.peerID.String() {
+		t.Errorf("Expected monitored peer list [%s], got %v (%v)", member.peerID, peers, err)
+	}
+
+	if err := member.Unsubscribe("room"); err != nil {
+		t.Fatalf("Unsubscribe failed: %v", err)
+	}
+	expect(change{member.peerID.String(), false})
+
+	if err := watcher.StopMonitor("room"); err != nil {
+		t.Fatalf("StopMonitor failed: %v", err)
+	}
+	if len(watcher.joinedTopics) != 0 {
+		t.Errorf("Expected topic to be closed after StopMonitor, still joined: %v", watcher.joinedTopics)
+	}
+}
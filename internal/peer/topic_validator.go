@@ -0,0 +1,361 @@
+package peer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// TopicValidatorSpec selects the built-in checks for a topic's messages
+// Messages failing a check are rejected by gossipsub: they are not delivered or forwarded
+// and the peer that sent them is penalized
+// On encrypted topics the schema and required fields are checked on the decrypted content;
+// messages this peer can't decrypt are ignored (dropped without penalty)
+type TopicValidatorSpec struct {
+	MaxSize  int      // Maximum message size in bytes (0 = unlimited)
+	Schema   string   // Name of a JSON Schema from the topicSchemas config (empty = none)
+	Required []string // Fields every message must have; dotted paths reach into nested objects
+}
+
+// empty returns true if the spec has no checks
+func (s TopicValidatorSpec) empty() bool {
+	return s.MaxSize <= 0 && s.Schema == "" && len(s.Required) == 0
+}
+
+// jsonSchema is a compiled JSON Schema
+// Supported keywords: type, properties, required, additionalProperties, items, enum, const,
+// minLength, maxLength, pattern, minimum, maximum, minItems and maxItems, plus annotations
+// (title, description, ...); schemas using other keywords are refused rather than half-checked
+type jsonSchema struct {
+	Types                []string
+	Properties           map[string]*jsonSchema
+	Required             []string
+	AdditionalProperties *jsonSchema // nil = allowed
+	NoAdditional         bool        // additionalProperties: false
+	Items                *jsonSchema
+	Enum                 []any
+	Const                any
+	HasConst             bool
+	MinLength            *int
+	MaxLength            *int
+	Pattern              *regexp.Regexp
+	Minimum              *float64
+	Maximum              *float64
+	MinItems             *int
+	MaxItems             *int
+}
+
+// rawSchema is the JSON form of a schema before compilation
+type rawSchema struct {
+	Type                 json.RawMessage            `json:"type"`
+	Properties           map[string]json.RawMessage `json:"properties"`
+	Required             []string                   `json:"required"`
+	AdditionalProperties json.RawMessage            `json:"additionalProperties"`
+	Items                json.RawMessage            `json:"items"`
+	Enum                 []any                      `json:"enum"`
+	Const                json.RawMessage            `json:"const"`
+	MinLength            *int                       `json:"minLength"`
+	MaxLength            *int                       `json:"maxLength"`
+	Pattern              *string                    `json:"pattern"`
+	Minimum              *float64                   `json:"minimum"`
+	Maximum              *float64                   `json:"maximum"`
+	MinItems             *int                       `json:"minItems"`
+	MaxItems             *int                       `json:"maxItems"`
+}
+
+// schemaKeywords are the keywords compileSchema accepts: the validated ones and annotations with no effect on validation
+var schemaKeywords = map[string]bool{
+	"type": true, "properties": true, "required": true, "additionalProperties": true, "items": true,
+	"enum": true, "const": true, "minLength": true, "maxLength": true, "pattern": true,
+	"minimum": true, "maximum": true, "minItems": true, "maxItems": true,
+	"$schema": true, "$id": true, "$comment": true, "title": true, "description": true, "default": true, "examples": true,
+}
+
+// compileSchema parses a JSON Schema document
+// Unsupported keywords (oneOf, $ref, format, ...) are errors, so a schema never silently accepts what it means to reject
+func compileSchema(data []byte) (*jsonSchema, error) {
+	var keywords map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keywords); err != nil {
+		return nil, fmt.Errorf("failed to parse schema: %w", err)
+	}
+	for keyword := range keywords {
+		if !schemaKeywords[keyword] {
+			return nil, fmt.Errorf("unsupported schema keyword: %s", keyword)
+		}
+	}
+
+	var raw rawSchema
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse schema: %w", err)
+	}
+
+	s := &jsonSchema{
+		Required:  raw.Required,
+		Enum:      raw.Enum,
+		MinLength: raw.MinLength,
+		MaxLength: raw.MaxLength,
+		Minimum:   raw.Minimum,
+		Maximum:   raw.Maximum,
+		MinItems:  raw.MinItems,
+		MaxItems:  raw.MaxItems,
+	}
+
+	if len(raw.Type) > 0 {
+		var single string
+		if err := json.Unmarshal(raw.Type, &single); err == nil {
+			s.Types = []string{single}
+		} else if err := json.Unmarshal(raw.Type, &s.Types); err != nil {
+			return nil, fmt.Errorf("invalid type: %s", raw.Type)
+		}
+	}
+
+	if len(raw.Properties) > 0 {
+		s.Properties = make(map[string]*jsonSchema, len(raw.Properties))
+		for name, propData := range raw.Properties {
+			prop, err := compileSchema(propData)
+			if err != nil {
+				return nil, fmt.Errorf("property %s: %w", name, err)
+			}
+			s.Properties[name] = prop
+		}
+	}
+
+	switch trimmed := bytes.TrimSpace(raw.AdditionalProperties); string(trimmed) {
+	case "", "true":
+	case "false":
+		s.NoAdditional = true
+	default:
+		additional, err := compileSchema(trimmed)
+		if err != nil {
+			return nil, fmt.Errorf("additionalProperties: %w", err)
+		}
+		s.AdditionalProperties = additional
+	}
+
+	if len(raw.Items) > 0 {
+		items, err := compileSchema(raw.Items)
+		if err != nil {
+			return nil, fmt.Errorf("items: %w", err)
+		}
+		s.Items = items
+	}
+
+	if len(raw.Const) > 0 {
+		if err := json.Unmarshal(raw.Const, &s.Const); err != nil {
+			return nil, fmt.Errorf("invalid const: %w", err)
+		}
+		s.HasConst = true
+	}
+
+	if raw.Pattern != nil {
+		pattern, err := regexp.Compile(*raw.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern: %w", err)
+		}
+		s.Pattern = pattern
+	}
+
+	return s, nil
+}
+
+// validate checks a decoded JSON value against the schema
+// path names the value in error messages
+func (s *jsonSchema) validate(value any, path string) error {
+	if len(s.Types) > 0 && !s.matchesType(value) {
+		return fmt.Errorf("%s: expected %s", path, strings.Join(s.Types, " or "))
+	}
+	if s.HasConst && !reflect.DeepEqual(value, s.Const) {
+		return fmt.Errorf("%s: does not match const", path)
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, allowed := range s.Enum {
+			if reflect.DeepEqual(value, allowed) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: not one of the enum values", path)
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.MinLength != nil && length < *s.MinLength {
+			return fmt.Errorf("%s: shorter than %d", path, *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			return fmt.Errorf("%s: longer than %d", path, *s.MaxLength)
+		}
+		if s.Pattern != nil && !s.Pattern.MatchString(v) {
+			return fmt.Errorf("%s: does not match pattern %s", path, s.Pattern)
+		}
+
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			return fmt.Errorf("%s: less than %v", path, *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			return fmt.Errorf("%s: greater than %v", path, *s.Maximum)
+		}
+
+	case []any:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			return fmt.Errorf("%s: fewer than %d items", path, *s.MinItems)
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			return fmt.Errorf("%s: more than %d items", path, *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				if err := s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s: missing required property %s", path, name)
+			}
+		}
+		for name, field := range v {
+			fieldPath := path + "." + name
+			if prop, ok := s.Properties[name]; ok {
+				if err := prop.validate(field, fieldPath); err != nil {
+					return err
+				}
+			} else if s.NoAdditional {
+				return fmt.Errorf("%s: additional property not allowed", fieldPath)
+			} else if s.AdditionalProperties != nil {
+				if err := s.AdditionalProperties.validate(field, fieldPath); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// matchesType returns true if value is one of the schema's types
+func (s *jsonSchema) matchesType(value any) bool {
+	for _, t := range s.Types {
+		switch v := value.(type) {
+		case nil:
+			if t == "null" {
+				return true
+			}
+		case bool:
+			if t == "boolean" {
+				return true
+			}
+		case string:
+			if t == "string" {
+				return true
+			}
+		case float64:
+			if t == "number" || (t == "integer" && v == float64(int64(v))) {
+				return true
+			}
+		case []any:
+			if t == "array" {
+				return true
+			}
+		case map[string]any:
+			if t == "object" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasField returns true if a decoded JSON object has a field at a dotted path
+func hasField(value any, path string) bool {
+	for _, name := range strings.Split(path, ".") {
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return false
+		}
+		if value, ok = obj[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// validateTopicMessage applies a spec's checks to raw topic message data
+// schema is the compiled spec.Schema (nil = none)
+func validateTopicMessage(data []byte, spec TopicValidatorSpec, schema *jsonSchema) error {
+	if spec.MaxSize > 0 && len(data) > spec.MaxSize {
+		return fmt.Errorf("message is %d bytes, limit is %d", len(data), spec.MaxSize)
+	}
+	if schema == nil && len(spec.Required) == 0 {
+		return nil
+	}
+
+	var decoded any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return fmt.Errorf("message is not valid JSON: %w", err)
+	}
+	for _, field := range spec.Required {
+		if !hasField(decoded, field) {
+			return fmt.Errorf("message is missing required field %s", field)
+		}
+	}
+	if schema != nil {
+		if err := schema.validate(decoded, "message"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newTopicValidator builds the gossipsub validator for a spec
+// Returns nil for an empty spec
+func (p *Peer) newTopicValidator(topic string, spec TopicValidatorSpec) (pubsub.ValidatorEx, error) {
+	if spec.empty() {
+		return nil, nil
+	}
+
+	var schema *jsonSchema
+	if spec.Schema != "" {
+		var exists bool
+		schema, exists = p.manager.topicSchemas[spec.Schema]
+		if !exists {
+			return nil, fmt.Errorf("unknown topic schema: %s", spec.Schema)
+		}
+	}
+
+	content := TopicValidatorSpec{Required: spec.Required}
+	return func(_ context.Context, from peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+		// The size limit applies to the message as sent; the other checks to its content, decrypted on encrypted topics
+		err := validateTopicMessage(msg.Data, TopicValidatorSpec{MaxSize: spec.MaxSize}, nil)
+		if err == nil && (schema != nil || !content.empty()) {
+			plaintext, openErr := p.openTopicMessage(topic, msg.Data)
+			if openErr != nil {
+				// Not checkable here (e.g. a key this peer doesn't have yet): drop it without penalizing the sender
+				p.logVerbose(2, "Ignored message on topic %s from %s: %v", topic, p.manager.getOrCreateAlias(from.String()), openErr)
+				return pubsub.ValidationIgnore
+			}
+			err = validateTopicMessage(plaintext, content, schema)
+		}
+		if err != nil {
+			p.logVerbose(2, "Rejected message on topic %s from %s: %v", topic, p.manager.getOrCreateAlias(from.String()), err)
+			return pubsub.ValidationReject
+		}
+		return pubsub.ValidationAccept
+	}, nil
+}
@@ -0,0 +1,168 @@
+package peer
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+)
+
+const chatSchema = `{
+	"title": "Chat message",
+	"type": "object",
+	"required": ["text"],
+	"properties": {
+		"text": {"type": "string", "minLength": 1, "maxLength": 20},
+		"kind": {"enum": ["chat", "system"]},
+		"tags": {"type": "array", "items": {"type": "string"}, "maxItems": 2},
+		"priority": {"type": "integer", "minimum": 0}
+	},
+	"additionalProperties": false
+}`
+
+// TestValidateTopicMessage tests the built-in size, required-field and schema checks
+func TestValidateTopicMessage(t *testing.T) {
+	schema, err := compileSchema([]byte(chatSchema))
+	if err != nil {
+		t.Fatalf("Failed to compile schema: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		data   string
+		spec   TopicValidatorSpec
+		schema *jsonSchema
+		valid  bool
+	}{
+		{"within size", `"hi"`, TopicValidatorSpec{MaxSize: 4}, nil, true},
+		{"too large", `"hello"`, TopicValidatorSpec{MaxSize: 4}, nil, false},
+		{"size only skips JSON", `not json`, TopicValidatorSpec{MaxSize: 100}, nil, true},
+		{"required present", `{"user":{"name":"a"}}`, TopicValidatorSpec{Required: []string{"user.name"}}, nil, true},
+		{"required missing", `{"user":{}}`, TopicValidatorSpec{Required: []string{"user.name"}}, nil, false},
+		{"invalid JSON", `{`, TopicValidatorSpec{Required: []string{"text"}}, nil, false},
+		{"schema valid", `{"text":"hi","kind":"chat","tags":["a"],"priority":1}`, TopicValidatorSpec{Schema: "chat"}, schema, true},
+		{"schema missing property", `{"kind":"chat"}`, TopicValidatorSpec{Schema: "chat"}, schema, false},
+		{"schema wrong type", `{"text":5}`, TopicValidatorSpec{Schema: "chat"}, schema, false},
+		{"schema empty string", `{"text":""}`, TopicValidatorSpec{Schema: "chat"}, schema, false},
+		{"schema enum", `{"text":"hi","kind":"other"}`, TopicValidatorSpec{Schema: "chat"}, schema, false},
+		{"schema items", `{"text":"hi","tags":[1]}`, TopicValidatorSpec{Schema: "chat"}, schema, false},
+		{"schema max items", `{"text":"hi","tags":["a","b","c"]}`, TopicValidatorSpec{Schema: "chat"}, schema, false},
+		{"schema integer", `{"text":"hi","priority":1.5}`, TopicValidatorSpec{Schema: "chat"}, schema, false},
+		{"schema additional property", `{"text":"hi","extra":true}`, TopicValidatorSpec{Schema: "chat"}, schema, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateTopicMessage([]byte(tt.data), tt.spec, tt.schema)
+			if tt.valid && err != nil {
+				t.Errorf("Expected valid, got %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Error("Expected message to be rejected")
+			}
+		})
+	}
+}
+
+// TestCompileSchemaInvalid tests that malformed schemas are reported
+func TestCompileSchemaInvalid(t *testing.T) {
+	for _, schema := range []string{`{`, `{"type": 5}`, `{"pattern": "("}`, `{"properties": {"a": {"type": []}}, "items": 3}`,
+		`{"oneOf": [{"type": "string"}]}`, `{"properties": {"a": {"type": "string", "format": "email"}}}`, `{"items": {"$ref": "#/defs/x"}}`} {
+		if _, err := compileSchema([]byte(schema)); err == nil {
+			t.Errorf("Expected error compiling %s", schema)
+		}
+	}
+}
+
+// TestPeerSetTopicValidator tests that validators are registered with gossipsub
+func TestPeerSetTopicValidator(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	manager := &Manager{
+		ctx:         ctx,
+		peers:       make(map[string]*Peer),
+		peerAliases: make(map[string]string),
+		verbosity:   0,
+	}
+	if err := manager.SetTopicSchemas(map[string]string{"chat": chatSchema}); err != nil {
+		t.Fatalf("SetTopicSchemas failed: %v", err)
+	}
+
+	p := newTestPubsubPeer(t, manager)
+
+	if err := p.SetTopicValidator("room", TopicValidatorSpec{Schema: "missing"}); err == nil {
+		t.Error("Expected error for unknown schema")
+	}
+	if err := p.SetTopicValidator("room", TopicValidatorSpec{Schema: "chat"}); err != nil {
+		t.Fatalf("SetTopicValidator failed: %v", err)
+	}
+
+	// Local publishes go through the validator too
+	if err := p.Publish("room", map[string]any{"text": "hi"}); err != nil {
+		t.Errorf("Valid message rejected: %v", err)
+	}
+	if err := p.Publish("room", map[string]any{"text": 5}); err == nil {
+		t.Error("Invalid message should be rejected")
+	}
+
+	// Replacing and then clearing the validator
+	if err := p.SetTopicValidator("room", TopicValidatorSpec{MaxSize: 1}); err != nil {
+		t.Fatalf("Replacing validator failed: %v", err)
+	}
+	if err := p.SetTopicValidator("room", TopicValidatorSpec{}); err != nil {
+		t.Fatalf("Clearing validator failed: %v", err)
+	}
+	if err := p.Publish("room", map[string]any{"text": 5}); err != nil {
+		t.Errorf("Message rejected after clearing validator: %v", err)
+	}
+}
+
+// TestTopicValidatorEncrypted tests that content checks on encrypted topics apply to the decrypted message
+func TestTopicValidatorEncrypted(t *testing.T) {
+	sender := newTestKeyPeer()
+	receiver := newTestKeyPeer()
+	receiver.manager = &Manager{peerAliases: make(map[string]string)}
+
+	keyID, key, err := sender.AddGroupKey(nil)
+	if err != nil {
+		t.Fatalf("AddGroupKey failed: %v", err)
+	}
+	sender.SetTopicKey("room", keyID)
+	receiver.AddGroupKey(key)
+	receiver.SetTopicKey("room", keyID)
+
+	validate, err := receiver.newTopicValidator("room", TopicValidatorSpec{MaxSize: 200, Required: []string{"text"}})
+	if err != nil {
+		t.Fatalf("Failed to build validator: %v", err)
+	}
+	check := func(data []byte) pubsub.ValidationResult {
+		return validate(context.Background(), receiver.peerID, &pubsub.Message{Message: &pb.Message{Data: data}})
+	}
+	seal := func(plaintext string) []byte {
+		sealed, err := sender.sealTopicMessage("room", []byte(plaintext), "")
+		if err != nil {
+			t.Fatalf("sealTopicMessage failed: %v", err)
+		}
+		return sealed
+	}
+
+	if result := check(seal(`{"text":"hi"}`)); result != pubsub.ValidationAccept {
+		t.Errorf("Expected an encrypted valid message to be accepted, got %v", result)
+	}
+	if result := check(seal(`{"other":"hi"}`)); result != pubsub.ValidationReject {
+		t.Errorf("Expected an encrypted message missing a field to be rejected, got %v", result)
+	}
+	if result := check(seal(`{"text":"` + strings.Repeat("x", 200) + `"}`)); result != pubsub.ValidationReject {
+		t.Errorf("Expected an oversized message to be rejected, got %v", result)
+	}
+
+	// A message under a key this peer doesn't have can't be checked
+	otherID, _, _ := sender.AddGroupKey(nil)
+	sender.SetTopicKey("room", otherID)
+	if result := check(seal(`{"text":"hi"}`)); result != pubsub.ValidationIgnore {
+		t.Errorf("Expected a message under an unknown key to be ignored, got %v", result)
+	}
+}
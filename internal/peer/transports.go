@@ -0,0 +1,219 @@
+package peer
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/libp2p/go-libp2p"
+	libp2pquic "github.com/libp2p/go-libp2p/p2p/transport/quic"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+	ws "github.com/libp2p/go-libp2p/p2p/transport/websocket"
+	webtransport "github.com/libp2p/go-libp2p/p2p/transport/webtransport"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// Transport names
+const (
+	TransportTCP          = "tcp"
+	TransportQUIC         = "quic-v1"
+	TransportWebTransport = "webtransport"
+	TransportWebSocket    = "websocket"
+)
+
+// TransportSettings selects the transports and addresses of every peer's host
+type TransportSettings struct {
+	ListenAddrs []string // Multiaddrs to listen on; port 0 picks a random port
+	PortRange   int      // Peers created from fixed listen ports: peer n listens on port+n (0 or 1 = a single peer)
+	Transports  []string // Enabled transports (empty = the libp2p defaults)
+	Announce    []string // Addresses announced instead of the listen addresses (empty = listen addresses)
+	NoAnnounce  []string // Addresses or /ipcidr ranges that are never announced
+}
+
+// DefaultTransportSettings listens on a random TCP port with the libp2p default transports
+func DefaultTransportSettings() TransportSettings {
+	return TransportSettings{
+		ListenAddrs: []string{"/ip4/0.0.0.0/tcp/0"},
+	}
+}
+
+// transportConfig is TransportSettings parsed and checked by SetTransports
+type transportConfig struct {
+	listen     []ma.Multiaddr
+	portRange  int
+	transports []libp2p.Option
+	announce   []ma.Multiaddr
+	noAnnounce []ma.Multiaddr // Exact addresses
+	noNets     *ma.Filters    // /ipcidr ranges
+	fixedPorts bool           // Some listen address has a fixed port, so peers need port slots
+}
+
+// parseTransportSettings checks settings and parses their addresses
+func parseTransportSettings(s TransportSettings) (*transportConfig, error) {
+	c := &transportConfig{portRange: max(s.PortRange, 1), noNets: ma.NewFilters()}
+
+	listen := s.ListenAddrs
+	if len(listen) == 0 {
+		listen = DefaultTransportSettings().ListenAddrs
+	}
+	for _, str := range listen {
+		addr, err := ma.NewMultiaddr(str)
+		if err != nil {
+			return nil, fmt.Errorf("invalid listen address %q: %w", str, err)
+		}
+		c.listen = append(c.listen, addr)
+		c.fixedPorts = c.fixedPorts || hasFixedPort(addr)
+	}
+
+	for _, name := range s.Transports {
+		switch name {
+		case TransportTCP:
+			c.transports = append(c.transports, libp2p.Transport(tcp.NewTCPTransport))
+		case TransportQUIC:
+			c.transports = append(c.transports, libp2p.Transport(libp2pquic.NewTransport))
+		case TransportWebTransport:
+			c.transports = append(c.transports, libp2p.Transport(webtransport.New))
+		case TransportWebSocket:
+			c.transports = append(c.transports, libp2p.Transport(ws.New))
+		default:
+			return nil, fmt.Errorf("unknown transport: %s", name)
+		}
+	}
+
+	for _, str := range s.Announce {
+		addr, err := ma.NewMultiaddr(str)
+		if err != nil {
+			return nil, fmt.Errorf("invalid announce address %q: %w", str, err)
+		}
+		c.announce = append(c.announce, addr)
+	}
+
+	for _, str := range s.NoAnnounce {
+		addr, err := ma.NewMultiaddr(str)
+		if err != nil {
+			return nil, fmt.Errorf("invalid no-announce address %q: %w", str, err)
+		}
+		if _, err := addr.ValueForProtocol(ma.P_IPCIDR); err == nil {
+			ipnet, err := manet.MultiaddrToIPNet(addr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid no-announce range %q: %w", str, err)
+			}
+			c.noNets.AddFilter(*ipnet, ma.ActionDeny)
+			continue
+		}
+		c.noAnnounce = append(c.noAnnounce, addr)
+	}
+
+	return c, nil
+}
+
+// hasFixedPort returns true if an address has a non-zero TCP or UDP port
+func hasFixedPort(addr ma.Multiaddr) bool {
+	for _, c := range addr {
+		if code := c.Protocol().Code; (code == ma.P_TCP || code == ma.P_UDP) && c.Value() != "0" {
+			return true
+		}
+	}
+	return false
+}
+
+// offsetPort adds offset to an address's fixed TCP or UDP ports
+func offsetPort(addr ma.Multiaddr, offset int) (ma.Multiaddr, error) {
+	if offset == 0 || !hasFixedPort(addr) {
+		return addr, nil
+	}
+	out := make(ma.Multiaddr, 0, len(addr))
+	for _, c := range addr {
+		if code := c.Protocol().Code; (code == ma.P_TCP || code == ma.P_UDP) && c.Value() != "0" {
+			port, err := strconv.Atoi(c.Value())
+			if err != nil {
+				return nil, fmt.Errorf("invalid port in %s: %w", addr, err)
+			}
+			if port+offset > 65535 {
+				return nil, fmt.Errorf("port range of %s goes past 65535", addr)
+			}
+			shifted, err := ma.NewComponent(c.Protocol().Name, strconv.Itoa(port+offset))
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, *shifted)
+			continue
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// offsetPorts applies offsetPort to each address
+func offsetPorts(addrs []ma.Multiaddr, offset int) ([]ma.Multiaddr, error) {
+	out := make([]ma.Multiaddr, 0, len(addrs))
+	for _, addr := range addrs {
+		shifted, err := offsetPort(addr, offset)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, shifted)
+	}
+	return out, nil
+}
+
+// announcedAddrs returns the addresses a host announces given the ones it listens on
+// announce and noAnnounce already have the peer's port offset applied
+func (c *transportConfig) announcedAddrs(addrs, announce, noAnnounce []ma.Multiaddr) []ma.Multiaddr {
+	if len(announce) > 0 {
+		addrs = announce
+	}
+	return ma.FilterAddrs(addrs, func(addr ma.Multiaddr) bool {
+		return !ma.Contains(noAnnounce, addr) && !c.noNets.AddrBlocked(addr)
+	})
+}
+
+// hostOptions returns the libp2p options for a host using a port slot
+func (c *transportConfig) hostOptions(slot int) ([]libp2p.Option, error) {
+	listen, err := offsetPorts(c.listen, slot)
+	if err != nil {
+		return nil, err
+	}
+	announce, err := offsetPorts(c.announce, slot)
+	if err != nil {
+		return nil, err
+	}
+	noAnnounce, err := offsetPorts(c.noAnnounce, slot)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []libp2p.Option{libp2p.ListenAddrs(listen...)}
+	opts = append(opts, c.transports...)
+	if len(announce) > 0 || len(noAnnounce) > 0 || len(c.noNets.FiltersForAction(ma.ActionDeny)) > 0 {
+		opts = append(opts, libp2p.AddrsFactory(func(addrs []ma.Multiaddr) []ma.Multiaddr {
+			return c.announcedAddrs(addrs, announce, noAnnounce)
+		}))
+	}
+	return opts, nil
+}
+
+// acquirePortSlot reserves the lowest free port slot for a new peer
+// Returns 0 without reserving when no listen address has a fixed port
+func (m *Manager) acquirePortSlot() (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.transports.fixedPorts {
+		return 0, nil
+	}
+	for slot := 0; slot < m.transports.portRange; slot++ {
+		if !m.portSlots[slot] {
+			m.portSlots[slot] = true
+			return slot, nil
+		}
+	}
+	return 0, fmt.Errorf("no free listen port: all %d ports of the port range are in use", m.transports.portRange)
+}
+
+// releasePortSlot frees a peer's port slot for later peers
+func (m *Manager) releasePortSlot(slot int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.portSlots, slot)
+}
@@ -0,0 +1,139 @@
+package peer
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// TestParseTransportSettings tests address and transport name checks
+func TestParseTransportSettings(t *testing.T) {
+	for _, settings := range []TransportSettings{
+		{ListenAddrs: []string{"not-a-multiaddr"}},
+		{Transports: []string{"carrier-pigeon"}},
+		{Announce: []string{"/ip4/999.0.0.1/tcp/1"}},
+		{NoAnnounce: []string{"/ip4/10.0.0.0/ipcidr/99"}},
+	} {
+		if _, err := parseTransportSettings(settings); err == nil {
+			t.Errorf("Expected an error for %+v", settings)
+		}
+	}
+
+	c, err := parseTransportSettings(TransportSettings{})
+	if err != nil {
+		t.Fatalf("Failed to parse empty settings: %v", err)
+	}
+	if len(c.listen) != 1 || c.listen[0].String() != "/ip4/0.0.0.0/tcp/0" || c.fixedPorts {
+		t.Errorf("Expected the default random TCP port, got %v (fixed ports: %v)", c.listen, c.fixedPorts)
+	}
+}
+
+// TestAnnouncedAddrs tests announce replacement and no-announce filtering
+func TestAnnouncedAddrs(t *testing.T) {
+	listening := []ma.Multiaddr{
+		ma.StringCast("/ip4/127.0.0.1/tcp/4001"),
+		ma.StringCast("/ip4/10.1.2.3/tcp/4001"),
+		ma.StringCast("/ip4/192.168.1.5/udp/4001/quic-v1"),
+	}
+
+	c, err := parseTransportSettings(TransportSettings{
+		ListenAddrs: []string{"/ip4/0.0.0.0/tcp/4001"},
+		NoAnnounce:  []string{"/ip4/10.0.0.0/ipcidr/8", "/ip4/127.0.0.1/tcp/4001"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to parse settings: %v", err)
+	}
+	got := c.announcedAddrs(listening, c.announce, c.noAnnounce)
+	if len(got) != 1 || !got[0].Equal(listening[2]) {
+		t.Errorf("Expected only the QUIC address, got %v", got)
+	}
+
+	c, err = parseTransportSettings(TransportSettings{
+		Announce:   []string{"/dns4/example.com/tcp/443/wss", "/ip4/10.9.9.9/tcp/4001"},
+		NoAnnounce: []string{"/ip4/10.0.0.0/ipcidr/8"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to parse settings: %v", err)
+	}
+	got = c.announcedAddrs(listening, c.announce, c.noAnnounce)
+	if len(got) != 1 || got[0].String() != "/dns4/example.com/tcp/443/wss" {
+		t.Errorf("Expected only the announced DNS address, got %v", got)
+	}
+}
+
+// TestPortSlots tests that peers get distinct fixed ports within the port range
+func TestPortSlots(t *testing.T) {
+	manager := &Manager{portSlots: make(map[int]bool)}
+	if err := manager.SetTransports(TransportSettings{
+		ListenAddrs: []string{"/ip4/127.0.0.1/tcp/4001", "/ip4/127.0.0.1/udp/4001/quic-v1"},
+		PortRange:   2,
+	}); err != nil {
+		t.Fatalf("Failed to set transports: %v", err)
+	}
+
+	first, err := manager.acquirePortSlot()
+	if err != nil {
+		t.Fatalf("Failed to acquire first slot: %v", err)
+	}
+	second, err := manager.acquirePortSlot()
+	if err != nil {
+		t.Fatalf("Failed to acquire second slot: %v", err)
+	}
+	if first != 0 || second != 1 {
+		t.Errorf("Expected slots 0 and 1, got %d and %d", first, second)
+	}
+	if _, err := manager.acquirePortSlot(); err == nil {
+		t.Error("Expected an error once the port range is used up")
+	}
+
+	listen, err := offsetPorts(manager.transports.listen, second)
+	if err != nil {
+		t.Fatalf("Failed to offset ports: %v", err)
+	}
+	if listen[0].String() != "/ip4/127.0.0.1/tcp/4002" || listen[1].String() != "/ip4/127.0.0.1/udp/4002/quic-v1" {
+		t.Errorf("Expected port 4002 for the second peer, got %v", listen)
+	}
+
+	manager.releasePortSlot(first)
+	if slot, err := manager.acquirePortSlot(); err != nil || slot != 0 {
+		t.Errorf("Expected released slot 0 to be reused, got %d (%v)", slot, err)
+	}
+}
+
+// TestHostOptions tests that a host can be created with selected transports
+func TestHostOptions(t *testing.T) {
+	c, err := parseTransportSettings(TransportSettings{
+		ListenAddrs: []string{"/ip4/127.0.0.1/tcp/0", "/ip4/127.0.0.1/udp/0/quic-v1"},
+		Transports:  []string{TransportTCP, TransportQUIC},
+		NoAnnounce:  []string{"/ip4/127.0.0.0/ipcidr/8"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to parse settings: %v", err)
+	}
+	opts, err := c.hostOptions(0)
+	if err != nil {
+		t.Fatalf("Failed to build host options: %v", err)
+	}
+	h, err := libp2p.New(opts...)
+	if err != nil {
+		t.Fatalf("Failed to create host: %v", err)
+	}
+	defer h.Close()
+
+	listening := h.Network().ListenAddresses()
+	for _, code := range []int{ma.P_TCP, ma.P_QUIC_V1} {
+		found := false
+		for _, addr := range listening {
+			if _, err := addr.ValueForProtocol(code); err == nil {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Expected a %s listener, got %v", ma.ProtocolWithCode(code).Name, listening)
+		}
+	}
+	if announced := h.Addrs(); len(announced) != 0 {
+		t.Errorf("Expected loopback addresses to be hidden, got %v", announced)
+	}
+}
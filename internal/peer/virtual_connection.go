@@ -3,106 +3,360 @@ package peer
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
-	"github.com/libp2p/go-libp2p/core/protocol"
 )
 
+// Queue policies: what SendToQueue does when a queue is at its limits
+const (
+	QueuePolicyReject     = "reject"     // Fail the send with ErrQueueFull
+	QueuePolicyDropOldest = "dropOldest" // Abandon the oldest queued messages to make room
+	QueuePolicyBlock      = "block"      // Wait for room, up to the stream timeout
+)
+
+// ErrQueueFull is returned by sends that don't fit in their queue
+var ErrQueueFull = errors.New("queue full")
+
+// reorderWindow is how far ahead of the next expected sequence number a receiver buffers messages
+const reorderWindow = 256
+
 // VirtualConnectionManager manages virtual connections and message queues per (peer, protocol) pair
 type VirtualConnectionManager struct {
-	ctx    context.Context
-	peer   *Peer
-	mu     sync.RWMutex
-	queues map[string]*MessageQueue // key: "peerID:protocol"
+	ctx        context.Context
+	peer       *Peer
+	mu         sync.RWMutex
+	queues     map[string]*MessageQueue      // key: "peerID:protocol"
+	notifee    *network.NotifyBundle         // Resets unreachable queues on new connections
+	calls      map[string]chan StreamMessage // Pending call replies by call ID
+	nextCallID uint64
+	session    string // Identifies this sender instance so receivers can reset their sequence state
 }
 
 // MessageQueue holds messages for a specific (peer, protocol) pair
 type MessageQueue struct {
-	peer                 string
-	protocol             string
-	messages             []QueuedMessage
-	stream               network.Stream
-	retryCount           int
-	unreachable          bool
-	lastActivity         time.Time
-	processing           bool
-	mu                   sync.Mutex
-	manager              *VirtualConnectionManager
-	streamReaderDone     chan struct{}
-	streamReaderDoneOnce sync.Once
+	peer             string
+	protocol         string
+	messages         []QueuedMessage
+	stream           network.Stream
+	retryCount       int
+	unreachable      bool
+	lastActivity     time.Time
+	processing       bool
+	mu               sync.Mutex
+	sendMu           sync.Mutex // Serializes SendToQueue, keeping sequence order while mu is released for persistence
+	manager          *VirtualConnectionManager
+	streamReaderDone chan struct{} // Closed when the current stream's reader ends
+	writeMu          sync.Mutex    // Serializes writes to the stream
+	acks             chan string   // IDs of ACKs received from the remote peer
+	ttl              time.Duration // Messages older than this expire (0 = never)
+	nextSeq          uint64        // Sequence number for the next outbound message
+	maxMessages      int           // Message limit (0 = unlimited)
+	maxBytes         int64         // Payload byte limit (0 = unlimited)
+	policy           string        // What a send does when the queue is full
+	spaceFreed       chan struct{} // Closed when messages leave the queue, wakes blocked senders
+
+	// Receive side: dedup and in-order delivery of the remote peer's messages
+	recvMu      sync.Mutex                 // Serializes delivery across stream readers
+	recvSession string                     // Remote sender session the state below belongs to
+	recvNext    uint64                     // Next sequence number to deliver
+	recvPending map[uint64]receivedMessage // Decoded out-of-order messages waiting for recvNext
 }
 
 // QueuedMessage represents a message in the queue
 type QueuedMessage struct {
 	id          string
+	seq         uint64 // Per-(peer, protocol) sequence number, starting at 1
 	data        []byte
 	attempts    int
 	maxAttempts int
 	timestamp   time.Time
+	ack         int  // Client ack number to report on delivery (-1 = no ack)
+	binary      bool // data is raw bytes rather than JSON
 }
 
 // StreamMessage represents a message sent over the stream
+// On framed streams it is a JSON header frame followed by a raw body frame holding Data;
+// on legacy streams it is one JSON frame with Data base64-encoded (see legacyStreamMessage)
 type StreamMessage struct {
-	Type string `json:"type"` // "data" or "ack"
-	ID   string `json:"id"`
+	Type    string `json:"type"` // "data", "ack", "call" or "reply"
+	ID      string `json:"id"`
+	Data    []byte `json:"-"`                 // Sent as the body frame, not base64 in the header
+	Binary  bool   `json:"binary,omitempty"`  // Data is raw bytes rather than JSON
+	Error   string `json:"error,omitempty"`   // Reply only: the remote handler failed
+	Timeout int64  `json:"timeout,omitempty"` // Call only: milliseconds the caller will wait
+	Session string `json:"session,omitempty"` // Data only: sender instance the sequence numbers belong to
+	Seq     uint64 `json:"seq,omitempty"`     // Data only: sequence number (0 = unsequenced legacy sender)
+	Base    uint64 `json:"base,omitempty"`    // Data only: oldest sequence number the sender may still send
+	SentAt  int64  `json:"sentAt,omitempty"`  // Data only: Unix milliseconds when the sender queued the message
+}
+
+// legacyStreamMessage is a stream message as peers predating framed streams send it
+type legacyStreamMessage struct {
+	StreamMessage
 	Data []byte `json:"data,omitempty"`
 }
 
+// receivedMessage is a decoded data message waiting for in-order delivery
+type receivedMessage struct {
+	data any
+	meta MessageMetadata
+}
+
+// encodePayload encodes application data for a stream message
+// []byte is sent as-is (binary), anything else as JSON
+func encodePayload(data any) ([]byte, bool, error) {
+	if raw, ok := data.([]byte); ok {
+		return raw, true, nil
+	}
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to marshal data: %w", err)
+	}
+	return jsonData, false, nil
+}
+
+// decodePayload decodes stream message data for the application
+func decodePayload(data []byte, binary bool) (any, error) {
+	if binary {
+		return data, nil
+	}
+	var decoded any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, err
+	}
+	return decoded, nil
+}
+
 // NewVirtualConnectionManager creates a new virtual connection manager for a peer
 func NewVirtualConnectionManager(ctx context.Context, p *Peer) *VirtualConnectionManager {
 	vcm := &VirtualConnectionManager{
-		ctx:    ctx,
-		peer:   p,
-		queues: make(map[string]*MessageQueue),
+		ctx:     ctx,
+		peer:    p,
+		queues:  make(map[string]*MessageQueue),
+		calls:   make(map[string]chan StreamMessage),
+		session: fmt.Sprintf("%x", time.Now().UnixNano()),
 	}
 
 	// Start idle stream monitor
 	go vcm.idleStreamMonitor()
 
+	// Reset unreachable queues whenever libp2p reports a new connection to their peer
+	if p.host != nil {
+		vcm.notifee = &network.NotifyBundle{
+			ConnectedF: func(_ network.Network, conn network.Conn) {
+				// Notifications are synchronous and may fire while a queue is dialing
+				go vcm.ResetQueues(conn.RemotePeer().String(), "")
+			},
+		}
+		p.host.Network().Notify(vcm.notifee)
+	}
+
 	return vcm
 }
 
-// SendToQueue adds a message to the queue for (peer, protocol)
-func (vcm *VirtualConnectionManager) SendToQueue(targetPeerID, protocolStr string, data any) error {
-	// Encode data as JSON
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		return fmt.Errorf("failed to marshal data: %w", err)
+// ResetQueues clears the unreachable state of the queues to a peer and retries pending messages
+// An empty protocol resets the queues for every protocol
+func (vcm *VirtualConnectionManager) ResetQueues(targetPeerID, protocolStr string) {
+	vcm.mu.RLock()
+	queues := make([]*MessageQueue, 0)
+	for _, queue := range vcm.queues {
+		if queue.peer == targetPeerID && (protocolStr == "" || queue.protocol == protocolStr) {
+			queues = append(queues, queue)
+		}
 	}
+	vcm.mu.RUnlock()
 
+	for _, queue := range queues {
+		if queue.reset() {
+			targetAlias := vcm.peer.manager.getOrCreateAlias(queue.peer)
+			vcm.peer.logVerbose(1, "Reset unreachable queue to %s on protocol %s", targetAlias, queue.protocol)
+		}
+	}
+}
+
+// newMessageQueue creates an empty queue for (peer, protocol)
+func newMessageQueue(vcm *VirtualConnectionManager, peerID, protocolStr string) *MessageQueue {
+	return &MessageQueue{
+		peer:             peerID,
+		protocol:         protocolStr,
+		messages:         make([]QueuedMessage, 0),
+		lastActivity:     time.Now(),
+		manager:          vcm,
+		streamReaderDone: make(chan struct{}),
+		acks:             make(chan string, 16),
+		ttl:              vcm.peer.manager.queueTTLFor(protocolStr),
+		nextSeq:          1,
+		maxMessages:      vcm.peer.manager.queueMaxMessages,
+		maxBytes:         vcm.peer.manager.queueMaxBytes,
+		policy:           vcm.peer.manager.queuePolicy,
+		spaceFreed:       make(chan struct{}),
+		recvPending:      make(map[uint64]receivedMessage),
+	}
+}
+
+// getOrCreateQueue returns the queue for (peer, protocol), creating it if needed
+func (vcm *VirtualConnectionManager) getOrCreateQueue(targetPeerID, protocolStr string) *MessageQueue {
 	queueKey := fmt.Sprintf("%s:%s", targetPeerID, protocolStr)
 
 	vcm.mu.Lock()
+	defer vcm.mu.Unlock()
+
 	queue, exists := vcm.queues[queueKey]
 	if !exists {
-		queue = &MessageQueue{
-			peer:             targetPeerID,
-			protocol:         protocolStr,
-			messages:         make([]QueuedMessage, 0),
-			lastActivity:     time.Now(),
-			manager:          vcm,
-			streamReaderDone: make(chan struct{}),
-		}
+		queue = newMessageQueue(vcm, targetPeerID, protocolStr)
 		vcm.queues[queueKey] = queue
 	}
-	vcm.mu.Unlock()
+	return queue
+}
+
+// persistent returns true if queued messages are written to the datastore
+func (vcm *VirtualConnectionManager) persistent() bool {
+	return vcm.peer.manager.queueStore != nil
+}
+
+// storeMessage persists a queued message (no-op without persistence)
+func (q *MessageQueue) storeMessage(msg QueuedMessage) {
+	if !q.manager.persistent() {
+		return
+	}
+	store := q.manager.peer.manager.queueStore
+	if err := store.put(q.manager.ctx, q.manager.peer.peerID.String(), q.peer, q.protocol, msg); err != nil {
+		q.manager.peer.logVerbose(1, "Failed to persist queued message for protocol %s: %v", q.protocol, err)
+	}
+}
+
+// forgetMessages removes messages from the datastore (no-op without persistence)
+func (q *MessageQueue) forgetMessages(msgs []QueuedMessage) {
+	if !q.manager.persistent() {
+		return
+	}
+	store := q.manager.peer.manager.queueStore
+	for _, msg := range msgs {
+		if err := store.delete(q.manager.ctx, q.manager.peer.peerID.String(), q.peer, q.protocol, msg.id); err != nil {
+			q.manager.peer.logVerbose(1, "Failed to remove persisted message for protocol %s: %v", q.protocol, err)
+		}
+	}
+}
+
+// RestoreQueues reloads persisted messages for this peer and retries them
+// Restored messages carry no client ack since the sending session is gone
+func (vcm *VirtualConnectionManager) RestoreQueues() error {
+	if !vcm.persistent() {
+		return nil
+	}
+
+	stored, err := vcm.peer.manager.queueStore.load(vcm.ctx, vcm.peer.peerID.String())
+	if err != nil {
+		return err
+	}
+
+	restored := make(map[*MessageQueue]bool)
+	for _, sm := range stored {
+		queue := vcm.getOrCreateQueue(sm.Peer, sm.Protocol)
+		msg := QueuedMessage{
+			id:          sm.ID,
+			data:        sm.Data,
+			maxAttempts: 3,
+			timestamp:   sm.Timestamp,
+			ack:         -1,
+			binary:      sm.Binary,
+		}
+
+		if queue.ttl > 0 && time.Since(msg.timestamp) > queue.ttl {
+			// Expired while we were offline
+			queue.forgetMessages([]QueuedMessage{msg})
+			continue
+		}
+
+		queue.mu.Lock()
+		queue.messages = append(queue.messages, msg)
+		queue.mu.Unlock()
+		restored[queue] = true
+	}
+
+	for queue := range restored {
+		queue.mu.Lock()
+		sort.SliceStable(queue.messages, func(i, j int) bool {
+			return queue.messages[i].timestamp.Before(queue.messages[j].timestamp)
+		})
+		// Sequence numbers restart with the new session
+		for i := range queue.messages {
+			queue.messages[i].seq = queue.nextSeq
+			queue.nextSeq++
+		}
+		count := len(queue.messages)
+		queue.mu.Unlock()
+
+		targetAlias := vcm.peer.manager.getOrCreateAlias(queue.peer)
+		vcm.peer.logVerbose(1, "Restored %d queued messages to %s on protocol %s", count, targetAlias, queue.protocol)
+		go queue.processQueue()
+	}
+
+	return nil
+}
+
+// SendToQueue adds a message to the queue for (peer, protocol)
+// If ack >= 0, the ack callback fires once the remote peer acknowledges the message
+// []byte data is delivered as raw bytes, anything else as JSON
+// A full queue applies its policy; failures wrap ErrQueueFull
+func (vcm *VirtualConnectionManager) SendToQueue(targetPeerID, protocolStr string, data any, ack int) error {
+	payload, binary, err := encodePayload(data)
+	if err != nil {
+		return err
+	}
+
+	queue := vcm.getOrCreateQueue(targetPeerID, protocolStr)
+	queue.sendMu.Lock()
+	defer queue.sendMu.Unlock()
 
 	// Add message to queue
 	queue.mu.Lock()
+	dropped, err := queue.makeRoomLocked(len(payload))
+	if len(dropped) > 0 {
+		defer func() {
+			targetAlias := vcm.peer.manager.getOrCreateAlias(targetPeerID)
+			vcm.peer.logVerbose(1, "Dropped %d queued messages to %s on protocol %s to make room", len(dropped), targetAlias, protocolStr)
+			queue.forgetMessages(dropped)
+			queue.reportAbandoned(dropped, fmt.Errorf("%w: dropped to make room for newer messages", ErrQueueFull))
+		}()
+	}
+	if err != nil {
+		queue.mu.Unlock()
+		return err
+	}
+	if queue.unreachable && !vcm.persistent() {
+		// Persistent queues keep accepting messages (store-and-forward) until their TTL expires
+		queue.mu.Unlock()
+		return fmt.Errorf("peer %s is unreachable on protocol %s", targetPeerID, protocolStr)
+	}
 	msg := QueuedMessage{
-		id:          fmt.Sprintf("%d-%d", time.Now().UnixNano(), len(queue.messages)),
-		data:        jsonData,
+		id:          fmt.Sprintf("%d-%d", time.Now().UnixNano(), queue.nextSeq),
+		seq:         queue.nextSeq,
+		data:        payload,
 		attempts:    0,
 		maxAttempts: 3,
 		timestamp:   time.Now(),
+		ack:         ack,
+		binary:      binary,
 	}
+	queue.nextSeq++
+	queue.mu.Unlock()
+
+	// Persist before the message becomes visible to processQueue so delivery can't race the write,
+	// without holding mu so acks, readers and processQueue don't wait on disk I/O
+	queue.storeMessage(msg)
+
+	queue.mu.Lock()
 	queue.messages = append(queue.messages, msg)
-	shouldProcess := !queue.processing
+	shouldProcess := !queue.processing && !queue.unreachable
 	queue.mu.Unlock()
 
 	// Trigger processing if not already processing
@@ -113,6 +367,82 @@ func (vcm *VirtualConnectionManager) SendToQueue(targetPeerID, protocolStr strin
 	return nil
 }
 
+// queuedBytes returns the payload bytes of msgs
+func queuedBytes(msgs []QueuedMessage) int64 {
+	var total int64
+	for _, msg := range msgs {
+		total += int64(len(msg.data))
+	}
+	return total
+}
+
+// fits returns true if a message of size bytes can join msgs within the queue's limits
+func (q *MessageQueue) fits(msgs []QueuedMessage, size int) bool {
+	if q.maxMessages > 0 && len(msgs) >= q.maxMessages {
+		return false
+	}
+	return q.maxBytes <= 0 || queuedBytes(msgs)+int64(size) <= q.maxBytes
+}
+
+// makeRoomLocked applies the queue's policy before adding a message of size bytes (caller must hold mu)
+// Returns the messages dropped to make room; the block policy releases mu while it waits
+func (q *MessageQueue) makeRoomLocked(size int) ([]QueuedMessage, error) {
+	if q.fits(q.messages, size) {
+		return nil, nil
+	}
+	if q.maxBytes > 0 && int64(size) > q.maxBytes {
+		return nil, fmt.Errorf("%w: %d byte message exceeds the %d byte limit for protocol %s", ErrQueueFull, size, q.maxBytes, q.protocol)
+	}
+
+	switch q.policy {
+	case QueuePolicyDropOldest:
+		// The head may be in flight, so processQueue keeps it
+		keep := 0
+		if q.processing && len(q.messages) > 0 {
+			keep = 1
+		}
+		if !q.fits(q.messages[:keep], size) {
+			return nil, fmt.Errorf("%w: message in flight leaves no room on protocol %s", ErrQueueFull, q.protocol)
+		}
+		dropped := make([]QueuedMessage, 0)
+		for !q.fits(q.messages, size) {
+			dropped = append(dropped, q.messages[keep])
+			q.messages = append(q.messages[:keep], q.messages[keep+1:]...)
+		}
+		return dropped, nil
+
+	case QueuePolicyBlock:
+		timer := time.NewTimer(q.manager.peer.manager.streamTimeout)
+		defer timer.Stop()
+		for !q.fits(q.messages, size) {
+			freed := q.spaceFreed
+			q.mu.Unlock()
+			select {
+			case <-freed:
+				q.mu.Lock()
+			case <-timer.C:
+				q.mu.Lock()
+				return nil, fmt.Errorf("%w: timed out waiting for room on protocol %s", ErrQueueFull, q.protocol)
+			case <-q.manager.ctx.Done():
+				q.mu.Lock()
+				return nil, q.manager.ctx.Err()
+			}
+		}
+		return nil, nil
+
+	default:
+		return nil, fmt.Errorf("%w: %d messages (%d bytes) waiting on protocol %s", ErrQueueFull, len(q.messages), queuedBytes(q.messages), q.protocol)
+	}
+}
+
+// signalSpaceLocked wakes senders blocked on a full queue (caller must hold mu)
+func (q *MessageQueue) signalSpaceLocked() {
+	if q.spaceFreed != nil {
+		close(q.spaceFreed)
+	}
+	q.spaceFreed = make(chan struct{})
+}
+
 // processQueue processes queued messages for this queue
 func (q *MessageQueue) processQueue() {
 	q.mu.Lock()
@@ -144,24 +474,33 @@ func (q *MessageQueue) processQueue() {
 
 		// Get next message
 		msg := q.messages[0]
+		if q.ttl > 0 && time.Since(msg.timestamp) > q.ttl {
+			// Expired between attempts: expireMessages leaves the head to us, so it is never
+			// reported as failed while it is in flight and could still be acked
+			q.messages = q.messages[1:]
+			q.signalSpaceLocked()
+			q.mu.Unlock()
+			q.expire([]QueuedMessage{msg})
+			continue
+		}
 		q.mu.Unlock()
 
 		// Get or create stream
 		if err := q.ensureStream(); err != nil {
-			q.handleSendFailure(msg.id)
+			q.handleSendFailure(msg.id, err)
 			continue
 		}
 
 		// Send message
 		if err := q.sendMessage(msg); err != nil {
-			q.handleSendFailure(msg.id)
+			q.handleSendFailure(msg.id, err)
 			continue
 		}
 
 		// Wait for ACK with timeout
 		acked := q.waitForAck(msg.id, 5*time.Second)
 		if !acked {
-			q.handleSendFailure(msg.id)
+			q.handleSendFailure(msg.id, fmt.Errorf("no acknowledgment from peer"))
 			continue
 		}
 
@@ -169,10 +508,17 @@ func (q *MessageQueue) processQueue() {
 		q.mu.Lock()
 		if len(q.messages) > 0 && q.messages[0].id == msg.id {
 			q.messages = q.messages[1:]
+			q.signalSpaceLocked()
 		}
 		q.lastActivity = time.Now()
 		q.retryCount = 0 // Reset retry count on success
 		q.mu.Unlock()
+		q.forgetMessages([]QueuedMessage{msg})
+
+		// Report delivery now that the remote peer has acknowledged the message
+		if msg.ack >= 0 {
+			q.manager.peer.manager.notifySendAck(q.manager.peer.peerID.String(), msg.ack)
+		}
 	}
 }
 
@@ -210,21 +556,18 @@ func (q *MessageQueue) ensureStream() error {
 		}
 	}
 
-	// Open stream
-	pid := protocol.ID(q.protocol)
-	stream, err := q.manager.peer.host.NewStream(q.manager.ctx, targetPeerID, pid)
+	// Open stream, framed unless the remote only speaks the legacy protocol
+	pids := q.manager.peer.manager.streamProtocols(q.protocol)
+	stream, err := q.manager.peer.host.NewStream(q.manager.ctx, targetPeerID, pids...)
 	if err != nil {
 		return fmt.Errorf("failed to open stream: %w", err)
 	}
 
 	q.stream = stream
 	q.lastActivity = time.Now()
-	// Reset the done channel and Once for the new stream reader
-	q.streamReaderDone = make(chan struct{})
-	q.streamReaderDoneOnce = sync.Once{}
 
 	// Start reading ACKs and data from stream
-	go q.readFromStream()
+	q.startReaderLocked(stream)
 
 	// Log connection
 	targetAlias := q.manager.peer.manager.getOrCreateAlias(q.peer)
@@ -237,6 +580,11 @@ func (q *MessageQueue) ensureStream() error {
 func (q *MessageQueue) sendMessage(msg QueuedMessage) error {
 	q.mu.Lock()
 	stream := q.stream
+	// Everything before the head of the queue was delivered, abandoned or expired
+	base := msg.seq
+	if len(q.messages) > 0 && q.messages[0].seq < base {
+		base = q.messages[0].seq
+	}
 	q.mu.Unlock()
 
 	if stream == nil {
@@ -244,17 +592,17 @@ func (q *MessageQueue) sendMessage(msg QueuedMessage) error {
 	}
 
 	streamMsg := StreamMessage{
-		Type: "data",
-		ID:   msg.id,
-		Data: msg.data,
-	}
-
-	msgBytes, err := json.Marshal(streamMsg)
-	if err != nil {
-		return fmt.Errorf("failed to marshal stream message: %w", err)
+		Type:    "data",
+		ID:      msg.id,
+		Data:    msg.data,
+		Binary:  msg.binary,
+		Session: q.manager.session,
+		Seq:     msg.seq,
+		Base:    base,
+		SentAt:  msg.timestamp.UnixMilli(),
 	}
 
-	if err := writeMessage(stream, msgBytes); err != nil {
+	if err := q.writeStreamMessage(stream, streamMsg); err != nil {
 		// Stream failed, close it
 		q.mu.Lock()
 		q.closeStreamLocked()
@@ -274,19 +622,55 @@ func (q *MessageQueue) sendMessage(msg QueuedMessage) error {
 }
 
 // waitForAck waits for an ACK for a specific message ID
+// Returns false if the timeout expires or the stream closes before the ACK arrives
 func (q *MessageQueue) waitForAck(msgID string, timeout time.Duration) bool {
-	// For now, simulate immediate ACK since we don't have the full ACK infrastructure
-	// In a full implementation, this would wait for an actual ACK message from the remote peer
-	time.Sleep(10 * time.Millisecond)
-	return true
+	q.mu.Lock()
+	readerDone := q.streamReaderDone
+	q.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case id := <-q.acks:
+			if id == msgID {
+				return true
+			}
+			// Stale ACK for an earlier attempt - keep waiting
+		case <-readerDone:
+			// Stream closed, the ACK can no longer arrive on it
+			return false
+		case <-timer.C:
+			targetAlias := q.manager.peer.manager.getOrCreateAlias(q.peer)
+			q.manager.peer.logVerbose(2, "Timed out waiting for ACK from %s on protocol %s", targetAlias, q.protocol)
+			return false
+		case <-q.manager.ctx.Done():
+			return false
+		}
+	}
+}
+
+// handleAck records an ACK received from the remote peer for waitForAck
+func (q *MessageQueue) handleAck(msgID string) {
+	q.mu.Lock()
+	q.lastActivity = time.Now()
+	q.mu.Unlock()
+
+	select {
+	case q.acks <- msgID:
+	default:
+		// Nobody is waiting and the buffer is full - drop the stale ACK
+	}
 }
 
 // handleSendFailure handles a failed send attempt
-func (q *MessageQueue) handleSendFailure(msgID string) {
+// After max attempts the queue is marked unreachable and its messages are abandoned
+func (q *MessageQueue) handleSendFailure(msgID string, cause error) {
 	q.mu.Lock()
-	defer q.mu.Unlock()
 
 	if len(q.messages) == 0 || q.messages[0].id != msgID {
+		q.mu.Unlock()
 		return
 	}
 
@@ -297,46 +681,103 @@ func (q *MessageQueue) handleSendFailure(msgID string) {
 		// Mark peer unreachable after max attempts
 		q.unreachable = true
 		targetAlias := q.manager.peer.manager.getOrCreateAlias(q.peer)
-		q.manager.peer.logVerbose(1, "Peer %s marked unreachable after %d attempts", targetAlias, q.messages[0].attempts)
+		q.manager.peer.logVerbose(1, "Peer %s marked unreachable after %d attempts: %v", targetAlias, q.messages[0].attempts, cause)
+
+		if q.manager.persistent() {
+			// Store-and-forward: keep messages until the peer reconnects or they expire
+			q.messages[0].attempts = 0
+			q.mu.Unlock()
+			return
+		}
+
+		// Abandon queued messages so they don't sit in the queue forever
+		abandoned := q.messages
+		q.messages = make([]QueuedMessage, 0)
+		q.signalSpaceLocked()
+		q.mu.Unlock()
+
+		q.reportAbandoned(abandoned, cause)
 		return
 	}
 
-	// Exponential backoff
+	// Exponential backoff, without holding mu so senders and the stream reader keep running
 	backoff := time.Duration(1<<uint(q.messages[0].attempts)) * time.Second
-	time.Sleep(backoff)
+	q.mu.Unlock()
+	select {
+	case <-time.After(backoff):
+	case <-q.manager.ctx.Done():
+	}
 }
 
-// readFromStream reads messages from the stream
-func (q *MessageQueue) readFromStream() {
-	defer func() {
-		q.streamReaderDoneOnce.Do(func() {
-			close(q.streamReaderDone)
-		})
-	}()
+// reportAbandoned notifies the client which acked messages will never be delivered
+func (q *MessageQueue) reportAbandoned(abandoned []QueuedMessage, cause error) {
+	acks := make([]int, 0, len(abandoned))
+	for _, msg := range abandoned {
+		if msg.ack >= 0 {
+			acks = append(acks, msg.ack)
+		}
+	}
+
+	q.manager.peer.manager.notifySendFailed(q.manager.peer.peerID.String(), q.peer, q.protocol, acks, cause.Error())
+}
 
+// reset clears the unreachable flag so the queue accepts and retries messages again
+// Returns true if the queue was unreachable
+func (q *MessageQueue) reset() bool {
 	q.mu.Lock()
-	stream := q.stream
-	q.mu.Unlock()
+	defer q.mu.Unlock()
 
-	if stream == nil {
-		return
+	if !q.unreachable {
+		return false
+	}
+	q.unreachable = false
+	q.retryCount = 0
+
+	// Retry queued messages
+	if !q.processing && len(q.messages) > 0 {
+		go q.processQueue()
 	}
+	return true
+}
 
+// startReaderLocked starts reading a new stream (caller must hold mu)
+// Each reader gets its own done channel, so a replaced stream's reader ending can't wake waitForAck on the new one
+func (q *MessageQueue) startReaderLocked(stream network.Stream) {
+	done := make(chan struct{})
+	q.streamReaderDone = done
+	go q.readFromStream(stream, done)
+}
+
+// readFromStream reads messages from the stream, closing done when the stream ends
+func (q *MessageQueue) readFromStream(stream network.Stream, done chan struct{}) {
+	defer close(done)
+
+	framed := isFramed(stream.Protocol())
 	for {
-		data, err := readMessage(stream)
+		// Framed streams send each message as a header frame followed by a body frame
+		header, err := readMessage(stream)
+		var body []byte
+		if err == nil && framed {
+			body, err = readMessage(stream)
+		}
 		if err != nil {
 			if err != io.EOF {
 				peerAlias := q.manager.peer.manager.getOrCreateAlias(q.peer)
 				q.manager.peer.logVerbose(2, "Error reading from stream to %s on protocol %s: %v", peerAlias, q.protocol, err)
 			}
 			q.mu.Lock()
-			q.closeStreamLocked()
+			if q.stream == stream {
+				q.closeStreamLocked()
+			} else {
+				// Replaced by a newer stream, or the remote's side of a simultaneous open
+				stream.Close()
+			}
 			q.mu.Unlock()
 			return
 		}
 
-		var streamMsg StreamMessage
-		if err := json.Unmarshal(data, &streamMsg); err != nil {
+		streamMsg, err := decodeStreamMessage(header, body, framed)
+		if err != nil {
 			peerAlias := q.manager.peer.manager.getOrCreateAlias(q.peer)
 			q.manager.peer.logVerbose(1, "Error unmarshaling stream message from %s: %v", peerAlias, err)
 			continue
@@ -344,63 +785,137 @@ func (q *MessageQueue) readFromStream() {
 
 		switch streamMsg.Type {
 		case "ack":
-			// ACK received - would be handled by waitForAck in full implementation
-			q.mu.Lock()
-			q.lastActivity = time.Now()
-			q.mu.Unlock()
+			// ACK received - wake up waitForAck
+			q.handleAck(streamMsg.ID)
 
 		case "data":
 			// Data received from peer
-			q.handleIncomingData(streamMsg.ID, streamMsg.Data)
+			q.handleIncomingData(streamMsg)
+
+		case "call":
+			// Request from peer - answer in the background so the reader keeps running
+			go q.handleIncomingCall(streamMsg)
+
+		case "reply":
+			// Reply to one of our calls - wake up Call
+			q.manager.handleReply(streamMsg)
 		}
 	}
 }
 
 // handleIncomingData handles data received from a peer
-func (q *MessageQueue) handleIncomingData(msgID string, data []byte) {
-	// Decode JSON
-	var decoded any
-	if err := json.Unmarshal(data, &decoded); err != nil {
+// Sequenced messages are deduplicated and delivered in order; duplicates are re-acked but not delivered
+func (q *MessageQueue) handleIncomingData(streamMsg StreamMessage) {
+	// Clear unreachable flag - peer is reachable again
+	if q.reset() {
+		targetAlias := q.manager.peer.manager.getOrCreateAlias(q.peer)
+		q.manager.peer.logVerbose(1, "Peer %s is reachable again", targetAlias)
+	}
+	q.mu.Lock()
+	q.lastActivity = time.Now()
+	q.mu.Unlock()
+
+	q.recvMu.Lock()
+	defer q.recvMu.Unlock()
+
+	if streamMsg.Seq == 0 {
+		// Unsequenced sender - deliver as received
+		decoded, err := decodePayload(streamMsg.Data, streamMsg.Binary)
+		if err != nil {
+			fmt.Printf("Error unmarshaling data: %v\n", err)
+			return
+		}
+		q.sendAck(streamMsg.ID)
+		q.deliver(receivedMessage{decoded, receivedMetadata(streamMsg)})
+		return
+	}
+
+	q.syncReceiveWindowLocked(streamMsg)
+
+	_, buffered := q.recvPending[streamMsg.Seq]
+	if streamMsg.Seq < q.recvNext || buffered {
+		// Already delivered or waiting - the ACK was probably lost, so resend it
+		remoteAlias := q.manager.peer.manager.getOrCreateAlias(q.peer)
+		q.manager.peer.logVerbose(2, "Dropped duplicate message %d from %s on protocol %s", streamMsg.Seq, remoteAlias, q.protocol)
+		q.sendAck(streamMsg.ID)
+		return
+	}
+	if streamMsg.Seq-q.recvNext >= reorderWindow {
+		// Too far ahead to buffer - leave it unacked so the sender retries later
+		return
+	}
+	decoded, err := decodePayload(streamMsg.Data, streamMsg.Binary)
+	if err != nil {
 		fmt.Printf("Error unmarshaling data: %v\n", err)
 		return
 	}
 
-	// Clear unreachable flag - peer is reachable again
-	q.mu.Lock()
-	if q.unreachable {
-		q.unreachable = false
-		targetAlias := q.manager.peer.manager.getOrCreateAlias(q.peer)
-		q.manager.peer.logVerbose(1, "Peer %s is reachable again", targetAlias)
+	q.recvPending[streamMsg.Seq] = receivedMessage{decoded, receivedMetadata(streamMsg)}
+	q.sendAck(streamMsg.ID)
 
-		// Retry queued messages
-		if !q.processing && len(q.messages) > 0 {
-			go q.processQueue()
+	// Deliver everything that is now in order
+	for {
+		next, ok := q.recvPending[q.recvNext]
+		if !ok {
+			break
 		}
+		delete(q.recvPending, q.recvNext)
+		q.recvNext++
+		q.deliver(next)
 	}
-	q.lastActivity = time.Now()
-	q.mu.Unlock()
+}
 
-	// Send ACK
+// syncReceiveWindowLocked aligns the receive state with the sender (caller must hold recvMu)
+// A new session means the sender restarted; a higher base means it gave up on earlier messages
+func (q *MessageQueue) syncReceiveWindowLocked(streamMsg StreamMessage) {
+	if streamMsg.Session != q.recvSession {
+		q.recvSession = streamMsg.Session
+		q.recvNext = streamMsg.Base
+		q.recvPending = make(map[uint64]receivedMessage)
+	}
+	if streamMsg.Base > q.recvNext {
+		q.recvNext = streamMsg.Base
+		for seq := range q.recvPending {
+			if seq < q.recvNext {
+				delete(q.recvPending, seq)
+			}
+		}
+	}
+}
+
+// sendAck acknowledges a data message on the current stream
+func (q *MessageQueue) sendAck(msgID string) {
 	ackMsg := StreamMessage{
 		Type: "ack",
 		ID:   msgID,
 	}
-	ackBytes, err := json.Marshal(ackMsg)
-	if err != nil {
-		fmt.Printf("Error marshaling ACK: %v\n", err)
-		return
-	}
 
 	q.mu.Lock()
 	stream := q.stream
 	q.mu.Unlock()
 
 	if stream != nil {
-		if err := writeMessage(stream, ackBytes); err != nil {
+		if err := q.writeStreamMessage(stream, ackMsg); err != nil {
 			fmt.Printf("Error sending ACK: %v\n", err)
 		}
 	}
+}
+
+// receivedMetadata describes a data message as it arrives
+func receivedMetadata(streamMsg StreamMessage) MessageMetadata {
+	meta := MessageMetadata{
+		ID:         streamMsg.ID,
+		Seqno:      streamMsg.Seq,
+		ReceivedAt: time.Now(),
+	}
+	if streamMsg.SentAt > 0 {
+		meta.SentAt = time.UnixMilli(streamMsg.SentAt)
+	}
+	return meta
+}
 
+// deliver hands a decoded message to the application
+func (q *MessageQueue) deliver(msg receivedMessage) {
 	// Log received message
 	remoteAlias := q.manager.peer.manager.getOrCreateAlias(q.peer)
 	q.manager.peer.logVerbose(2, "Received message from %s on protocol %s", remoteAlias, q.protocol)
@@ -411,11 +926,181 @@ func (q *MessageQueue) handleIncomingData(msgID string, data []byte) {
 			q.manager.peer.peerID.String(),
 			q.peer,
 			q.protocol,
-			decoded,
+			msg.data,
+			msg.meta,
 		)
 	}
 }
 
+// writeStreamMessage writes a stream message's header and body frames, serializing concurrent writers
+func (q *MessageQueue) writeStreamMessage(stream network.Stream, streamMsg StreamMessage) error {
+	framed := isFramed(stream.Protocol())
+	var header []byte
+	var err error
+	if framed {
+		header, err = json.Marshal(streamMsg)
+	} else {
+		header, err = json.Marshal(legacyStreamMessage{StreamMessage: streamMsg, Data: streamMsg.Data})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to marshal stream message: %w", err)
+	}
+
+	q.writeMu.Lock()
+	defer q.writeMu.Unlock()
+	if err := writeMessage(stream, header); err != nil || !framed {
+		return err
+	}
+	return writeMessage(stream, streamMsg.Data)
+}
+
+// decodeStreamMessage decodes a framed message's header and body, or a legacy message's single frame
+func decodeStreamMessage(header, body []byte, framed bool) (StreamMessage, error) {
+	if !framed {
+		var legacy legacyStreamMessage
+		err := json.Unmarshal(header, &legacy)
+		legacy.StreamMessage.Data = legacy.Data
+		return legacy.StreamMessage, err
+	}
+	var streamMsg StreamMessage
+	err := json.Unmarshal(header, &streamMsg)
+	streamMsg.Data = body
+	return streamMsg, err
+}
+
+// Call sends a request to a peer on a protocol and waits for the remote application's reply
+// Calls bypass the message queue: they are not persisted, retried or acked
+func (vcm *VirtualConnectionManager) Call(targetPeerID, protocolStr string, data any, timeout time.Duration) (any, error) {
+	payload, binary, err := encodePayload(data)
+	if err != nil {
+		return nil, err
+	}
+
+	queue := vcm.getOrCreateQueue(targetPeerID, protocolStr)
+	if err := queue.ensureStream(); err != nil {
+		return nil, err
+	}
+	queue.mu.Lock()
+	stream := queue.stream
+	queue.mu.Unlock()
+	if stream == nil {
+		return nil, fmt.Errorf("no stream available")
+	}
+
+	// Register for the reply before sending so it can't be missed
+	callID := fmt.Sprintf("call-%d", atomic.AddUint64(&vcm.nextCallID, 1))
+	replyCh := make(chan StreamMessage, 1)
+	vcm.mu.Lock()
+	vcm.calls[callID] = replyCh
+	vcm.mu.Unlock()
+	defer func() {
+		vcm.mu.Lock()
+		delete(vcm.calls, callID)
+		vcm.mu.Unlock()
+	}()
+
+	call := StreamMessage{
+		Type:    "call",
+		ID:      callID,
+		Data:    payload,
+		Binary:  binary,
+		Timeout: timeout.Milliseconds(),
+	}
+	if err := queue.writeStreamMessage(stream, call); err != nil {
+		queue.mu.Lock()
+		if queue.stream == stream {
+			queue.closeStreamLocked()
+		}
+		queue.mu.Unlock()
+		return nil, fmt.Errorf("failed to write call: %w", err)
+	}
+
+	targetAlias := vcm.peer.manager.getOrCreateAlias(targetPeerID)
+	vcm.peer.logVerbose(2, "Sent call to %s on protocol %s", targetAlias, protocolStr)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case reply := <-replyCh:
+		if reply.Error != "" {
+			return nil, fmt.Errorf("remote peer error: %s", reply.Error)
+		}
+		if len(reply.Data) == 0 && !reply.Binary {
+			return nil, nil
+		}
+		decoded, err := decodePayload(reply.Data, reply.Binary)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal reply: %w", err)
+		}
+		return decoded, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("call to %s on protocol %s timed out after %v", targetAlias, protocolStr, timeout)
+	case <-vcm.ctx.Done():
+		return nil, vcm.ctx.Err()
+	}
+}
+
+// handleReply hands a reply to the Call waiting for it
+func (vcm *VirtualConnectionManager) handleReply(reply StreamMessage) {
+	vcm.mu.RLock()
+	replyCh, exists := vcm.calls[reply.ID]
+	vcm.mu.RUnlock()
+
+	if !exists {
+		// The call already timed out
+		return
+	}
+
+	select {
+	case replyCh <- reply:
+	default:
+	}
+}
+
+// handleIncomingCall asks the application to answer a call from a peer and writes the reply
+func (q *MessageQueue) handleIncomingCall(call StreamMessage) {
+	remoteAlias := q.manager.peer.manager.getOrCreateAlias(q.peer)
+	q.manager.peer.logVerbose(2, "Received call from %s on protocol %s", remoteAlias, q.protocol)
+
+	q.mu.Lock()
+	q.lastActivity = time.Now()
+	q.mu.Unlock()
+
+	reply := StreamMessage{
+		Type: "reply",
+		ID:   call.ID,
+	}
+
+	decoded, err := decodePayload(call.Data, call.Binary)
+	if err != nil {
+		reply.Error = fmt.Sprintf("invalid call data: %v", err)
+	} else {
+		timeout := time.Duration(call.Timeout) * time.Millisecond
+		result, err := q.manager.peer.manager.handlePeerCall(q.manager.peer.peerID.String(), q.peer, q.protocol, decoded, timeout)
+		if err != nil {
+			reply.Error = err.Error()
+		} else if reply.Data, reply.Binary, err = encodePayload(result); err != nil {
+			reply.Error = err.Error()
+		}
+	}
+
+	// The stream may have been closed while the application was answering
+	if err := q.ensureStream(); err != nil {
+		q.manager.peer.logVerbose(1, "Failed to send reply to %s on protocol %s: %v", remoteAlias, q.protocol, err)
+		return
+	}
+	q.mu.Lock()
+	stream := q.stream
+	q.mu.Unlock()
+
+	if stream != nil {
+		if err := q.writeStreamMessage(stream, reply); err != nil {
+			q.manager.peer.logVerbose(1, "Failed to send reply to %s on protocol %s: %v", remoteAlias, q.protocol, err)
+		}
+	}
+}
+
 // closeStreamLocked closes the stream (caller must hold mu)
 func (q *MessageQueue) closeStreamLocked() {
 	if q.stream != nil {
@@ -424,7 +1109,7 @@ func (q *MessageQueue) closeStreamLocked() {
 	}
 }
 
-// idleStreamMonitor monitors streams for inactivity
+// idleStreamMonitor monitors streams for inactivity and expires stale messages
 func (vcm *VirtualConnectionManager) idleStreamMonitor() {
 	ticker := time.NewTicker(10 * time.Second)
 	defer ticker.Stop()
@@ -435,10 +1120,59 @@ func (vcm *VirtualConnectionManager) idleStreamMonitor() {
 			return
 		case <-ticker.C:
 			vcm.checkIdleStreams()
+			vcm.expireMessages()
+		}
+	}
+}
+
+// expireMessages drops queued messages older than their queue's TTL and reports them
+func (vcm *VirtualConnectionManager) expireMessages() {
+	vcm.mu.RLock()
+	queues := make([]*MessageQueue, 0, len(vcm.queues))
+	for _, queue := range vcm.queues {
+		queues = append(queues, queue)
+	}
+	vcm.mu.RUnlock()
+
+	now := time.Now()
+	for _, queue := range queues {
+		queue.mu.Lock()
+		if queue.ttl <= 0 {
+			queue.mu.Unlock()
+			continue
+		}
+		kept := make([]QueuedMessage, 0, len(queue.messages))
+		expired := make([]QueuedMessage, 0)
+		for i, msg := range queue.messages {
+			if i == 0 && queue.processing {
+				// The head may be in flight; processQueue expires it before its next attempt
+				kept = append(kept, msg)
+			} else if now.Sub(msg.timestamp) > queue.ttl {
+				expired = append(expired, msg)
+			} else {
+				kept = append(kept, msg)
+			}
+		}
+		queue.messages = kept
+		if len(expired) > 0 {
+			queue.signalSpaceLocked()
+		}
+		queue.mu.Unlock()
+
+		if len(expired) > 0 {
+			queue.expire(expired)
 		}
 	}
 }
 
+// expire forgets messages removed from the queue for outliving its TTL and reports them
+func (q *MessageQueue) expire(expired []QueuedMessage) {
+	targetAlias := q.manager.peer.manager.getOrCreateAlias(q.peer)
+	q.manager.peer.logVerbose(1, "Expired %d queued messages to %s on protocol %s", len(expired), targetAlias, q.protocol)
+	q.forgetMessages(expired)
+	q.reportAbandoned(expired, fmt.Errorf("message expired after %v", q.ttl))
+}
+
 // checkIdleStreams closes streams that have been idle for too long
 func (vcm *VirtualConnectionManager) checkIdleStreams() {
 	now := time.Now()
@@ -462,47 +1196,40 @@ func (vcm *VirtualConnectionManager) checkIdleStreams() {
 // HandleIncomingStream handles a stream initiated by a remote peer
 func (vcm *VirtualConnectionManager) HandleIncomingStream(stream network.Stream) {
 	remotePeerID := stream.Conn().RemotePeer().String()
-	protocolStr := string(stream.Protocol())
-	queueKey := fmt.Sprintf("%s:%s", remotePeerID, protocolStr)
+	protocolStr := vcm.peer.startedProtocol(stream.Protocol())
 
-	vcm.mu.Lock()
-	queue, exists := vcm.queues[queueKey]
-	if !exists {
-		queue = &MessageQueue{
-			peer:             remotePeerID,
-			protocol:         protocolStr,
-			messages:         make([]QueuedMessage, 0),
-			lastActivity:     time.Now(),
-			manager:          vcm,
-			streamReaderDone: make(chan struct{}),
-		}
-		vcm.queues[queueKey] = queue
-	}
-	vcm.mu.Unlock()
+	queue := vcm.getOrCreateQueue(remotePeerID, protocolStr)
 
 	// Set the stream on the queue
 	queue.mu.Lock()
+	if queue.stream != nil && queue.stream.Stat().Direction == network.DirOutbound && vcm.peer.peerID < stream.Conn().RemotePeer() {
+		// Both peers opened a stream at once: both keep the one opened by the lower peer ID,
+		// so keep ours and read the remote's until it switches over and closes it
+		queue.mu.Unlock()
+		go queue.readFromStream(stream, make(chan struct{}))
+		return
+	}
 	if queue.stream != nil {
 		// Close old stream
 		queue.stream.Close()
 	}
 	queue.stream = stream
 	queue.lastActivity = time.Now()
-	// Reset the done channel and Once for the new stream reader
-	queue.streamReaderDone = make(chan struct{})
-	queue.streamReaderDoneOnce = sync.Once{}
+	// Start reading from stream
+	queue.startReaderLocked(stream)
 	queue.mu.Unlock()
 
 	// Log incoming connection
 	remoteAlias := vcm.peer.manager.getOrCreateAlias(remotePeerID)
 	vcm.peer.logVerbose(1, "Accepted connection from %s on protocol %s", remoteAlias, protocolStr)
-
-	// Start reading from stream
-	go queue.readFromStream()
 }
 
 // Close cleans up all queues and streams
 func (vcm *VirtualConnectionManager) Close() error {
+	if vcm.notifee != nil {
+		vcm.peer.host.Network().StopNotify(vcm.notifee)
+	}
+
 	vcm.mu.Lock()
 	defer vcm.mu.Unlock()
 
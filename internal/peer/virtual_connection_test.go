@@ -2,8 +2,16 @@ package peer
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"testing"
 	"time"
+
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/peer"
 )
 
 // TestVirtualConnectionManager_QueueCreation tests that queues are created correctly
@@ -67,7 +75,7 @@ func TestVirtualConnectionManager_SendToQueue(t *testing.T) {
 	protocol := "/test/1.0.0"
 	data := map[string]string{"message": "hello"}
 
-	err := vcm.SendToQueue(targetPeerID, protocol, data)
+	err := vcm.SendToQueue(targetPeerID, protocol, data, -1)
 	if err != nil {
 		t.Fatalf("SendToQueue should not return error for valid data: %v", err)
 	}
@@ -169,7 +177,7 @@ func TestVirtualConnectionManager_MultipleQueues(t *testing.T) {
 	}
 
 	for _, combo := range combinations {
-		err := vcm.SendToQueue(combo.peerID, combo.protocol, map[string]string{"test": "data"})
+		err := vcm.SendToQueue(combo.peerID, combo.protocol, map[string]string{"test": "data"}, -1)
 		if err != nil {
 			t.Fatalf("SendToQueue failed for %s/%s: %v", combo.peerID, combo.protocol, err)
 		}
@@ -208,8 +216,8 @@ func TestVirtualConnectionManager_Close(t *testing.T) {
 	vcm := NewVirtualConnectionManager(ctx, peer)
 
 	// Create some queues
-	vcm.SendToQueue("peer1", "/test/1.0.0", map[string]string{"test": "data"})
-	vcm.SendToQueue("peer2", "/test/1.0.0", map[string]string{"test": "data"})
+	vcm.SendToQueue("peer1", "/test/1.0.0", map[string]string{"test": "data"}, -1)
+	vcm.SendToQueue("peer2", "/test/1.0.0", map[string]string{"test": "data"}, -1)
 
 	time.Sleep(50 * time.Millisecond)
 
@@ -246,3 +254,821 @@ func TestQueuedMessage_Properties(t *testing.T) {
 		t.Errorf("Data mismatch: got %s", string(msg.data))
 	}
 }
+
+// newTestVCMPeer creates a peer with a real libp2p host and a virtual connection manager
+func newTestVCMPeer(t *testing.T, manager *Manager) *Peer {
+	t.Helper()
+
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatalf("Failed to create libp2p host: %v", err)
+	}
+	t.Cleanup(func() { h.Close() })
+
+	p := &Peer{
+		ctx:     manager.ctx,
+		host:    h,
+		peerID:  h.ID(),
+		manager: manager,
+	}
+	p.vcm = NewVirtualConnectionManager(manager.ctx, p)
+	t.Cleanup(func() { p.vcm.Close() })
+
+	manager.mu.Lock()
+	manager.peers[h.ID().String()] = p
+	manager.mu.Unlock()
+
+	return p
+}
+
+// TestVirtualConnectionManager_AckAfterRemoteDelivery tests that the send ack fires only after the remote peer acknowledges
+func TestVirtualConnectionManager_AckAfterRemoteDelivery(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	manager := &Manager{
+		ctx:         ctx,
+		peers:       make(map[string]*Peer),
+		peerAliases: make(map[string]string),
+		verbosity:   0,
+	}
+
+	received := make(chan any, 1)
+	manager.onPeerData = func(receiverPeerID, senderPeerID, protocol string, data any, meta MessageMetadata) {
+		received <- data
+	}
+	acks := make(chan int, 1)
+	manager.SetSendAckCallback(func(senderPeerID string, ack int) {
+		acks <- ack
+	})
+
+	sender := newTestVCMPeer(t, manager)
+	receiver := newTestVCMPeer(t, manager)
+	receiver.host.SetStreamHandler("/test/1.0.0", receiver.vcm.HandleIncomingStream)
+
+	if err := sender.vcm.SendToQueue(receiver.peerID.String(), "/test/1.0.0", map[string]string{"message": "hello"}, 7); err != nil {
+		t.Fatalf("SendToQueue failed: %v", err)
+	}
+
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Remote peer did not receive the message")
+	}
+
+	select {
+	case ack := <-acks:
+		if ack != 7 {
+			t.Errorf("Expected ack 7, got %d", ack)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Send ack was not reported after remote delivery")
+	}
+}
+
+// TestVirtualConnectionManager_NoAckWithoutRemote tests that an undeliverable message is never acked
+func TestVirtualConnectionManager_NoAckWithoutRemote(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	manager := &Manager{
+		ctx:         ctx,
+		peers:       make(map[string]*Peer),
+		peerAliases: make(map[string]string),
+		verbosity:   0,
+	}
+
+	acks := make(chan int, 1)
+	manager.SetSendAckCallback(func(senderPeerID string, ack int) {
+		acks <- ack
+	})
+
+	peer := &Peer{
+		ctx:     ctx,
+		manager: manager,
+	}
+	vcm := NewVirtualConnectionManager(ctx, peer)
+
+	if err := vcm.SendToQueue("test-peer-123", "/test/1.0.0", map[string]string{"message": "hello"}, 0); err != nil {
+		t.Fatalf("SendToQueue failed: %v", err)
+	}
+
+	select {
+	case ack := <-acks:
+		t.Fatalf("Ack %d reported for a message that was never delivered", ack)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+// TestMessageQueue_WaitForAck tests ACK correlation by message ID
+func TestMessageQueue_WaitForAck(t *testing.T) {
+	ctx := context.Background()
+
+	manager := &Manager{
+		ctx:         ctx,
+		peers:       make(map[string]*Peer),
+		peerAliases: make(map[string]string),
+		verbosity:   0,
+	}
+
+	peer := &Peer{
+		ctx:     ctx,
+		manager: manager,
+	}
+
+	vcm := NewVirtualConnectionManager(ctx, peer)
+	queue := newMessageQueue(vcm, "test-peer", "/test/1.0.0")
+
+	// A stale ACK for another message must not satisfy the wait
+	queue.handleAck("old-msg")
+	if queue.waitForAck("msg-1", 50*time.Millisecond) {
+		t.Error("waitForAck should not accept an ACK for a different message")
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		queue.handleAck("msg-1")
+	}()
+	if !queue.waitForAck("msg-1", time.Second) {
+		t.Error("waitForAck should return true once the matching ACK arrives")
+	}
+}
+
+// TestMessageQueue_AbandonReportsAcks tests that reaching max attempts reports and drops queued messages
+func TestMessageQueue_AbandonReportsAcks(t *testing.T) {
+	ctx := context.Background()
+
+	manager := &Manager{
+		ctx:         ctx,
+		peers:       make(map[string]*Peer),
+		peerAliases: make(map[string]string),
+		verbosity:   0,
+	}
+
+	var failedAcks []int
+	var failedReason string
+	manager.SetSendFailedCallback(func(senderPeerID, targetPeerID, protocol string, acks []int, reason string) {
+		failedAcks = acks
+		failedReason = reason
+	})
+
+	peer := &Peer{
+		ctx:     ctx,
+		manager: manager,
+	}
+
+	vcm := NewVirtualConnectionManager(ctx, peer)
+	queue := newMessageQueue(vcm, "test-peer", "/test/1.0.0")
+	vcm.queues["test-peer:/test/1.0.0"] = queue
+	queue.messages = []QueuedMessage{
+		{id: "msg-1", attempts: 2, maxAttempts: 3, ack: 4},
+		{id: "msg-2", maxAttempts: 3, ack: -1},
+		{id: "msg-3", maxAttempts: 3, ack: 5},
+	}
+
+	queue.handleSendFailure("msg-1", fmt.Errorf("stream reset"))
+
+	if !queue.unreachable {
+		t.Error("Queue should be marked unreachable after max attempts")
+	}
+	if len(queue.messages) != 0 {
+		t.Errorf("Expected abandoned messages to be dropped, %d remain", len(queue.messages))
+	}
+	if len(failedAcks) != 2 || failedAcks[0] != 4 || failedAcks[1] != 5 {
+		t.Errorf("Expected failed acks [4 5], got %v", failedAcks)
+	}
+	if failedReason != "stream reset" {
+		t.Errorf("Expected reason 'stream reset', got '%s'", failedReason)
+	}
+
+	// Sends to an unreachable queue are rejected instead of silently dropped
+	if err := vcm.SendToQueue("test-peer", "/test/1.0.0", "hello", 6); err == nil {
+		t.Error("SendToQueue should fail while the queue is unreachable")
+	}
+
+	// Resetting makes the queue accept messages again
+	vcm.ResetQueues("test-peer", "")
+	if queue.unreachable {
+		t.Error("ResetQueues should clear the unreachable flag")
+	}
+}
+
+// TestVirtualConnectionManager_PersistAndRestore tests that persisted messages are reloaded and delivered
+func TestVirtualConnectionManager_PersistAndRestore(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	manager := &Manager{
+		ctx:         ctx,
+		peers:       make(map[string]*Peer),
+		peerAliases: make(map[string]string),
+		verbosity:   0,
+	}
+	manager.EnableQueuePersistence(dssync.MutexWrap(datastore.NewMapDatastore()))
+	manager.SetQueueTTL(time.Hour, nil)
+
+	received := make(chan any, 2)
+	manager.onPeerData = func(receiverPeerID, senderPeerID, protocol string, data any, meta MessageMetadata) {
+		received <- data
+	}
+
+	sender := newTestVCMPeer(t, manager)
+	receiver := newTestVCMPeer(t, manager)
+	receiver.host.SetStreamHandler("/test/1.0.0", receiver.vcm.HandleIncomingStream)
+	senderID := sender.peerID.String()
+	receiverID := receiver.peerID.String()
+
+	// Simulate messages left over from a previous run: one fresh, one past its TTL
+	fresh := QueuedMessage{id: "fresh", data: []byte(`"hello"`), timestamp: time.Now()}
+	stale := QueuedMessage{id: "stale", data: []byte(`"old"`), timestamp: time.Now().Add(-2 * time.Hour)}
+	for _, msg := range []QueuedMessage{fresh, stale} {
+		if err := manager.queueStore.put(ctx, senderID, receiverID, "/test/1.0.0", msg); err != nil {
+			t.Fatalf("Failed to store message: %v", err)
+		}
+	}
+
+	if err := sender.vcm.RestoreQueues(); err != nil {
+		t.Fatalf("RestoreQueues failed: %v", err)
+	}
+
+	select {
+	case data := <-received:
+		if data != "hello" {
+			t.Errorf("Expected restored message 'hello', got %v", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Restored message was not delivered")
+	}
+	select {
+	case data := <-received:
+		t.Errorf("Expired message was delivered: %v", data)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// Delivered and expired messages are removed from the store
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stored, err := manager.queueStore.load(ctx, senderID)
+		if err != nil {
+			t.Fatalf("Failed to load stored messages: %v", err)
+		}
+		if len(stored) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected store to be empty, %d messages remain", len(stored))
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// TestVirtualConnectionManager_PersistWhileUnreachable tests store-and-forward to unreachable peers
+func TestVirtualConnectionManager_PersistWhileUnreachable(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	manager := &Manager{
+		ctx:         ctx,
+		peers:       make(map[string]*Peer),
+		peerAliases: make(map[string]string),
+		verbosity:   0,
+	}
+	manager.EnableQueuePersistence(dssync.MutexWrap(datastore.NewMapDatastore()))
+
+	sender := newTestVCMPeer(t, manager)
+	queue := newMessageQueue(sender.vcm, "test-peer", "/test/1.0.0")
+	queue.unreachable = true
+	sender.vcm.queues["test-peer:/test/1.0.0"] = queue
+
+	if err := sender.vcm.SendToQueue("test-peer", "/test/1.0.0", "hello", 3); err != nil {
+		t.Fatalf("Persistent queues should accept messages for unreachable peers: %v", err)
+	}
+
+	stored, err := manager.queueStore.load(ctx, sender.peerID.String())
+	if err != nil {
+		t.Fatalf("Failed to load stored messages: %v", err)
+	}
+	if len(stored) != 1 {
+		t.Fatalf("Expected 1 stored message, got %d", len(stored))
+	}
+	if stored[0].Peer != "test-peer" || stored[0].Protocol != "/test/1.0.0" || string(stored[0].Data) != `"hello"` {
+		t.Errorf("Unexpected stored message: %+v", stored[0])
+	}
+}
+
+// TestVirtualConnectionManager_ExpireMessages tests that messages past the queue TTL are dropped and reported
+func TestVirtualConnectionManager_ExpireMessages(t *testing.T) {
+	ctx := context.Background()
+
+	manager := &Manager{
+		ctx:         ctx,
+		peers:       make(map[string]*Peer),
+		peerAliases: make(map[string]string),
+		verbosity:   0,
+	}
+	manager.SetQueueTTL(time.Hour, map[string]time.Duration{"/forever/1.0.0": 0})
+
+	var failedAcks []int
+	manager.SetSendFailedCallback(func(senderPeerID, targetPeerID, protocol string, acks []int, reason string) {
+		failedAcks = append(failedAcks, acks...)
+	})
+
+	peer := &Peer{
+		ctx:     ctx,
+		manager: manager,
+	}
+	vcm := NewVirtualConnectionManager(ctx, peer)
+
+	old := time.Now().Add(-2 * time.Hour)
+	queue := newMessageQueue(vcm, "test-peer", "/test/1.0.0")
+	queue.messages = []QueuedMessage{
+		{id: "msg-1", timestamp: old, ack: 1},
+		{id: "msg-2", timestamp: time.Now(), ack: 2},
+	}
+	vcm.queues["test-peer:/test/1.0.0"] = queue
+
+	forever := newMessageQueue(vcm, "test-peer", "/forever/1.0.0")
+	forever.messages = []QueuedMessage{{id: "msg-3", timestamp: old, ack: 3}}
+	vcm.queues["test-peer:/forever/1.0.0"] = forever
+
+	vcm.expireMessages()
+
+	if len(queue.messages) != 1 || queue.messages[0].id != "msg-2" {
+		t.Errorf("Expected only msg-2 to remain, got %v", queue.messages)
+	}
+	if len(forever.messages) != 1 {
+		t.Error("Messages on a protocol with TTL 0 should never expire")
+	}
+	if len(failedAcks) != 1 || failedAcks[0] != 1 {
+		t.Errorf("Expected expired ack [1], got %v", failedAcks)
+	}
+
+	// A head being sent is left to processQueue, so it can't be reported failed and then acked
+	inFlight := newMessageQueue(vcm, "other-peer", "/test/1.0.0")
+	inFlight.processing = true
+	inFlight.messages = []QueuedMessage{
+		{id: "msg-4", timestamp: old, ack: 4},
+		{id: "msg-5", timestamp: old, ack: 5},
+	}
+	vcm.queues["other-peer:/test/1.0.0"] = inFlight
+	failedAcks = nil
+
+	vcm.expireMessages()
+
+	if len(inFlight.messages) != 1 || inFlight.messages[0].id != "msg-4" {
+		t.Errorf("Expected the in-flight head to remain, got %v", inFlight.messages)
+	}
+	if len(failedAcks) != 1 || failedAcks[0] != 5 {
+		t.Errorf("Expected expired ack [5], got %v", failedAcks)
+	}
+}
+
+// TestVirtualConnectionManager_Call tests request/response calls between peers
+func TestVirtualConnectionManager_Call(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	manager := &Manager{
+		ctx:         ctx,
+		peers:       make(map[string]*Peer),
+		peerAliases: make(map[string]string),
+		verbosity:   0,
+	}
+	manager.SetPeerCallCallback(func(receiverPeerID, senderPeerID, protocol string, data any, timeout time.Duration) (any, error) {
+		if data == "fail" {
+			return nil, fmt.Errorf("handler refused")
+		}
+		return map[string]any{"echo": data}, nil
+	})
+
+	caller := newTestVCMPeer(t, manager)
+	callee := newTestVCMPeer(t, manager)
+	callee.host.SetStreamHandler("/test/1.0.0", callee.vcm.HandleIncomingStream)
+
+	reply, err := caller.vcm.Call(callee.peerID.String(), "/test/1.0.0", "hello", 5*time.Second)
+	if err != nil {
+		t.Fatalf("Call failed: %v", err)
+	}
+	replyMap, ok := reply.(map[string]any)
+	if !ok || replyMap["echo"] != "hello" {
+		t.Errorf("Expected echo reply, got %v", reply)
+	}
+
+	// Errors from the remote handler are returned to the caller
+	if _, err := caller.vcm.Call(callee.peerID.String(), "/test/1.0.0", "fail", 5*time.Second); err == nil {
+		t.Error("Expected an error from the remote handler")
+	}
+
+	caller.vcm.mu.RLock()
+	pendingCalls := len(caller.vcm.calls)
+	caller.vcm.mu.RUnlock()
+	if pendingCalls != 0 {
+		t.Errorf("Expected no pending calls, got %d", pendingCalls)
+	}
+}
+
+// TestVirtualConnectionManager_CallTimeout tests that a call without a reply times out
+func TestVirtualConnectionManager_CallTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	manager := &Manager{
+		ctx:         ctx,
+		peers:       make(map[string]*Peer),
+		peerAliases: make(map[string]string),
+		verbosity:   0,
+	}
+	release := make(chan struct{})
+	defer close(release)
+	manager.SetPeerCallCallback(func(receiverPeerID, senderPeerID, protocol string, data any, timeout time.Duration) (any, error) {
+		<-release
+		return nil, nil
+	})
+
+	caller := newTestVCMPeer(t, manager)
+	callee := newTestVCMPeer(t, manager)
+	callee.host.SetStreamHandler("/test/1.0.0", callee.vcm.HandleIncomingStream)
+
+	start := time.Now()
+	if _, err := caller.vcm.Call(callee.peerID.String(), "/test/1.0.0", "hello", 200*time.Millisecond); err == nil {
+		t.Fatal("Expected call to time out")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Call took %v, expected to time out after 200ms", elapsed)
+	}
+}
+
+// TestVirtualConnectionManager_BinaryData tests that []byte payloads arrive as raw bytes
+func TestVirtualConnectionManager_BinaryData(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	manager := &Manager{
+		ctx:         ctx,
+		peers:       make(map[string]*Peer),
+		peerAliases: make(map[string]string),
+		verbosity:   0,
+	}
+
+	received := make(chan any, 2)
+	manager.onPeerData = func(receiverPeerID, senderPeerID, protocol string, data any, meta MessageMetadata) {
+		received <- data
+	}
+
+	sender := newTestVCMPeer(t, manager)
+	receiver := newTestVCMPeer(t, manager)
+	receiver.host.SetStreamHandler("/test/1.0.0", receiver.vcm.HandleIncomingStream)
+
+	payload := []byte{0x00, 0xff, 0x7b, 0x22}
+	if err := sender.vcm.SendToQueue(receiver.peerID.String(), "/test/1.0.0", payload, -1); err != nil {
+		t.Fatalf("SendToQueue failed: %v", err)
+	}
+	if err := sender.vcm.SendToQueue(receiver.peerID.String(), "/test/1.0.0", "text", -1); err != nil {
+		t.Fatalf("SendToQueue failed: %v", err)
+	}
+
+	for _, want := range []any{payload, "text"} {
+		select {
+		case data := <-received:
+			if raw, ok := want.([]byte); ok {
+				got, isBytes := data.([]byte)
+				if !isBytes || string(got) != string(raw) {
+					t.Errorf("Expected raw bytes %v, got %#v", raw, data)
+				}
+			} else if data != want {
+				t.Errorf("Expected %v, got %#v", want, data)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Message was not delivered")
+		}
+	}
+}
+
+// TestMessageQueue_OrderedDeduplicatedDelivery tests that sequenced messages reach the app once and in order
+func TestMessageQueue_OrderedDeduplicatedDelivery(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	manager := &Manager{
+		ctx:         ctx,
+		peers:       make(map[string]*Peer),
+		peerAliases: make(map[string]string),
+		verbosity:   0,
+	}
+
+	var delivered []any
+	var seqnos []uint64
+	manager.onPeerData = func(receiverPeerID, senderPeerID, protocol string, data any, meta MessageMetadata) {
+		delivered = append(delivered, data)
+		seqnos = append(seqnos, meta.Seqno)
+	}
+
+	peer := &Peer{
+		ctx:     ctx,
+		manager: manager,
+	}
+	vcm := NewVirtualConnectionManager(ctx, peer)
+	queue := vcm.getOrCreateQueue("remote-peer", "/test/1.0.0")
+
+	data := func(session string, seq, base uint64) StreamMessage {
+		return StreamMessage{
+			Type:    "data",
+			ID:      fmt.Sprintf("%s-%d", session, seq),
+			Data:    []byte(fmt.Sprintf("%d", seq)),
+			Session: session,
+			Seq:     seq,
+			Base:    base,
+		}
+	}
+
+	queue.handleIncomingData(data("a", 1, 1))
+	queue.handleIncomingData(data("a", 3, 1)) // Out of order - held until 2 arrives
+	queue.handleIncomingData(data("a", 1, 1)) // Duplicate after a lost ACK
+	queue.handleIncomingData(data("a", 2, 1))
+	queue.handleIncomingData(data("a", 3, 1)) // Duplicate of a delivered message
+	queue.handleIncomingData(data("a", 6, 6)) // Sender gave up on 4 and 5
+	queue.handleIncomingData(data("b", 1, 1)) // Sender restarted
+
+	want := []any{float64(1), float64(2), float64(3), float64(6), float64(1)}
+	if len(delivered) != len(want) {
+		t.Fatalf("Expected %v delivered, got %v", want, delivered)
+	}
+	for i := range want {
+		if delivered[i] != want[i] {
+			t.Errorf("Delivery %d: expected %v, got %v", i, want[i], delivered[i])
+		}
+	}
+	if fmt.Sprint(seqnos) != "[1 2 3 6 1]" {
+		t.Errorf("Expected metadata seqnos [1 2 3 6 1], got %v", seqnos)
+	}
+}
+
+// TestVirtualConnectionManager_SequenceNumbers tests that queued messages get consecutive sequence numbers
+func TestVirtualConnectionManager_SequenceNumbers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	manager := &Manager{
+		ctx:         ctx,
+		peers:       make(map[string]*Peer),
+		peerAliases: make(map[string]string),
+		verbosity:   0,
+	}
+
+	peer := &Peer{
+		ctx:     ctx,
+		manager: manager,
+	}
+	vcm := NewVirtualConnectionManager(ctx, peer)
+	queue := vcm.getOrCreateQueue("remote-peer", "/test/1.0.0")
+	queue.mu.Lock()
+	queue.unreachable = true // Keep messages queued
+	queue.mu.Unlock()
+	vcm.peer.manager.queueStore = newQueueStore(dssync.MutexWrap(datastore.NewMapDatastore()))
+
+	for i := 0; i < 3; i++ {
+		if err := vcm.SendToQueue("remote-peer", "/test/1.0.0", i, -1); err != nil {
+			t.Fatalf("SendToQueue failed: %v", err)
+		}
+	}
+
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	for i, msg := range queue.messages {
+		if msg.seq != uint64(i+1) {
+			t.Errorf("Message %d: expected seq %d, got %d", i, i+1, msg.seq)
+		}
+	}
+}
+
+// newLimitedQueue returns a VCM and a held queue (processing set so nothing is sent) with the given limits
+func newLimitedQueue(t *testing.T, maxMessages int, maxBytes int64, policy string) (*VirtualConnectionManager, *MessageQueue) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	manager := &Manager{
+		ctx:           ctx,
+		peers:         make(map[string]*Peer),
+		peerAliases:   make(map[string]string),
+		verbosity:     0,
+		streamTimeout: 200 * time.Millisecond,
+	}
+	manager.SetQueueLimits(maxMessages, maxBytes, policy)
+
+	peer := &Peer{
+		ctx:     ctx,
+		manager: manager,
+	}
+	vcm := NewVirtualConnectionManager(ctx, peer)
+	queue := vcm.getOrCreateQueue("remote-peer", "/test/1.0.0")
+	queue.mu.Lock()
+	queue.processing = true
+	queue.mu.Unlock()
+	return vcm, queue
+}
+
+// TestVirtualConnectionManager_QueueLimitReject tests that full queues reject sends with ErrQueueFull
+func TestVirtualConnectionManager_QueueLimitReject(t *testing.T) {
+	vcm, _ := newLimitedQueue(t, 2, 8, QueuePolicyReject)
+
+	for i := 0; i < 2; i++ {
+		if err := vcm.SendToQueue("remote-peer", "/test/1.0.0", i, -1); err != nil {
+			t.Fatalf("Send %d should fit: %v", i, err)
+		}
+	}
+	if err := vcm.SendToQueue("remote-peer", "/test/1.0.0", 2, -1); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Expected ErrQueueFull for message limit, got %v", err)
+	}
+
+	_, queue := newLimitedQueue(t, 0, 8, QueuePolicyReject)
+	if err := queue.manager.SendToQueue("remote-peer", "/test/1.0.0", "too long for the limit", -1); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Expected ErrQueueFull for byte limit, got %v", err)
+	}
+}
+
+// TestVirtualConnectionManager_QueueLimitDropOldest tests that the oldest waiting messages make room and are reported
+func TestVirtualConnectionManager_QueueLimitDropOldest(t *testing.T) {
+	vcm, queue := newLimitedQueue(t, 2, 0, QueuePolicyDropOldest)
+
+	var failedAcks []int
+	vcm.peer.manager.onSendFailed = func(senderPeerID, targetPeerID, protocol string, acks []int, reason string) {
+		failedAcks = append(failedAcks, acks...)
+	}
+
+	for i := 0; i < 4; i++ {
+		if err := vcm.SendToQueue("remote-peer", "/test/1.0.0", i, i); err != nil {
+			t.Fatalf("Send %d failed: %v", i, err)
+		}
+	}
+
+	queue.mu.Lock()
+	acks := make([]int, 0, len(queue.messages))
+	for _, msg := range queue.messages {
+		acks = append(acks, msg.ack)
+	}
+	queue.mu.Unlock()
+
+	// The in-flight head (0) is kept, 1 and 2 are dropped for 2 and 3
+	if fmt.Sprint(acks) != "[0 3]" {
+		t.Errorf("Expected queued acks [0 3], got %v", acks)
+	}
+	if fmt.Sprint(failedAcks) != "[1 2]" {
+		t.Errorf("Expected dropped acks [1 2], got %v", failedAcks)
+	}
+}
+
+// TestVirtualConnectionManager_QueueLimitBlock tests that blocked sends wait for room or time out
+func TestVirtualConnectionManager_QueueLimitBlock(t *testing.T) {
+	vcm, queue := newLimitedQueue(t, 1, 0, QueuePolicyBlock)
+
+	if err := vcm.SendToQueue("remote-peer", "/test/1.0.0", 0, -1); err != nil {
+		t.Fatalf("First send failed: %v", err)
+	}
+
+	// Nothing leaves the queue - the send times out
+	if err := vcm.SendToQueue("remote-peer", "/test/1.0.0", 1, -1); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Expected ErrQueueFull after blocking, got %v", err)
+	}
+
+	// Delivering the head unblocks the sender
+	done := make(chan error, 1)
+	go func() {
+		done <- vcm.SendToQueue("remote-peer", "/test/1.0.0", 2, -1)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	queue.mu.Lock()
+	queue.messages = queue.messages[1:]
+	queue.signalSpaceLocked()
+	queue.mu.Unlock()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Blocked send should succeed once room is freed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Blocked send did not wake up")
+	}
+}
+
+// TestVirtualConnectionManager_BidirectionalSends tests that two peers sending to each other at once,
+// replacing each other's streams, still get every message acked
+func TestVirtualConnectionManager_BidirectionalSends(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	manager := &Manager{
+		ctx:         ctx,
+		peers:       make(map[string]*Peer),
+		peerAliases: make(map[string]string),
+		verbosity:   0,
+	}
+	manager.onPeerData = func(receiverPeerID, senderPeerID, protocol string, data any, meta MessageMetadata) {}
+	acks := make(chan int, 20)
+	manager.SetSendAckCallback(func(senderPeerID string, ack int) {
+		acks <- ack
+	})
+
+	a := newTestVCMPeer(t, manager)
+	b := newTestVCMPeer(t, manager)
+	a.host.SetStreamHandler("/test/1.0.0", a.vcm.HandleIncomingStream)
+	b.host.SetStreamHandler("/test/1.0.0", b.vcm.HandleIncomingStream)
+
+	for i := 0; i < 5; i++ {
+		if err := a.vcm.SendToQueue(b.peerID.String(), "/test/1.0.0", i, i); err != nil {
+			t.Fatalf("SendToQueue failed: %v", err)
+		}
+		if err := b.vcm.SendToQueue(a.peerID.String(), "/test/1.0.0", i, 10+i); err != nil {
+			t.Fatalf("SendToQueue failed: %v", err)
+		}
+	}
+
+	got := make(map[int]bool)
+	timeout := time.After(15 * time.Second)
+	for len(got) < 10 {
+		select {
+		case ack := <-acks:
+			got[ack] = true
+		case <-timeout:
+			t.Fatalf("Expected 10 acks, got %d", len(got))
+		}
+	}
+}
+
+// TestVirtualConnectionManager_LegacyStreams tests that framed peers prefer the framed protocol and still talk to legacy peers
+func TestVirtualConnectionManager_LegacyStreams(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	manager := &Manager{
+		ctx:         ctx,
+		peers:       make(map[string]*Peer),
+		peerAliases: make(map[string]string),
+		verbosity:   0,
+	}
+	received := make(chan MessageMetadata, 2)
+	manager.onPeerData = func(receiverPeerID, senderPeerID, protocol string, data any, meta MessageMetadata) {
+		received <- meta
+	}
+
+	sender := newTestVCMPeer(t, manager)
+	receiver := newTestVCMPeer(t, manager)
+	for _, pid := range manager.streamProtocols("/test/1.0.0") {
+		receiver.host.SetStreamHandler(pid, receiver.vcm.HandleIncomingStream)
+	}
+
+	if err := sender.vcm.SendToQueue(receiver.peerID.String(), "/test/1.0.0", "framed", -1); err != nil {
+		t.Fatalf("SendToQueue failed: %v", err)
+	}
+	select {
+	case meta := <-received:
+		if meta.Seqno == 0 {
+			t.Error("Expected a sequenced message from a framed peer")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Framed message was not delivered")
+	}
+	queue := sender.vcm.getOrCreateQueue(receiver.peerID.String(), "/test/1.0.0")
+	queue.mu.Lock()
+	if queue.stream == nil || !isFramed(queue.stream.Protocol()) {
+		t.Error("Expected the framed protocol to be negotiated")
+	}
+	queue.mu.Unlock()
+
+	// A legacy peer sends one JSON frame per message with base64 data and no sequence number
+	legacy, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatalf("Failed to create libp2p host: %v", err)
+	}
+	defer legacy.Close()
+	if err := legacy.Connect(ctx, peer.AddrInfo{ID: receiver.peerID, Addrs: receiver.host.Addrs()}); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	stream, err := legacy.NewStream(ctx, receiver.peerID, "/test/1.0.0")
+	if err != nil {
+		t.Fatalf("Failed to open legacy stream: %v", err)
+	}
+	defer stream.Close()
+	if err := writeMessage(stream, []byte(`{"type":"data","id":"legacy-1","data":"ImxlZ2FjeSI="}`)); err != nil {
+		t.Fatalf("Failed to write legacy message: %v", err)
+	}
+	select {
+	case meta := <-received:
+		if meta.Seqno != 0 {
+			t.Errorf("Expected an unsequenced legacy message, got seqno %d", meta.Seqno)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Legacy message was not delivered")
+	}
+
+	// The ack comes back as a single legacy frame
+	stream.SetReadDeadline(time.Now().Add(5 * time.Second))
+	frame, err := readMessage(stream)
+	if err != nil {
+		t.Fatalf("Failed to read ack: %v", err)
+	}
+	var ack StreamMessage
+	if err := json.Unmarshal(frame, &ack); err != nil || ack.Type != "ack" || ack.ID != "legacy-1" {
+		t.Errorf("Expected a legacy ack for legacy-1, got %s (%v)", frame, err)
+	}
+}
@@ -0,0 +1,83 @@
+// CRC: crc-WebSocketHandler.md, Spec: main.md
+package protocol
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Binary frames carry raw bytes without base64 inflation
+// Layout: [4-byte big-endian header length][JSON Message header][raw body]
+
+// EncodeBinaryFrame encodes a message and its Body as a binary WebSocket frame
+func EncodeBinaryFrame(msg *Message) ([]byte, error) {
+	headerBytes, err := json.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal frame header: %w", err)
+	}
+
+	frame := make([]byte, 4+len(headerBytes)+len(msg.Body))
+	binary.BigEndian.PutUint32(frame, uint32(len(headerBytes)))
+	copy(frame[4:], headerBytes)
+	copy(frame[4+len(headerBytes):], msg.Body)
+	return frame, nil
+}
+
+// EncodeTextFrame encodes a message as a text WebSocket frame
+// A Body is base64-encoded into Params at BodyField, for clients that don't accept binary frames
+func EncodeTextFrame(msg *Message) ([]byte, error) {
+	if msg.Body == nil {
+		return json.Marshal(msg)
+	}
+
+	encoded, _ := json.Marshal(base64.StdEncoding.EncodeToString(msg.Body))
+	params, err := setJSONField(msg.Params, strings.Split(msg.BodyField, "."), encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inline body at %s: %w", msg.BodyField, err)
+	}
+	text := *msg
+	text.Params = params
+	return json.Marshal(&text)
+}
+
+// setJSONField sets a field of a JSON object at a path, creating missing objects along the way
+// Other fields are kept as raw JSON so numbers don't lose precision
+func setJSONField(object json.RawMessage, path []string, value json.RawMessage) (json.RawMessage, error) {
+	fields := make(map[string]json.RawMessage)
+	if len(object) > 0 && string(object) != "null" {
+		if err := json.Unmarshal(object, &fields); err != nil {
+			return nil, err
+		}
+	}
+	if len(path) > 1 {
+		nested, err := setJSONField(fields[path[0]], path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		value = nested
+	}
+	fields[path[0]] = value
+	return json.Marshal(fields)
+}
+
+// DecodeBinaryFrame decodes a binary WebSocket frame into a message with its Body
+func DecodeBinaryFrame(frame []byte) (*Message, error) {
+	if len(frame) < 4 {
+		return nil, fmt.Errorf("binary frame too short: %d bytes", len(frame))
+	}
+
+	headerLen := binary.BigEndian.Uint32(frame)
+	if uint64(headerLen) > uint64(len(frame)-4) {
+		return nil, fmt.Errorf("binary frame header length %d exceeds frame size %d", headerLen, len(frame))
+	}
+
+	var msg Message
+	if err := json.Unmarshal(frame[4:4+headerLen], &msg); err != nil {
+		return nil, fmt.Errorf("invalid frame header: %w", err)
+	}
+	msg.Body = frame[4+headerLen:]
+	return &msg, nil
+}
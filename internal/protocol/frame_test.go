@@ -0,0 +1,135 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/zot/p2p-webapp/internal/peer"
+)
+
+func TestBinaryFrameRoundTrip(t *testing.T) {
+	body := []byte{0x00, 0xff, 0x10, 0x80}
+	msg := &Message{
+		RequestID: 7,
+		Method:    "send",
+		Params:    json.RawMessage(`{"peer":"p","protocol":"/test/1.0.0","ack":-1,"binary":true}`),
+		Body:      body,
+	}
+
+	frame, err := EncodeBinaryFrame(msg)
+	if err != nil {
+		t.Fatalf("EncodeBinaryFrame failed: %v", err)
+	}
+
+	decoded, err := DecodeBinaryFrame(frame)
+	if err != nil {
+		t.Fatalf("DecodeBinaryFrame failed: %v", err)
+	}
+	if decoded.RequestID != 7 || decoded.Method != "send" {
+		t.Errorf("Header mismatch: %+v", decoded)
+	}
+	if !bytes.Equal(decoded.Body, body) {
+		t.Errorf("Body mismatch: got %v, want %v", decoded.Body, body)
+	}
+
+	var req SendRequest
+	if err := json.Unmarshal(decoded.Params, &req); err != nil {
+		t.Fatalf("Failed to unmarshal params: %v", err)
+	}
+	if !req.Binary || req.Peer != "p" {
+		t.Errorf("Params mismatch: %+v", req)
+	}
+}
+
+func TestDecodeBinaryFrameInvalid(t *testing.T) {
+	if _, err := DecodeBinaryFrame([]byte{0, 0}); err == nil {
+		t.Error("Expected error for short frame")
+	}
+	if _, err := DecodeBinaryFrame([]byte{0, 0, 0, 50, '{', '}'}); err == nil {
+		t.Error("Expected error for header length past end of frame")
+	}
+}
+
+func TestCreatePeerDataMessageBinary(t *testing.T) {
+	h := &Handler{pending: make(map[pendingRequest]chan *Message)}
+	raw := []byte("raw bytes")
+
+	msg := h.CreatePeerDataMessage("peer-1", "/test/1.0.0", raw, peer.MessageMetadata{})
+	if !bytes.Equal(msg.Body, raw) {
+		t.Errorf("Body mismatch: got %v", msg.Body)
+	}
+
+	// Binary frames carry the bytes only in the body
+	if strings.Contains(string(msg.Params), "cmF3IGJ5dGVz") {
+		t.Errorf("Params should not contain the base64 data: %s", msg.Params)
+	}
+
+	// Text frames inline the bytes base64-encoded in the params
+	frame, err := EncodeTextFrame(msg)
+	if err != nil {
+		t.Fatalf("EncodeTextFrame failed: %v", err)
+	}
+	var decoded Message
+	var text PeerDataRequest
+	if err := json.Unmarshal(frame, &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal text frame: %v", err)
+	}
+	if err := json.Unmarshal(decoded.Params, &text); err != nil {
+		t.Fatalf("Failed to unmarshal params: %v", err)
+	}
+	if !text.Binary || text.Data != "cmF3IGJ5dGVz" || text.Peer != "peer-1" {
+		t.Errorf("Expected base64 data with binary flag, got %+v", text)
+	}
+
+	// JSON data is unchanged
+	msg = h.CreatePeerDataMessage("peer-1", "/test/1.0.0", map[string]any{"text": "hi"}, peer.MessageMetadata{})
+	if msg.Body != nil {
+		t.Error("JSON data should not produce a binary body")
+	}
+}
+
+func TestCreateGotFileMessageBinary(t *testing.T) {
+	h := &Handler{pending: make(map[pendingRequest]chan *Message)}
+	raw := []byte{1, 2, 3}
+
+	msg := h.CreateGotFileMessage("cid-1", true, map[string]any{
+		"type":     "file",
+		"mimeType": "application/octet-stream",
+		"content":  raw,
+	})
+	if !bytes.Equal(msg.Body, raw) {
+		t.Errorf("Body mismatch: got %v", msg.Body)
+	}
+
+	var req GotFileRequest
+	if err := json.Unmarshal(msg.Params, &req); err != nil {
+		t.Fatalf("Failed to unmarshal params: %v", err)
+	}
+	content, _ := req.Content.(map[string]any)
+	if !req.Binary || content["type"] != "file" {
+		t.Errorf("Unexpected params: %+v", req)
+	}
+	if _, exists := content["content"]; exists {
+		t.Error("Params should not include the file content")
+	}
+
+	// Text frames put the content back, base64-encoded
+	frame, err := EncodeTextFrame(msg)
+	if err != nil {
+		t.Fatalf("EncodeTextFrame failed: %v", err)
+	}
+	var decoded Message
+	if err := json.Unmarshal(frame, &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal text frame: %v", err)
+	}
+	req = GotFileRequest{}
+	if err := json.Unmarshal(decoded.Params, &req); err != nil {
+		t.Fatalf("Failed to unmarshal params: %v", err)
+	}
+	content, _ = req.Content.(map[string]any)
+	if content["content"] != "AQID" || content["mimeType"] != "application/octet-stream" {
+		t.Errorf("Expected base64 content in the text frame, got %+v", content)
+	}
+}
@@ -4,21 +4,37 @@ package protocol
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/zot/p2p-webapp/internal/commands"
 	"github.com/zot/p2p-webapp/internal/peer"
 )
 
+// defaultCallTimeout is used when a call request doesn't specify a timeout
+const defaultCallTimeout = 30 * time.Second
+
 // Handler routes and processes protocol messages
 // CRC: crc-WebSocketHandler.md
 type Handler struct {
 	peerManager PeerManager
 	nextReqID   int
 	mu          sync.Mutex
-	pending     map[int]chan *Message
-	onSendAck   func(peerID string, ack int) // Callback to send ack messages to WebSocket clients
+	pending     map[pendingRequest]chan *Message // Server requests awaiting a client response
+	onSendAck   func(peerID string, ack int)     // Callback to send ack messages to WebSocket clients
+}
+
+// Client is a client connection that server requests are sent to
+type Client interface {
+	SendMessage(msg *Message) error
+}
+
+// pendingRequest identifies a server request by the connection it was sent to, so only that connection can answer it
+type pendingRequest struct {
+	client    Client
+	requestID int
 }
 
 // PeerManager interface for peer operations (Dependency Inversion)
@@ -29,18 +45,35 @@ type PeerManager interface {
 	// Callback setters
 	SetPeerFilesCallback(cb func(receiverPeerID, targetPeerID, dirCID string, entries map[string]any))
 	SetGotFileCallback(cb func(receiverPeerID string, cid string, success bool, content any))
+	SetSendAckCallback(cb func(senderPeerID string, ack int))
 	// Connection management
 	AddPeers(peerID string, targetPeerIDs []string) error
 	RemovePeers(peerID string, targetPeerIDs []string) error
+	PeerInfo(peerID, targetPeerID string, ping bool) (peer.PeerInfo, error)
+	Connections(peerID string) (peer.Connectivity, error)
+	Bootstrap(peerID, bootstrapAddr string) error
+	// Server-side identities
+	CreateIdentity(name, peerKey, passphrase string) (peerID string, err error)
+	ListIdentities() ([]peer.Identity, error)
+	CreatePeerFromIdentity(name, passphrase, rootDirectory string) (peerID string, err error)
+	// Blocklist
+	BlockPeer(peerID, reason string) error
+	UnblockPeer(peerID string) error
+	ListBlocked() (peer.Blocklist, error)
 }
 
 // NewHandler creates a new protocol handler
 // CRC: crc-WebSocketHandler.md
 func NewHandler(pm PeerManager) *Handler {
-	return &Handler{
+	h := &Handler{
 		peerManager: pm,
-		pending:     make(map[int]chan *Message),
+		pending:     make(map[pendingRequest]chan *Message),
 	}
+
+	// Acks are reported by the peer manager once the remote peer confirms delivery
+	pm.SetSendAckCallback(h.deliverAck)
+
+	return h
 }
 
 // SetAckCallback sets the callback for sending ack messages
@@ -48,30 +81,70 @@ func (h *Handler) SetAckCallback(callback func(peerID string, ack int)) {
 	h.onSendAck = callback
 }
 
+// deliverAck forwards a remote delivery acknowledgement to the client
+func (h *Handler) deliverAck(peerID string, ack int) {
+	if h.onSendAck != nil {
+		h.onSendAck(peerID, ack)
+	}
+}
+
 // HandleClientMessage processes messages from the client
 // CRC: crc-WebSocketHandler.md
+// Responses to server requests go through HandleResponse, which knows their connection; here they return no message
 func (h *Handler) HandleClientMessage(msg *Message, peerID string) (*Message, error) {
+	if msg.IsResponse {
+		return nil, nil
+	}
+
 	switch msg.Method {
 	case "peer":
 		return h.handlePeer(msg)
+	case "createidentity":
+		return h.handleCreateIdentity(msg)
+	case "listidentities":
+		return h.handleListIdentities(msg)
+	case "blockpeer":
+		return h.handleBlockPeer(msg)
+	case "unblockpeer":
+		return h.handleUnblockPeer(msg)
+	case "listblocked":
+		return h.handleListBlocked(msg)
 	case "start":
 		return h.handleStart(msg, peerID)
 	case "stop":
 		return h.handleStop(msg, peerID)
 	case "send":
 		return h.handleSend(msg, peerID)
+	case "call":
+		return h.handleCall(msg, peerID)
+	case "resetqueue":
+		return h.handleResetQueue(msg, peerID)
 	case "subscribe":
 		return h.handleSubscribe(msg, peerID)
 	case "publish":
 		return h.handlePublish(msg, peerID)
 	case "unsubscribe":
 		return h.handleUnsubscribe(msg, peerID)
+	case "settopicvalidator":
+		return h.handleSetTopicValidator(msg, peerID)
+	case "addgroupkey":
+		return h.handleAddGroupKey(msg, peerID)
+	case "settopickey":
+		return h.handleSetTopicKey(msg, peerID)
+	case "removetopickey":
+		return h.handleRemoveTopicKey(msg, peerID)
 	case "listpeers":
 		return h.handleListPeers(msg, peerID)
 	case "addpeers":
 		return h.handleAddPeers(msg, peerID)
 	case "removepeers":
 		return h.handleRemovePeers(msg, peerID)
+	case "peerinfo":
+		return h.handlePeerInfo(msg, peerID)
+	case "connections":
+		return h.handleConnections(msg, peerID)
+	case "bootstrap":
+		return h.handleBootstrap(msg, peerID)
 	case "listfiles":
 		return h.handleListFiles(msg, peerID)
 	case "getfile":
@@ -95,6 +168,18 @@ func (h *Handler) handlePeer(msg *Message) (*Message, error) {
 		}
 	}
 
+	if req.Identity != "" {
+		if req.PeerKey != "" {
+			return h.errorResponse(msg.RequestID, 400, "identity and peerkey can't both be given")
+		}
+		// The key stays on the server: only the peer ID is returned
+		peerID, err := h.peerManager.CreatePeerFromIdentity(req.Identity, req.Passphrase, req.RootDirectory)
+		if err != nil {
+			return h.errorResponse(msg.RequestID, 500, err.Error())
+		}
+		return h.peerResponse(msg.RequestID, peerID, "")
+	}
+
 	peerID, peerKey, err := h.peerManager.CreatePeer(req.PeerKey, req.RootDirectory)
 	if err != nil {
 		return h.errorResponse(msg.RequestID, 500, err.Error())
@@ -103,6 +188,92 @@ func (h *Handler) handlePeer(msg *Message) (*Message, error) {
 	return h.peerResponse(msg.RequestID, peerID, peerKey)
 }
 
+func (h *Handler) handleCreateIdentity(msg *Message) (*Message, error) {
+	var req CreateIdentityRequest
+	if err := json.Unmarshal(msg.Params, &req); err != nil || req.Name == "" {
+		return h.errorResponse(msg.RequestID, 400, "invalid params")
+	}
+
+	peerID, err := h.peerManager.CreateIdentity(req.Name, req.PeerKey, req.Passphrase)
+	if err != nil {
+		return h.errorResponse(msg.RequestID, 500, err.Error())
+	}
+
+	return h.stringResponse(msg.RequestID, peerID)
+}
+
+func (h *Handler) handleListIdentities(msg *Message) (*Message, error) {
+	identities, err := h.peerManager.ListIdentities()
+	if err != nil {
+		return h.errorResponse(msg.RequestID, 500, err.Error())
+	}
+
+	resp := ListIdentitiesResponse{Identities: make([]IdentityInfo, 0, len(identities))}
+	for _, id := range identities {
+		resp.Identities = append(resp.Identities, IdentityInfo{Name: id.Name, PeerID: id.PeerID, Encrypted: id.Encrypted})
+	}
+	result, _ := json.Marshal(resp)
+	return &Message{
+		RequestID:  msg.RequestID,
+		IsResponse: true,
+		Result:     result,
+	}, nil
+}
+
+// CRC: crc-PeerManager.md
+func (h *Handler) handleBlockPeer(msg *Message) (*Message, error) {
+	var req BlockPeerRequest
+	if err := json.Unmarshal(msg.Params, &req); err != nil || req.Peer == "" {
+		return h.errorResponse(msg.RequestID, 400, "invalid params")
+	}
+
+	if err := h.peerManager.BlockPeer(req.Peer, req.Reason); err != nil {
+		return h.errorResponse(msg.RequestID, 500, err.Error())
+	}
+
+	return h.emptyResponse(msg.RequestID)
+}
+
+// CRC: crc-PeerManager.md
+func (h *Handler) handleUnblockPeer(msg *Message) (*Message, error) {
+	var req UnblockPeerRequest
+	if err := json.Unmarshal(msg.Params, &req); err != nil || req.Peer == "" {
+		return h.errorResponse(msg.RequestID, 400, "invalid params")
+	}
+
+	if err := h.peerManager.UnblockPeer(req.Peer); err != nil {
+		return h.errorResponse(msg.RequestID, 500, err.Error())
+	}
+
+	return h.emptyResponse(msg.RequestID)
+}
+
+// CRC: crc-PeerManager.md
+func (h *Handler) handleListBlocked(msg *Message) (*Message, error) {
+	list, err := h.peerManager.ListBlocked()
+	if err != nil {
+		return h.errorResponse(msg.RequestID, 500, err.Error())
+	}
+
+	resp := ListBlockedResponse{Blocked: make([]BlockedPeerInfo, 0, len(list.Blocked)), Allowed: list.Allowed}
+	for _, blocked := range list.Blocked {
+		resp.Blocked = append(resp.Blocked, BlockedPeerInfo{
+			PeerID:    blocked.PeerID,
+			Reason:    blocked.Reason,
+			BlockedAt: blocked.BlockedAt.UnixMilli(),
+		})
+	}
+	if resp.Allowed == nil {
+		resp.Allowed = []string{}
+	}
+	result, _ := json.Marshal(resp)
+	return &Message{
+		RequestID:  msg.RequestID,
+		IsResponse: true,
+		Result:     result,
+	}, nil
+}
+
 func (h *Handler) handleStart(msg *Message, peerID string) (*Message, error) {
 	var req StartRequest
 	if err := json.Unmarshal(msg.Params, &req); err != nil {
@@ -145,18 +316,80 @@ func (h *Handler) handleSend(msg *Message, peerID string) (*Message, error) {
 		return h.errorResponse(msg.RequestID, 400, "invalid params")
 	}
 
+	p, err := h.peerManager.GetPeer(peerID)
+	if err != nil {
+		return h.errorResponse(msg.RequestID, 404, err.Error())
+	}
+
+	// Byte payloads arrive as the binary frame body, or base64 over text frames
+	data := req.Data
+	if msg.Body != nil {
+		data = msg.Body
+	} else if req.Binary {
+		encoded, _ := req.Data.(string)
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return h.errorResponse(msg.RequestID, 400, "invalid base64 data")
+		}
+		data = raw
+	}
+
+	// If ack was requested (>= 0), the ack message is sent once the remote peer acknowledges delivery
+	if err := p.SendToPeer(req.Peer, req.Protocol, data, req.Ack); err != nil {
+		if errors.Is(err, peer.ErrQueueFull) {
+			// 429 lets the client back off and retry instead of treating the peer as gone
+			return h.errorResponse(msg.RequestID, 429, err.Error())
+		}
+		return h.errorResponse(msg.RequestID, 500, err.Error())
+	}
+
+	return h.emptyResponse(msg.RequestID)
+}
+
+func (h *Handler) handleCall(msg *Message, peerID string) (*Message, error) {
+	var req CallRequest
+	if err := json.Unmarshal(msg.Params, &req); err != nil {
+		return h.errorResponse(msg.RequestID, 400, "invalid params")
+	}
+
 	peer, err := h.peerManager.GetPeer(peerID)
 	if err != nil {
 		return h.errorResponse(msg.RequestID, 404, err.Error())
 	}
 
-	if err := peer.SendToPeer(req.Peer, req.Protocol, req.Data); err != nil {
+	timeout := defaultCallTimeout
+	if req.Timeout > 0 {
+		timeout = time.Duration(req.Timeout) * time.Millisecond
+	}
+
+	// Blocks until the remote application replies or the timeout expires
+	reply, err := peer.CallPeer(req.Peer, req.Protocol, req.Data, timeout)
+	if err != nil {
 		return h.errorResponse(msg.RequestID, 500, err.Error())
 	}
 
-	// If ack was requested (>= 0), send ack message back to client
-	if req.Ack >= 0 && h.onSendAck != nil {
-		h.onSendAck(peerID, req.Ack)
+	resp := CallResponse{Data: reply}
+	result, _ := json.Marshal(resp)
+	return &Message{
+		RequestID:  msg.RequestID,
+		IsResponse: true,
+		Result:     result,
+	}, nil
+}
+
+func (h *Handler) handleResetQueue(msg *Message, peerID string) (*Message, error) {
+	var req ResetQueueRequest
+	if err := json.Unmarshal(msg.Params, &req); err != nil {
+		return h.errorResponse(msg.RequestID, 400, "invalid params")
+	}
+
+	peer, err := h.peerManager.GetPeer(peerID)
+	if err != nil {
+		return h.errorResponse(msg.RequestID, 404, err.Error())
+	}
+
+	if err := peer.ResetQueue(req.Peer, req.Protocol); err != nil {
+		return h.errorResponse(msg.RequestID, 400, err.Error())
 	}
 
 	return h.emptyResponse(msg.RequestID)
@@ -168,17 +401,38 @@ func (h *Handler) handleSubscribe(msg *Message, peerID string) (*Message, error)
 		return h.errorResponse(msg.RequestID, 400, "invalid params")
 	}
 
-	peer, err := h.peerManager.GetPeer(peerID)
+	var history peer.TopicHistoryRequest
+	if req.History != nil {
+		if req.History.MaxMessages < 0 || req.History.MaxAge < 0 {
+			return h.errorResponse(msg.RequestID, 400, "invalid history: limits must be >= 0")
+		}
+		history.MaxMessages = req.History.MaxMessages
+		history.MaxAge = time.Duration(req.History.MaxAge) * time.Millisecond
+		history.Subscriber = req.Subscriber
+	}
+
+	p, err := h.peerManager.GetPeer(peerID)
 	if err != nil {
 		return h.errorResponse(msg.RequestID, 404, err.Error())
 	}
 
-	if err := peer.Subscribe(req.Topic); err != nil {
+	// Encrypt the topic before subscribing so no message is delivered undecrypted
+	if req.Key != "" || req.KeyID != "" {
+		keyID, err := resolveGroupKey(p, req.Key, req.KeyID)
+		if err == nil {
+			err = p.SetTopicKey(req.Topic, keyID)
+		}
+		if err != nil {
+			return h.errorResponse(msg.RequestID, 400, err.Error())
+		}
+	}
+
+	if err := p.Subscribe(req.Topic, history); err != nil {
 		return h.errorResponse(msg.RequestID, 500, err.Error())
 	}
 
 	// Automatically start monitoring peer join/leave events
-	if err := peer.Monitor(req.Topic); err != nil {
+	if err := p.Monitor(req.Topic); err != nil {
 		return h.errorResponse(msg.RequestID, 500, err.Error())
 	}
 
@@ -191,18 +445,138 @@ func (h *Handler) handlePublish(msg *Message, peerID string) (*Message, error) {
 		return h.errorResponse(msg.RequestID, 400, "invalid params")
 	}
 
-	peer, err := h.peerManager.GetPeer(peerID)
+	p, err := h.peerManager.GetPeer(peerID)
 	if err != nil {
 		return h.errorResponse(msg.RequestID, 404, err.Error())
 	}
 
-	if err := peer.Publish(req.Topic, req.Data); err != nil {
+	keyID, err := resolveGroupKey(p, req.Key, req.KeyID)
+	if err != nil {
+		return h.errorResponse(msg.RequestID, 400, err.Error())
+	}
+
+	if err := p.PublishWithKey(req.Topic, req.Data, keyID); err != nil {
 		return h.errorResponse(msg.RequestID, 500, err.Error())
 	}
 
 	return h.emptyResponse(msg.RequestID)
 }
 
+func (h *Handler) handleAddGroupKey(msg *Message, peerID string) (*Message, error) {
+	var req AddGroupKeyRequest
+	if msg.Params != nil {
+		if err := json.Unmarshal(msg.Params, &req); err != nil {
+			return h.errorResponse(msg.RequestID, 400, "invalid params")
+		}
+	}
+
+	var key []byte
+	if req.Key != "" {
+		var err error
+		if key, err = base64.StdEncoding.DecodeString(req.Key); err != nil {
+			return h.errorResponse(msg.RequestID, 400, "invalid key: not base64")
+		}
+	}
+
+	p, err := h.peerManager.GetPeer(peerID)
+	if err != nil {
+		return h.errorResponse(msg.RequestID, 404, err.Error())
+	}
+
+	keyID, key, err := p.AddGroupKey(key)
+	if err != nil {
+		return h.errorResponse(msg.RequestID, 400, err.Error())
+	}
+
+	return h.groupKeyResponse(msg.RequestID, GroupKeyResponse{KeyID: keyID, Key: base64.StdEncoding.EncodeToString(key)})
+}
+
+func (h *Handler) handleSetTopicKey(msg *Message, peerID string) (*Message, error) {
+	var req SetTopicKeyRequest
+	if err := json.Unmarshal(msg.Params, &req); err != nil {
+		return h.errorResponse(msg.RequestID, 400, "invalid params")
+	}
+
+	p, err := h.peerManager.GetPeer(peerID)
+	if err != nil {
+		return h.errorResponse(msg.RequestID, 404, err.Error())
+	}
+
+	keyID, err := resolveGroupKey(p, req.Key, req.KeyID)
+	if err == nil && keyID == "" {
+		err = errors.New("key or keyId is required")
+	}
+	if err == nil {
+		err = p.SetTopicKey(req.Topic, keyID)
+	}
+	if err != nil {
+		return h.errorResponse(msg.RequestID, 400, err.Error())
+	}
+
+	return h.groupKeyResponse(msg.RequestID, GroupKeyResponse{KeyID: keyID})
+}
+
+func (h *Handler) handleRemoveTopicKey(msg *Message, peerID string) (*Message, error) {
+	var req RemoveTopicKeyRequest
+	if err := json.Unmarshal(msg.Params, &req); err != nil {
+		return h.errorResponse(msg.RequestID, 400, "invalid params")
+	}
+
+	p, err := h.peerManager.GetPeer(peerID)
+	if err != nil {
+		return h.errorResponse(msg.RequestID, 404, err.Error())
+	}
+
+	if err := p.RemoveTopicKey(req.Topic, req.KeyID); err != nil {
+		return h.errorResponse(msg.RequestID, 400, err.Error())
+	}
+
+	return h.emptyResponse(msg.RequestID)
+}
+
+// resolveGroupKey returns the ID of the group key a request names
+// A base64 key is stored on the peer first; neither key nor keyID returns ""
+func resolveGroupKey(p peer.PeerOperations, key, keyID string) (string, error) {
+	if key == "" {
+		return keyID, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", errors.New("invalid key: not base64")
+	}
+	id, _, err := p.AddGroupKey(raw)
+	if err != nil {
+		return "", err
+	}
+	if keyID != "" && keyID != id {
+		return "", fmt.Errorf("keyId %s does not match key (%s)", keyID, id)
+	}
+	return id, nil
+}
+
+func (h *Handler) handleSetTopicValidator(msg *Message, peerID string) (*Message, error) {
+	var req SetTopicValidatorRequest
+	if err := json.Unmarshal(msg.Params, &req); err != nil {
+		return h.errorResponse(msg.RequestID, 400, "invalid params")
+	}
+
+	p, err := h.peerManager.GetPeer(peerID)
+	if err != nil {
+		return h.errorResponse(msg.RequestID, 404, err.Error())
+	}
+
+	spec := peer.TopicValidatorSpec{
+		MaxSize:  req.MaxSize,
+		Schema:   req.Schema,
+		Required: req.Required,
+	}
+	if err := p.SetTopicValidator(req.Topic, spec); err != nil {
+		return h.errorResponse(msg.RequestID, 400, err.Error())
+	}
+
+	return h.emptyResponse(msg.RequestID)
+}
+
 func (h *Handler) handleUnsubscribe(msg *Message, peerID string) (*Message, error) {
 	var req UnsubscribeRequest
 	if err := json.Unmarshal(msg.Params, &req); err != nil {
@@ -281,6 +655,59 @@ func (h *Handler) handleRemovePeers(msg *Message, peerID string) (*Message, erro
 	return h.emptyResponse(msg.RequestID)
 }
 
+// CRC: crc-PeerManager.md, crc-Peer.md
+func (h *Handler) handlePeerInfo(msg *Message, peerID string) (*Message, error) {
+	var req PeerInfoRequest
+	if err := json.Unmarshal(msg.Params, &req); err != nil || req.Peer == "" {
+		return h.errorResponse(msg.RequestID, 400, "invalid params")
+	}
+
+	info, err := h.peerManager.PeerInfo(peerID, req.Peer, req.Ping)
+	if err != nil {
+		return h.errorResponse(msg.RequestID, 500, err.Error())
+	}
+
+	result, _ := json.Marshal(newPeerInfoResponse(info))
+	return &Message{
+		RequestID:  msg.RequestID,
+		IsResponse: true,
+		Result:     result,
+	}, nil
+}
+
+// CRC: crc-PeerManager.md, crc-Peer.md
+func (h *Handler) handleConnections(msg *Message, peerID string) (*Message, error) {
+	c, err := h.peerManager.Connections(peerID)
+	if err != nil {
+		return h.errorResponse(msg.RequestID, 500, err.Error())
+	}
+
+	resp := ConnectionsResponse{Reachability: c.Reachability, Addrs: c.Addrs, Peers: []PeerInfoResponse{}}
+	for _, info := range c.Peers {
+		resp.Peers = append(resp.Peers, newPeerInfoResponse(info))
+	}
+	result, _ := json.Marshal(resp)
+	return &Message{
+		RequestID:  msg.RequestID,
+		IsResponse: true,
+		Result:     result,
+	}, nil
+}
+
+// CRC: crc-PeerManager.md
+func (h *Handler) handleBootstrap(msg *Message, peerID string) (*Message, error) {
+	var req BootstrapRequest
+	if err := json.Unmarshal(msg.Params, &req); err != nil || req.Addr == "" {
+		return h.errorResponse(msg.RequestID, 400, "invalid params")
+	}
+
+	if err := h.peerManager.Bootstrap(peerID, req.Addr); err != nil {
+		return h.errorResponse(msg.RequestID, 500, err.Error())
+	}
+
+	return h.emptyResponse(msg.RequestID)
+}
+
 func (h *Handler) handleListFiles(msg *Message, peerID string) (*Message, error) {
 	var req ListFilesRequest
 	if err := json.Unmarshal(msg.Params, &req); err != nil {
@@ -333,7 +760,10 @@ func (h *Handler) handleStoreFile(msg *Message, peerID string) (*Message, error)
 	}
 
 	var content []byte
-	if !req.Directory {
+	if !req.Directory && msg.Body != nil {
+		// Binary frame: the body is the raw file content
+		content = msg.Body
+	} else if !req.Directory {
 		// Decode base64 content for files
 		var err error
 		content, err = base64.StdEncoding.DecodeString(req.Content)
@@ -380,6 +810,53 @@ func (h *Handler) handleRemoveFile(msg *Message, peerID string) (*Message, error
 
 // Server message senders (to be called by peer manager)
 
+// SendRequest sends a server request to a client and waits for the client's response
+// The response is correlated by connection and request ID through the pending map
+func (h *Handler) SendRequest(msg *Message, client Client, timeout time.Duration) (*Message, error) {
+	key := pendingRequest{client: client, requestID: msg.RequestID}
+	responseCh := make(chan *Message, 1)
+	h.mu.Lock()
+	h.pending[key] = responseCh
+	h.mu.Unlock()
+
+	defer func() {
+		h.mu.Lock()
+		delete(h.pending, key)
+		h.mu.Unlock()
+	}()
+
+	if err := client.SendMessage(msg); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case response := <-responseCh:
+		return response, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("no response to %s within %v", msg.Method, timeout)
+	}
+}
+
+// HandleResponse delivers a client response to the server request pending on the same connection
+func (h *Handler) HandleResponse(client Client, msg *Message) {
+	h.mu.Lock()
+	responseCh, exists := h.pending[pendingRequest{client: client, requestID: msg.RequestID}]
+	h.mu.Unlock()
+
+	if !exists {
+		// Late response after timeout, or not a response to a request sent to this connection
+		return
+	}
+
+	select {
+	case responseCh <- msg:
+	default:
+	}
+}
+
 func (h *Handler) NextRequestID() int {
 	h.mu.Lock()
 	defer h.mu.Unlock()
@@ -388,8 +865,103 @@ func (h *Handler) NextRequestID() int {
 	return id
 }
 
-func (h *Handler) CreatePeerDataMessage(peer, protocol string, data any) *Message {
+// newMessageMetadata converts peer message metadata for the client
+func newMessageMetadata(meta peer.MessageMetadata) *MessageMetadata {
+	md := &MessageMetadata{
+		ID:           meta.ID,
+		Seqno:        meta.Seqno,
+		ReceivedFrom: meta.ReceivedFrom,
+		ReceivedAt:   meta.ReceivedAt.UnixMilli(),
+		History:      meta.History,
+	}
+	if !meta.SentAt.IsZero() {
+		md.SentAt = meta.SentAt.UnixMilli()
+	}
+	return md
+}
+
+// newPeerInfoResponse converts what a peer knows about a remote peer for the client
+func newPeerInfoResponse(info peer.PeerInfo) PeerInfoResponse {
+	resp := PeerInfoResponse{
+		PeerID:       info.PeerID,
+		Connected:    info.Connected,
+		Connections:  []ConnectionInfo{},
+		Addrs:        info.Addrs,
+		Protocols:    info.Protocols,
+		AgentVersion: info.AgentVersion,
+		Latency:      milliseconds(info.Latency),
+		RTT:          milliseconds(info.RTT),
+		PingError:    info.PingError,
+	}
+	for _, conn := range info.Connections {
+		resp.Connections = append(resp.Connections, ConnectionInfo{
+			Addr:      conn.Addr,
+			Direction: conn.Direction,
+			Relayed:   conn.Relayed,
+			Limited:   conn.Limited,
+			Opened:    conn.Opened.UnixMilli(),
+			Streams:   conn.Streams,
+		})
+	}
+	return resp
+}
+
+// milliseconds converts a duration to fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// CreateResumeResponse creates the peer response for a connection that reattached to an existing peer
+func (h *Handler) CreateResumeResponse(requestID int, peerID, resumeToken string) *Message {
+	result, _ := json.Marshal(PeerResponse{PeerID: peerID, Version: commands.Version, ResumeToken: resumeToken})
+	return &Message{
+		RequestID:  requestID,
+		IsResponse: true,
+		Result:     result,
+	}
+}
+
+// CreateErrorResponse creates an error response for requests handled outside the handler
+func (h *Handler) CreateErrorResponse(requestID, code int, message string) *Message {
+	msg, _ := h.errorResponse(requestID, code, message)
+	return msg
+}
+
+// CreateEmptyResponse creates a success response without a result, for requests handled outside the handler
+func (h *Handler) CreateEmptyResponse(requestID int) *Message {
+	msg, _ := h.emptyResponse(requestID)
+	return msg
+}
+
+// CreatePeerDataMessage creates a peerData message
+// []byte data is marked binary and carried as Body, encoded when the message is written to a connection
+func (h *Handler) CreatePeerDataMessage(senderPeerID, protocol string, data any, meta peer.MessageMetadata) *Message {
 	req := PeerDataRequest{
+		Peer:     senderPeerID,
+		Protocol: protocol,
+		Data:     data,
+		Metadata: newMessageMetadata(meta),
+	}
+	raw, isBytes := data.([]byte)
+	if isBytes {
+		req.Data = nil
+		req.Binary = true
+	}
+	params, _ := json.Marshal(req)
+	msg := &Message{
+		RequestID: h.NextRequestID(),
+		Method:    "peerData",
+		Params:    params,
+	}
+	if isBytes {
+		msg.Body = raw
+		msg.BodyField = "data"
+	}
+	return msg
+}
+
+func (h *Handler) CreatePeerCallMessage(peer, protocol string, data any) *Message {
+	req := PeerCallRequest{
 		Peer:     peer,
 		Protocol: protocol,
 		Data:     data,
@@ -397,16 +969,17 @@ func (h *Handler) CreatePeerDataMessage(peer, protocol string, data any) *Messag
 	params, _ := json.Marshal(req)
 	return &Message{
 		RequestID: h.NextRequestID(),
-		Method:    "peerData",
+		Method:    "peerCall",
 		Params:    params,
 	}
 }
 
-func (h *Handler) CreateTopicDataMessage(topic, peerID string, data any) *Message {
+func (h *Handler) CreateTopicDataMessage(topic, peerID string, data any, meta peer.MessageMetadata) *Message {
 	req := TopicDataRequest{
-		Topic:  topic,
-		PeerID: peerID,
-		Data:   data,
+		Topic:    topic,
+		PeerID:   peerID,
+		Data:     data,
+		Metadata: newMessageMetadata(meta),
 	}
 	params, _ := json.Marshal(req)
 	return &Message{
@@ -430,6 +1003,36 @@ func (h *Handler) CreatePeerChangeMessage(topic, peerID string, joined bool) *Me
 	}
 }
 
+// CreatePeerConnectionMessage tells the client that a peer it added connected or disconnected
+func (h *Handler) CreatePeerConnectionMessage(peerID string, connected, limited bool) *Message {
+	params, _ := json.Marshal(PeerConnectionRequest{Peer: peerID, Connected: connected, Limited: limited})
+	return &Message{
+		RequestID: h.NextRequestID(),
+		Method:    "peerConnection",
+		Params:    params,
+	}
+}
+
+// CreateReachabilityMessage tells the client that AutoNAT changed the peer's reachability
+func (h *Handler) CreateReachabilityMessage(reachability string) *Message {
+	params, _ := json.Marshal(ReachabilityRequest{Reachability: reachability})
+	return &Message{
+		RequestID: h.NextRequestID(),
+		Method:    "reachability",
+		Params:    params,
+	}
+}
+
+// CreateAddressesMessage tells the client that the peer's own addresses changed
+func (h *Handler) CreateAddressesMessage(addrs []string, relayed bool) *Message {
+	params, _ := json.Marshal(AddressesRequest{Addrs: addrs, Relayed: relayed})
+	return &Message{
+		RequestID: h.NextRequestID(),
+		Method:    "addresses",
+		Params:    params,
+	}
+}
+
 func (h *Handler) CreateAckMessage(ack int) *Message {
 	req := AckRequest{
 		Ack: ack,
@@ -442,6 +1045,21 @@ func (h *Handler) CreateAckMessage(ack int) *Message {
 	}
 }
 
+func (h *Handler) CreateSendFailedMessage(peer, protocol string, acks []int, reason string) *Message {
+	req := SendFailedRequest{
+		Peer:     peer,
+		Protocol: protocol,
+		Acks:     acks,
+		Reason:   reason,
+	}
+	params, _ := json.Marshal(req)
+	return &Message{
+		RequestID: h.NextRequestID(),
+		Method:    "sendFailed",
+		Params:    params,
+	}
+}
+
 func (h *Handler) CreatePeerFilesMessage(peerID, cid string, entries map[string]FileEntryInfo) *Message {
 	req := PeerFilesRequest{
 		PeerID:  peerID,
@@ -456,18 +1074,37 @@ func (h *Handler) CreatePeerFilesMessage(peerID, cid string, entries map[string]
 	}
 }
 
+// CreateGotFileMessage creates a gotFile message
+// Raw file bytes in content["content"] are marked binary and carried as Body, encoded when the message is written to a connection
 func (h *Handler) CreateGotFileMessage(cid string, success bool, content any) *Message {
 	req := GotFileRequest{
 		CID:     cid,
 		Success: success,
 		Content: content,
 	}
+	fields, _ := content.(map[string]any)
+	raw, isBytes := fields["content"].([]byte)
+	if isBytes {
+		withoutBytes := make(map[string]any, len(fields))
+		for key, value := range fields {
+			if key != "content" {
+				withoutBytes[key] = value
+			}
+		}
+		req.Content = withoutBytes
+		req.Binary = true
+	}
 	params, _ := json.Marshal(req)
-	return &Message{
+	msg := &Message{
 		RequestID: h.NextRequestID(),
 		Method:    "gotFile",
 		Params:    params,
 	}
+	if isBytes {
+		msg.Body = raw
+		msg.BodyField = "content.content"
+	}
+	return msg
 }
 
 // Response helpers
@@ -490,6 +1127,15 @@ func (h *Handler) stringResponse(requestID int, value string) (*Message, error)
 	}, nil
 }
 
+func (h *Handler) groupKeyResponse(requestID int, resp GroupKeyResponse) (*Message, error) {
+	result, _ := json.Marshal(resp)
+	return &Message{
+		RequestID:  requestID,
+		IsResponse: true,
+		Result:     result,
+	}, nil
+}
+
 func (h *Handler) peerResponse(requestID int, peerID, peerKey string) (*Message, error) {
 	resp := PeerResponse{PeerID: peerID, PeerKey: peerKey, Version: commands.Version}
 	result, _ := json.Marshal(resp)
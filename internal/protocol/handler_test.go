@@ -0,0 +1,109 @@
+package protocol
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zot/p2p-webapp/internal/peer"
+)
+
+// testClient is a client connection that hands sent messages to a function
+type testClient struct {
+	send func(*Message) error
+}
+
+func (c *testClient) SendMessage(msg *Message) error {
+	return c.send(msg)
+}
+
+// TestHandlerSendRequest tests that client responses are correlated with server requests by connection and request ID
+func TestHandlerSendRequest(t *testing.T) {
+	h := &Handler{pending: make(map[pendingRequest]chan *Message)}
+	other := &testClient{send: func(*Message) error { return nil }}
+	var client *testClient
+
+	msg := h.CreatePeerCallMessage("peer-1", "/chat/1.0.0", "ping")
+	if msg.Method != "peerCall" {
+		t.Errorf("Expected method 'peerCall', got '%s'", msg.Method)
+	}
+
+	client = &testClient{send: func(m *Message) error {
+		// Another connection can't answer the request; then the client answers asynchronously
+		h.HandleResponse(other, &Message{RequestID: m.RequestID, IsResponse: true, Result: json.RawMessage(`"spoofed"`)})
+		go func() {
+			h.HandleResponse(client, &Message{
+				RequestID:  m.RequestID,
+				IsResponse: true,
+				Result:     json.RawMessage(`"pong"`),
+			})
+		}()
+		return nil
+	}}
+
+	response, err := h.SendRequest(msg, client, 5*time.Second)
+	if err != nil {
+		t.Fatalf("SendRequest failed: %v", err)
+	}
+	if string(response.Result) != `"pong"` {
+		t.Errorf("Expected result '\"pong\"', got '%s'", string(response.Result))
+	}
+	if len(h.pending) != 0 {
+		t.Errorf("Expected pending map to be empty, got %d entries", len(h.pending))
+	}
+}
+
+// TestHandlerSendRequestTimeout tests that an unanswered server request times out
+func TestHandlerSendRequestTimeout(t *testing.T) {
+	h := &Handler{pending: make(map[pendingRequest]chan *Message)}
+
+	msg := h.CreatePeerCallMessage("peer-1", "/chat/1.0.0", "ping")
+	_, err := h.SendRequest(msg, &testClient{send: func(*Message) error { return nil }}, 50*time.Millisecond)
+	if err == nil {
+		t.Fatal("Expected timeout error")
+	}
+	if len(h.pending) != 0 {
+		t.Errorf("Expected pending map to be empty, got %d entries", len(h.pending))
+	}
+}
+
+// TestCreateTopicDataMessageMetadata tests that delivery metadata reaches the client in Unix milliseconds
+func TestCreateTopicDataMessageMetadata(t *testing.T) {
+	h := &Handler{pending: make(map[pendingRequest]chan *Message)}
+	received := time.UnixMilli(1700000000123)
+
+	msg := h.CreateTopicDataMessage("chat", "author", "hi", peer.MessageMetadata{
+		ID:           "msg-1",
+		Seqno:        42,
+		ReceivedFrom: "relay",
+		ReceivedAt:   received,
+	})
+
+	var req TopicDataRequest
+	if err := json.Unmarshal(msg.Params, &req); err != nil {
+		t.Fatalf("Failed to unmarshal params: %v", err)
+	}
+	want := MessageMetadata{ID: "msg-1", Seqno: 42, ReceivedFrom: "relay", ReceivedAt: 1700000000123}
+	if req.Metadata == nil || *req.Metadata != want {
+		t.Errorf("Expected metadata %+v, got %+v", want, req.Metadata)
+	}
+
+	// Peer data without a sender timestamp omits sentAt
+	msg = h.CreatePeerDataMessage("sender", "/chat/1.0.0", "hi", peer.MessageMetadata{ID: "1-1", Seqno: 1, ReceivedAt: received})
+	var raw struct {
+		Metadata map[string]any `json:"metadata"`
+	}
+	if err := json.Unmarshal(msg.Params, &raw); err != nil {
+		t.Fatalf("Failed to unmarshal params: %v", err)
+	}
+	if _, ok := raw.Metadata["sentAt"]; ok || raw.Metadata["id"] != "1-1" || raw.Metadata["seqno"] != "1" {
+		t.Errorf("Expected metadata without sentAt and with a string seqno, got %s", msg.Params)
+	}
+
+	// pubsub seqnos exceed 2^53, so they're sent as strings to survive JavaScript numbers
+	msg = h.CreateTopicDataMessage("chat", "author", "hi", peer.MessageMetadata{Seqno: 1<<60 + 1, ReceivedAt: received})
+	if !strings.Contains(string(msg.Params), `"seqno":"1152921504606846977"`) {
+		t.Errorf("Expected the exact seqno as a string, got %s", msg.Params)
+	}
+}
@@ -10,6 +10,11 @@ type Message struct {
 	Result     json.RawMessage `json:"result,omitempty"`
 	Error      *ErrorResponse  `json:"error,omitempty"`
 	IsResponse bool            `json:"isresponse"`
+	Peer       string          `json:"peer,omitempty"` // Peer a request addresses or a server message is for, on connections with several peers
+
+	// Byte payloads: binary frames carry Body after the header, text frames inline it in Params (see EncodeTextFrame)
+	Body      []byte `json:"-"` // Raw bytes left out of Params
+	BodyField string `json:"-"` // Dotted path in Params where text frames put Body base64-encoded
 }
 
 // Shared response structs (minimize duplication)
@@ -23,10 +28,12 @@ type StringResponse struct {
 }
 
 // PeerResponse is used for the Peer command, returning {peerid, peerkey, version}
+// PeerKey is empty for peers created from a server-side identity
 type PeerResponse struct {
-	PeerID  string `json:"peerid"`
-	PeerKey string `json:"peerkey"`
-	Version string `json:"version"`
+	PeerID      string `json:"peerid"`
+	PeerKey     string `json:"peerkey"`
+	Version     string `json:"version"`
+	ResumeToken string `json:"resumeToken,omitempty"` // Reattaches a new connection to this peer after a disconnect
 }
 
 // ErrorResponse provides standardized error structure
@@ -38,9 +45,14 @@ type ErrorResponse struct {
 // Client Request Messages
 
 // PeerRequest creates or restores a peer
+// Identity selects a server-side identity instead of sending PeerKey
 type PeerRequest struct {
 	PeerKey       string `json:"peerkey,omitempty"`
+	Identity      string `json:"identity,omitempty"`      // Name of a stored identity
+	Passphrase    string `json:"passphrase,omitempty"`    // Decrypts an encrypted identity
+	Resume        string `json:"resume,omitempty"`        // Resume token of a disconnected peer to reattach to
 	RootDirectory string `json:"rootDirectory,omitempty"` // Optional CID of peer's root directory
+	Binary        bool   `json:"binary,omitempty"`        // Client accepts binary frames for byte payloads
 }
 
 // StartRequest starts listening for a protocol
@@ -58,18 +70,84 @@ type SendRequest struct {
 	Peer     string `json:"peer"`
 	Protocol string `json:"protocol"`
 	Data     any    `json:"data"`
-	Ack      int    `json:"ack"` // If >= 0, server sends ack message when delivered; -1 = no ack
+	Ack      int    `json:"ack"`              // If >= 0, server sends ack message when delivered; -1 = no ack
+	Binary   bool   `json:"binary,omitempty"` // Data is bytes: the binary frame body, or base64 in data
+}
+
+// CallRequest sends a request to a peer on a protocol and waits for its reply
+type CallRequest struct {
+	Peer     string `json:"peer"`
+	Protocol string `json:"protocol"`
+	Data     any    `json:"data"`
+	Timeout  int    `json:"timeout,omitempty"` // Milliseconds to wait for the reply (0 = default)
+}
+
+// CallResponse returns the remote peer's reply to a call
+type CallResponse struct {
+	Data any `json:"data"`
+}
+
+// ResetQueueRequest clears the unreachable state of the queues to a peer
+type ResetQueueRequest struct {
+	Peer     string `json:"peer"`
+	Protocol string `json:"protocol,omitempty"` // Empty = all protocols
+}
+
+// SetTopicValidatorRequest sets the checks gossipsub applies to a topic's messages
+// Omitting every check removes the topic's validator
+type SetTopicValidatorRequest struct {
+	Topic    string   `json:"topic"`
+	MaxSize  int      `json:"maxSize,omitempty"`  // Maximum message size in bytes
+	Schema   string   `json:"schema,omitempty"`   // Name of a JSON Schema in the [p2p.topicSchemas] config
+	Required []string `json:"required,omitempty"` // Fields every message must have (dotted paths allowed)
 }
 
 // SubscribeRequest subscribes to a topic
 type SubscribeRequest struct {
-	Topic string `json:"topic"`
+	Topic      string          `json:"topic"`
+	History    *HistoryRequest `json:"history,omitempty"`    // Replay recent topic messages before live ones
+	Key        string          `json:"key,omitempty"`        // Base64 group key to encrypt the topic with
+	KeyID      string          `json:"keyId,omitempty"`      // ID of a stored group key to encrypt the topic with
+	Subscriber string          `json:"subscriber,omitempty"` // Set by the server: the connection that gets the replayed history
+}
+
+// HistoryRequest selects the recent topic messages a subscribe replays
+type HistoryRequest struct {
+	MaxMessages int   `json:"maxMessages,omitempty"` // Most recent messages (0 = no count limit)
+	MaxAge      int64 `json:"maxAge,omitempty"`      // Milliseconds back from now (0 = no age limit)
 }
 
 // PublishRequest publishes data to a topic
+// Key or KeyID encrypts this message only; otherwise the topic's current key (if any) is used
 type PublishRequest struct {
 	Topic string `json:"topic"`
 	Data  any    `json:"data"`
+	Key   string `json:"key,omitempty"`   // Base64 group key
+	KeyID string `json:"keyId,omitempty"` // ID of a stored group key
+}
+
+// AddGroupKeyRequest stores a group key on the peer (an empty key generates one)
+type AddGroupKeyRequest struct {
+	Key string `json:"key,omitempty"` // Base64 group key
+}
+
+// GroupKeyResponse identifies a stored group key
+type GroupKeyResponse struct {
+	KeyID string `json:"keyId"`
+	Key   string `json:"key,omitempty"` // Base64 group key, returned by addGroupKey
+}
+
+// SetTopicKeyRequest encrypts a topic with a group key, or rotates it to a new one
+type SetTopicKeyRequest struct {
+	Topic string `json:"topic"`
+	Key   string `json:"key,omitempty"`   // Base64 group key
+	KeyID string `json:"keyId,omitempty"` // ID of a stored group key
+}
+
+// RemoveTopicKeyRequest stops accepting a group key on a topic (an empty key ID removes them all)
+type RemoveTopicKeyRequest struct {
+	Topic string `json:"topic"`
+	KeyID string `json:"keyId,omitempty"`
 }
 
 // UnsubscribeRequest unsubscribes from a topic
@@ -101,10 +179,116 @@ type RemovePeersRequest struct {
 	PeerIDs []string `json:"peerIds"`
 }
 
+// PeerInfoRequest asks what the peer's host knows about a remote peer
+// CRC: crc-Peer.md
+type PeerInfoRequest struct {
+	Peer string `json:"peer"`
+	Ping bool   `json:"ping,omitempty"` // Measure the round trip time with the libp2p ping protocol
+}
+
+// PeerInfoResponse describes a remote peer's connections, addresses and protocols
+type PeerInfoResponse struct {
+	PeerID       string           `json:"peerid"`
+	Connected    bool             `json:"connected"`
+	Connections  []ConnectionInfo `json:"connections"`
+	Addrs        []string         `json:"addrs"`
+	Protocols    []string         `json:"protocols"`
+	AgentVersion string           `json:"agentVersion,omitempty"`
+	Latency      float64          `json:"latency,omitempty"`   // Smoothed latency in milliseconds, when known
+	RTT          float64          `json:"rtt,omitempty"`       // Ping round trip time in milliseconds
+	PingError    string           `json:"pingError,omitempty"` // Why the requested ping failed
+}
+
+// ConnectionInfo describes one connection to a remote peer
+type ConnectionInfo struct {
+	Addr      string `json:"addr"`
+	Direction string `json:"direction"` // "inbound" or "outbound"
+	Relayed   bool   `json:"relayed"`
+	Limited   bool   `json:"limited,omitempty"` // Limited in time or bytes by the relay
+	Opened    int64  `json:"opened"`            // Unix milliseconds
+	Streams   int    `json:"streams"`
+}
+
+// ConnectionsResponse returns the peer's reachability and its connected peers
+// CRC: crc-Peer.md
+type ConnectionsResponse struct {
+	Reachability string             `json:"reachability"` // AutoNAT result: "public", "private" or "unknown"
+	Addrs        []string           `json:"addrs"`
+	Peers        []PeerInfoResponse `json:"peers"`
+}
+
+// BootstrapRequest connects the peer to a bootstrap peer
+type BootstrapRequest struct {
+	Addr string `json:"addr"` // Multiaddr ending in /p2p/<id>
+}
+
+// CreateIdentityRequest stores a named identity on the server
+type CreateIdentityRequest struct {
+	Name       string `json:"name"`
+	PeerKey    string `json:"peerkey,omitempty"`    // Existing key to import (empty = generate)
+	Passphrase string `json:"passphrase,omitempty"` // Encrypts the key at rest
+}
+
+// IdentityInfo describes a stored identity
+type IdentityInfo struct {
+	Name      string `json:"name"`
+	PeerID    string `json:"peerid"`
+	Encrypted bool   `json:"encrypted"` // Needs a passphrase to select
+}
+
+// ListIdentitiesResponse returns the stored identities, without their keys
+type ListIdentitiesResponse struct {
+	Identities []IdentityInfo `json:"identities"`
+}
+
+// BlockPeerRequest adds a peer to the server's blocklist
+type BlockPeerRequest struct {
+	Peer   string `json:"peer"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// UnblockPeerRequest removes a peer from the server's blocklist
+type UnblockPeerRequest struct {
+	Peer string `json:"peer"`
+}
+
+// BlockedPeerInfo describes a blocked peer
+type BlockedPeerInfo struct {
+	PeerID    string `json:"peerid"`
+	Reason    string `json:"reason,omitempty"`
+	BlockedAt int64  `json:"blockedAt"` // Unix milliseconds
+}
+
+// ListBlockedResponse returns the blocked peers and the allow list (empty = every other peer may connect)
+type ListBlockedResponse struct {
+	Blocked []BlockedPeerInfo `json:"blocked"`
+	Allowed []string          `json:"allowed"`
+}
+
 // Server Request Messages (sent from server to client)
 
 // PeerDataRequest delivers data from a peer on a protocol
 type PeerDataRequest struct {
+	Peer     string           `json:"peer"`
+	Protocol string           `json:"protocol"`
+	Data     any              `json:"data"`
+	Binary   bool             `json:"binary,omitempty"` // Data is bytes: the binary frame body, or base64 in data
+	Metadata *MessageMetadata `json:"metadata,omitempty"`
+}
+
+// MessageMetadata describes how a peerData or topicData message arrived
+// Times are Unix milliseconds
+type MessageMetadata struct {
+	ID           string `json:"id"`                     // pubsub message ID (base64url), or the peer message ID
+	Seqno        uint64 `json:"seqno,omitempty,string"` // pubsub sequence number, or the per-(peer, protocol) sequence number; a string since pubsub seqnos exceed 2^53
+	ReceivedFrom string `json:"receivedFrom,omitempty"` // topicData only: peer that relayed the message
+	SentAt       int64  `json:"sentAt,omitempty"`       // peerData only: when the sender queued the message
+	ReceivedAt   int64  `json:"receivedAt"`             // When the receiving peer got the message
+	History      bool   `json:"history,omitempty"`      // topicData only: replayed from topic history
+}
+
+// PeerCallRequest asks the client to answer a call from a peer (the client's response is the reply)
+type PeerCallRequest struct {
 	Peer     string `json:"peer"`
 	Protocol string `json:"protocol"`
 	Data     any    `json:"data"`
@@ -112,9 +296,10 @@ type PeerDataRequest struct {
 
 // TopicDataRequest delivers data from a topic
 type TopicDataRequest struct {
-	Topic  string `json:"topic"`
-	PeerID string `json:"peerid"`
-	Data   any    `json:"data"`
+	Topic    string           `json:"topic"`
+	PeerID   string           `json:"peerid"` // Author of the message
+	Data     any              `json:"data"`
+	Metadata *MessageMetadata `json:"metadata,omitempty"`
 }
 
 // PeerChangeRequest notifies client that a peer joined or left a subscribed topic
@@ -124,11 +309,37 @@ type PeerChangeRequest struct {
 	Joined bool   `json:"joined"` // true = joined, false = left
 }
 
+// PeerConnectionRequest notifies client that a peer added with addPeers connected or disconnected
+type PeerConnectionRequest struct {
+	Peer      string `json:"peer"`
+	Connected bool   `json:"connected"`
+	Limited   bool   `json:"limited,omitempty"` // Connected only through a limited relay connection
+}
+
+// ReachabilityRequest notifies client that AutoNAT changed the peer's reachability
+type ReachabilityRequest struct {
+	Reachability string `json:"reachability"` // "public", "private" or "unknown"
+}
+
+// AddressesRequest notifies client that the peer's own addresses changed
+type AddressesRequest struct {
+	Addrs   []string `json:"addrs"`
+	Relayed bool     `json:"relayed"` // Has a relay reservation (a /p2p-circuit address)
+}
+
 // AckRequest notifies client that a message was successfully delivered
 type AckRequest struct {
 	Ack int `json:"ack"`
 }
 
+// SendFailedRequest notifies client that queued messages to a peer were abandoned
+type SendFailedRequest struct {
+	Peer     string `json:"peer"`     // Unreachable target peer
+	Protocol string `json:"protocol"` // Protocol of the abandoned queue
+	Acks     []int  `json:"acks"`     // Ack numbers of the abandoned messages
+	Reason   string `json:"reason"`   // Last delivery error
+}
+
 // PeerFilesRequest notifies client of a peer's file list (server-to-client)
 type PeerFilesRequest struct {
 	PeerID  string                   `json:"peerid"`  // Target peer whose files were listed
@@ -145,9 +356,10 @@ type FileEntryInfo struct {
 
 // GotFileRequest notifies client of file retrieval result (server-to-client)
 type GotFileRequest struct {
-	CID     string `json:"cid"`     // Requested CID
-	Success bool   `json:"success"` // Whether retrieval was successful
-	Content any    `json:"content"` // File content or error info
+	CID     string `json:"cid"`              // Requested CID
+	Success bool   `json:"success"`          // Whether retrieval was successful
+	Content any    `json:"content"`          // File content or error info
+	Binary  bool   `json:"binary,omitempty"` // content.content is bytes: the binary frame body, or base64
 }
 
 // File Operation Messages
@@ -166,8 +378,8 @@ type GetFileRequest struct {
 // StoreFileRequest stores file or directory content
 type StoreFileRequest struct {
 	Path      string `json:"path"`
-	Content   string `json:"content,omitempty"` // base64 encoded file content (null for directories)
-	Directory bool   `json:"directory"`          // true = create directory, false = create file
+	Content   string `json:"content,omitempty"` // base64 encoded file content (binary frames carry it as the body instead)
+	Directory bool   `json:"directory"`         // true = create directory, false = create file
 }
 
 // RemoveFileRequest removes a file or directory
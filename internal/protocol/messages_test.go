@@ -2,6 +2,7 @@ package protocol
 
 import (
 	"encoding/json"
+	"strings"
 	"testing"
 )
 
@@ -207,3 +208,62 @@ func TestPublishRequestWithAnyData(t *testing.T) {
 		})
 	}
 }
+
+func TestSendFailedRequestSerialization(t *testing.T) {
+	req := SendFailedRequest{
+		Peer:     "target-peer",
+		Protocol: "/test/1.0.0",
+		Acks:     []int{1, 2},
+		Reason:   "failed to open stream",
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("Failed to marshal SendFailedRequest: %v", err)
+	}
+
+	var decoded SendFailedRequest
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal SendFailedRequest: %v", err)
+	}
+
+	if decoded.Peer != req.Peer {
+		t.Errorf("Peer mismatch: got %s, want %s", decoded.Peer, req.Peer)
+	}
+
+	if len(decoded.Acks) != 2 || decoded.Acks[0] != 1 || decoded.Acks[1] != 2 {
+		t.Errorf("Acks mismatch: got %v, want %v", decoded.Acks, req.Acks)
+	}
+
+	if decoded.Reason != req.Reason {
+		t.Errorf("Reason mismatch: got %s, want %s", decoded.Reason, req.Reason)
+	}
+}
+
+func TestCallRequestSerialization(t *testing.T) {
+	jsonStr := `{"peer":"target-peer","protocol":"/test/1.0.0","data":{"q":"ping"},"timeout":1500}`
+
+	var req CallRequest
+	if err := json.Unmarshal([]byte(jsonStr), &req); err != nil {
+		t.Fatalf("Failed to unmarshal CallRequest: %v", err)
+	}
+
+	if req.Peer != "target-peer" {
+		t.Errorf("Peer mismatch: got %s, want target-peer", req.Peer)
+	}
+	if req.Timeout != 1500 {
+		t.Errorf("Timeout mismatch: got %d, want 1500", req.Timeout)
+	}
+	if req.Data == nil {
+		t.Error("Data should not be nil")
+	}
+
+	// Timeout is optional
+	data, err := json.Marshal(CallRequest{Peer: "p", Protocol: "/test/1.0.0"})
+	if err != nil {
+		t.Fatalf("Failed to marshal CallRequest: %v", err)
+	}
+	if strings.Contains(string(data), "timeout") {
+		t.Errorf("Zero timeout should be omitted: %s", string(data))
+	}
+}
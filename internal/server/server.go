@@ -5,6 +5,7 @@ import (
 	"archive/zip"
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net"
@@ -36,12 +37,18 @@ type Server struct {
 	port           int
 	fileSystem     http.FileSystem
 	connections    map[*WSConnection]bool
-	peerConnection map[string]*WSConnection // Maps peerID to WSConnection
 	mu             sync.RWMutex
 	linger         bool
 	exitTimer      *time.Timer
 	exitTimerMu    sync.Mutex
 	verbosity      int
+
+	// Connection sessions (see session.go)
+	sessions         map[string][]*session // Each peer's connections, attached or waiting to be resumed, by peer ID
+	resumeGrace      time.Duration         // How long a disconnected session waits to be resumed (0 = disabled)
+	resumeBufferSize int                   // Server messages kept for a disconnected session
+	nextAck          int                   // Last server-wide send ack number
+	nextSession      int                   // Last session number, for session IDs
 }
 
 // zipFileSystem implements http.FileSystem for serving files from a ZIP archive
@@ -136,9 +143,12 @@ func NewServerFromDir(ctx context.Context, pm *peer.Manager, cfg *config.Config,
 		port:           cfg.Server.Port,
 		fileSystem:     http.Dir(htmlDir),
 		connections:    make(map[*WSConnection]bool),
-		peerConnection: make(map[string]*WSConnection),
 		linger:         cfg.Behavior.Linger,
 		verbosity:      cfg.Behavior.Verbosity,
+
+		sessions:         make(map[string][]*session),
+		resumeGrace:      cfg.WebSocket.ResumeGracePeriod.Duration,
+		resumeBufferSize: cfg.WebSocket.ResumeBufferSize,
 	}
 
 	// Create protocol handler
@@ -158,6 +168,15 @@ func NewServerFromDir(ctx context.Context, pm *peer.Manager, cfg *config.Config,
 	pm.SetPeerFilesCallback(s.onPeerFiles)
 	pm.SetGotFileCallback(s.onGotFile)
 
+	// Report sends abandoned to unreachable peers
+	pm.SetSendFailedCallback(s.onSendFailed)
+
+	// Let clients answer calls from remote peers
+	pm.SetPeerCallCallback(s.onPeerCall)
+
+	// Tell clients about peer connections, NAT reachability and address changes
+	pm.SetConnectivityCallback(s.onConnectivity)
+
 	return s
 }
 
@@ -174,9 +193,12 @@ func NewServerFromBundle(ctx context.Context, pm *peer.Manager, cfg *config.Conf
 		port:           cfg.Server.Port,
 		fileSystem:     &zipFileSystem{reader: bundleReader},
 		connections:    make(map[*WSConnection]bool),
-		peerConnection: make(map[string]*WSConnection),
 		linger:         cfg.Behavior.Linger,
 		verbosity:      cfg.Behavior.Verbosity,
+
+		sessions:         make(map[string][]*session),
+		resumeGrace:      cfg.WebSocket.ResumeGracePeriod.Duration,
+		resumeBufferSize: cfg.WebSocket.ResumeBufferSize,
 	}
 
 	// Create protocol handler
@@ -196,6 +218,15 @@ func NewServerFromBundle(ctx context.Context, pm *peer.Manager, cfg *config.Conf
 	pm.SetPeerFilesCallback(s.onPeerFiles)
 	pm.SetGotFileCallback(s.onGotFile)
 
+	// Report sends abandoned to unreachable peers
+	pm.SetSendFailedCallback(s.onSendFailed)
+
+	// Let clients answer calls from remote peers
+	pm.SetPeerCallCallback(s.onPeerCall)
+
+	// Tell clients about peer connections, NAT reachability and address changes
+	pm.SetConnectivityCallback(s.onConnectivity)
+
 	return s
 }
 
@@ -276,9 +307,12 @@ func (s *Server) Stop() error {
 	}
 	s.cancelExitTimer()
 
+	// Remove peers with their connections instead of keeping them for resumption
+	s.endSessions()
+
 	// Close all WebSocket connections
 	// Copy connections to slice and release lock before closing to avoid deadlock
-	// (conn.Close() calls UnregisterPeer which tries to acquire s.mu)
+	// (conn.Close() ends its session, which acquires s.mu)
 	if s.verbosity >= 3 {
 		s.mu.RLock()
 		connCount := len(s.connections)
@@ -291,7 +325,6 @@ func (s *Server) Stop() error {
 		connsToClose = append(connsToClose, conn)
 	}
 	s.connections = make(map[*WSConnection]bool) // Clear the map
-	s.peerConnection = make(map[string]*WSConnection) // Clear peer mappings
 	s.mu.Unlock()
 
 	// Now close connections without holding the lock
@@ -384,28 +417,6 @@ func (s *Server) Done() <-chan struct{} {
 	return s.ctx.Done()
 }
 
-// RegisterPeer registers a peer with its WebSocket connection
-func (s *Server) RegisterPeer(peerID string, conn *WSConnection) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.peerConnection[peerID] = conn
-}
-
-// UnregisterPeer removes a peer's connection mapping
-func (s *Server) UnregisterPeer(peerID string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	delete(s.peerConnection, peerID)
-}
-
-// IsPeerRegistered checks if a peer ID is already registered
-func (s *Server) IsPeerRegistered(peerID string) bool {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	_, exists := s.peerConnection[peerID]
-	return exists
-}
-
 // OpenBrowser opens the default browser to the server URL
 func (s *Server) OpenBrowser() error {
 	url := fmt.Sprintf("http://localhost:%d", s.port)
@@ -555,68 +566,111 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 
 // Callback methods to send server messages to clients
 
-func (s *Server) onPeerData(receiverPeerID, senderPeerID, protocol string, data any) {
-	msg := s.handler.CreatePeerDataMessage(senderPeerID, protocol, data)
-
-	// Send only to the connection that owns the receiving peer
-	s.mu.RLock()
-	conn, exists := s.peerConnection[receiverPeerID]
-	s.mu.RUnlock()
+func (s *Server) onPeerData(receiverPeerID, senderPeerID, protocol string, data any, meta peer.MessageMetadata) {
+	msg := s.handler.CreatePeerDataMessage(senderPeerID, protocol, data, meta)
 
-	if exists {
-		if err := conn.SendMessage(msg); err != nil {
-			fmt.Printf("Failed to send peer message to peer %s: %v\n", receiverPeerID, err)
-		}
+	// Send only to the connections of the receiving peer that started the protocol (buffered while disconnected)
+	if err := s.sendToPeer(receiverPeerID, msg, holdsProtocol(protocol)); err != nil {
+		fmt.Printf("Failed to send peer message to peer %s: %v\n", receiverPeerID, err)
 	}
 }
 
-func (s *Server) onTopicData(receiverPeerID, topic, senderPeerID string, data any) {
-	msg := s.handler.CreateTopicDataMessage(topic, senderPeerID, data)
-
-	// Send only to the connection that owns the receiving peer
-	s.mu.RLock()
-	conn, exists := s.peerConnection[receiverPeerID]
-	s.mu.RUnlock()
+func (s *Server) onTopicData(receiverPeerID, topic, senderPeerID string, data any, meta peer.MessageMetadata) {
+	msg := s.handler.CreateTopicDataMessage(topic, senderPeerID, data, meta)
 
-	if exists {
-		if err := conn.SendMessage(msg); err != nil {
-			fmt.Printf("Failed to send topic message to peer %s: %v\n", receiverPeerID, err)
+	// Replayed history goes only to the connection that subscribed with it
+	if meta.Subscriber != "" {
+		if err := s.sendToSession(receiverPeerID, meta.Subscriber, msg); err != nil {
+			fmt.Printf("Failed to send topic history to peer %s: %v\n", receiverPeerID, err)
 		}
+		return
+	}
+
+	// Send only to the connections of the receiving peer subscribed to the topic (buffered while disconnected)
+	if err := s.sendToPeer(receiverPeerID, msg, holdsTopic(topic)); err != nil {
+		fmt.Printf("Failed to send topic message to peer %s: %v\n", receiverPeerID, err)
 	}
 }
 
 func (s *Server) onPeerChange(receiverPeerID, topic, changedPeerID string, joined bool) {
 	msg := s.handler.CreatePeerChangeMessage(topic, changedPeerID, joined)
 
-	// Send only to the connection that owns the receiving peer
-	s.mu.RLock()
-	conn, exists := s.peerConnection[receiverPeerID]
-	s.mu.RUnlock()
-
-	if exists {
-		if err := conn.SendMessage(msg); err != nil {
-			action := "joined"
-			if !joined {
-				action = "left"
-			}
-			fmt.Printf("Failed to send %s message to peer %s: %v\n", action, receiverPeerID, err)
+	// Send only to the connections of the receiving peer subscribed to the topic (buffered while disconnected)
+	if err := s.sendToPeer(receiverPeerID, msg, holdsTopic(topic)); err != nil {
+		action := "joined"
+		if !joined {
+			action = "left"
 		}
+		fmt.Printf("Failed to send %s message to peer %s: %v\n", action, receiverPeerID, err)
 	}
 }
 
 func (s *Server) onSendAck(peerID string, ack int) {
-	msg := s.handler.CreateAckMessage(ack)
+	// Send only to the connection that sent the message, with its own ack number (buffered while disconnected)
+	s.sendAcks(peerID, []int{ack}, func(acks []int) *protocol.Message {
+		return s.handler.CreateAckMessage(acks[0])
+	})
+}
 
-	// Send only to the connection that owns the sending peer
-	s.mu.RLock()
-	conn, exists := s.peerConnection[peerID]
-	s.mu.RUnlock()
+func (s *Server) onSendFailed(senderPeerID, targetPeerID, proto string, acks []int, reason string) {
+	// Each connection that sent abandoned messages gets their acks in its own numbering (buffered while disconnected)
+	if s.sendAcks(senderPeerID, acks, func(acks []int) *protocol.Message {
+		return s.handler.CreateSendFailedMessage(targetPeerID, proto, acks, reason)
+	}) {
+		return
+	}
 
-	if exists {
-		if err := conn.SendMessage(msg); err != nil {
-			fmt.Printf("Failed to send ack message to peer %s: %v\n", peerID, err)
+	// No connection was waiting for these messages, so tell the ones using the protocol
+	msg := s.handler.CreateSendFailedMessage(targetPeerID, proto, []int{}, reason)
+	if err := s.sendToPeer(senderPeerID, msg, holdsProtocol(proto)); err != nil {
+		fmt.Printf("Failed to send sendFailed message to peer %s: %v\n", senderPeerID, err)
+	}
+}
+
+func (s *Server) onPeerCall(receiverPeerID, senderPeerID, protocol string, data any, timeout time.Duration) (any, error) {
+	msg := s.handler.CreatePeerCallMessage(senderPeerID, protocol, data)
+	msg.Peer = receiverPeerID
+
+	// Ask one connection of the receiving peer, preferring one that started the protocol
+	conn := s.callConnection(receiverPeerID, protocol)
+	if conn == nil {
+		return nil, fmt.Errorf("peer %s has no client connection", receiverPeerID)
+	}
+
+	response, err := s.handler.SendRequest(msg, conn, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if response.Error != nil {
+		return nil, fmt.Errorf("%s", response.Error.Message)
+	}
+
+	var reply any
+	if len(response.Result) > 0 {
+		if err := json.Unmarshal(response.Result, &reply); err != nil {
+			return nil, fmt.Errorf("invalid reply from client: %w", err)
 		}
 	}
+	return reply, nil
+}
+
+func (s *Server) onConnectivity(peerID string, evt peer.ConnectivityEvent) {
+	var msg *protocol.Message
+	switch evt.Kind {
+	case peer.ConnectivityPeer:
+		msg = s.handler.CreatePeerConnectionMessage(evt.Peer, evt.Connected, evt.Limited)
+	case peer.ConnectivityReachability:
+		msg = s.handler.CreateReachabilityMessage(evt.Reachability)
+	case peer.ConnectivityAddresses:
+		msg = s.handler.CreateAddressesMessage(evt.Addrs, evt.Relayed)
+	default:
+		return
+	}
+
+	// Send to every connection of the peer (buffered while disconnected)
+	if err := s.sendToPeer(peerID, msg, nil); err != nil {
+		fmt.Printf("Failed to send %s message to peer %s: %v\n", msg.Method, peerID, err)
+	}
 }
 
 func (s *Server) onPeerFiles(receiverPeerID, targetPeerID, dirCID string, entries map[string]any) {
@@ -640,30 +694,18 @@ func (s *Server) onPeerFiles(receiverPeerID, targetPeerID, dirCID string, entrie
 
 	msg := s.handler.CreatePeerFilesMessage(targetPeerID, dirCID, fileEntries)
 
-	// Send only to the connection that owns the receiving peer
-	s.mu.RLock()
-	conn, exists := s.peerConnection[receiverPeerID]
-	s.mu.RUnlock()
-
-	if exists {
-		if err := conn.SendMessage(msg); err != nil {
-			fmt.Printf("Failed to send peerFiles message to peer %s: %v\n", receiverPeerID, err)
-		}
+	// Send to every connection of the receiving peer (buffered while disconnected)
+	if err := s.sendToPeer(receiverPeerID, msg, nil); err != nil {
+		fmt.Printf("Failed to send peerFiles message to peer %s: %v\n", receiverPeerID, err)
 	}
 }
 
 func (s *Server) onGotFile(receiverPeerID string, cid string, success bool, content any) {
 	msg := s.handler.CreateGotFileMessage(cid, success, content)
 
-	// Send only to the connection that owns the receiving peer
-	s.mu.RLock()
-	conn, exists := s.peerConnection[receiverPeerID]
-	s.mu.RUnlock()
-
-	if exists {
-		if err := conn.SendMessage(msg); err != nil {
-			fmt.Printf("Failed to send gotFile message to peer %s: %v\n", receiverPeerID, err)
-		}
+	// Send to every connection of the receiving peer (buffered while disconnected)
+	if err := s.sendToPeer(receiverPeerID, msg, nil); err != nil {
+		fmt.Printf("Failed to send gotFile message to peer %s: %v\n", receiverPeerID, err)
 	}
 }
 
@@ -13,11 +13,10 @@ func TestAutoExitWithoutLinger(t *testing.T) {
 
 	// Create server with linger=false
 	srv := &Server{
-		ctx:            ctx,
-		cancel:         cancel,
-		connections:    make(map[*WSConnection]bool),
-		peerConnection: make(map[string]*WSConnection),
-		linger:         false,
+		ctx:         ctx,
+		cancel:      cancel,
+		connections: make(map[*WSConnection]bool),
+		linger:      false,
 	}
 
 	// Simulate last connection closing
@@ -39,11 +38,10 @@ func TestAutoExitCancellation(t *testing.T) {
 
 	// Create server with linger=false
 	srv := &Server{
-		ctx:            ctx,
-		cancel:         cancel,
-		connections:    make(map[*WSConnection]bool),
-		peerConnection: make(map[string]*WSConnection),
-		linger:         false,
+		ctx:         ctx,
+		cancel:      cancel,
+		connections: make(map[*WSConnection]bool),
+		linger:      false,
 	}
 
 	// Start exit timer
@@ -71,11 +69,10 @@ func TestLingerMode(t *testing.T) {
 
 	// Create server with linger=true
 	srv := &Server{
-		ctx:            ctx,
-		cancel:         cancel,
-		connections:    make(map[*WSConnection]bool),
-		peerConnection: make(map[string]*WSConnection),
-		linger:         true,
+		ctx:         ctx,
+		cancel:      cancel,
+		connections: make(map[*WSConnection]bool),
+		linger:      true,
 	}
 
 	// Simulate connection closing - should NOT start timer
@@ -99,11 +96,10 @@ func TestExitTimerRestarts(t *testing.T) {
 
 	// Create server with linger=false
 	srv := &Server{
-		ctx:            ctx,
-		cancel:         cancel,
-		connections:    make(map[*WSConnection]bool),
-		peerConnection: make(map[string]*WSConnection),
-		linger:         false,
+		ctx:         ctx,
+		cancel:      cancel,
+		connections: make(map[*WSConnection]bool),
+		linger:      false,
 	}
 
 	// First disconnection - start timer
@@ -140,11 +136,10 @@ func TestCancelExitTimerMultipleTimes(t *testing.T) {
 	defer cancel()
 
 	srv := &Server{
-		ctx:            ctx,
-		cancel:         cancel,
-		connections:    make(map[*WSConnection]bool),
-		peerConnection: make(map[string]*WSConnection),
-		linger:         false,
+		ctx:         ctx,
+		cancel:      cancel,
+		connections: make(map[*WSConnection]bool),
+		linger:      false,
 	}
 
 	// Start timer
@@ -170,11 +165,10 @@ func TestStopCancelsTimer(t *testing.T) {
 	defer cancel()
 
 	srv := &Server{
-		ctx:            ctx,
-		cancel:         cancel,
-		connections:    make(map[*WSConnection]bool),
-		peerConnection: make(map[string]*WSConnection),
-		linger:         false,
+		ctx:         ctx,
+		cancel:      cancel,
+		connections: make(map[*WSConnection]bool),
+		linger:      false,
 	}
 
 	// Start timer
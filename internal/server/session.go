@@ -0,0 +1,533 @@
+// CRC: crc-Server.md, Spec: main.md
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/zot/p2p-webapp/internal/protocol"
+)
+
+// session is one WebSocket connection's attachment to a peer
+// Several connections (e.g. browser tabs) can share a peer, each with its own session. With resumption
+// enabled a session outlives its connection for the grace period, so a reloaded page or a reconnecting
+// client can reattach to it with the resume token from the peer response
+type session struct {
+	peerID    string
+	id        string              // Server-wide session ID, routing messages meant for this connection only
+	token     string              // Resume token ("" when resumption is disabled)
+	conn      *WSConnection       // nil while detached
+	buffer    []*protocol.Message // Server messages waiting for the client, while detached or replaying
+	replaying bool                // Buffered messages are being sent to a resumed connection
+	dropped   int                 // Messages dropped because the buffer was full
+	expiry    *time.Timer         // Ends the session when the grace period ends
+	topics    map[string]bool     // Topics this connection subscribed to
+	protocols map[string]bool     // Protocols this connection started
+	acks      map[int]int         // Server-wide send acks -> this connection's own ack numbers
+}
+
+// attachPeer adds a session for a connection that created or joined a peer and returns its resume token
+// Returns "" when resumption is disabled
+func (s *Server) attachPeer(peerID string, conn *WSConnection) string {
+	sess := &session{
+		peerID:    peerID,
+		conn:      conn,
+		topics:    make(map[string]bool),
+		protocols: make(map[string]bool),
+		acks:      make(map[int]int),
+	}
+	if s.resumeGrace > 0 {
+		token := make([]byte, 32)
+		if _, err := rand.Read(token); err != nil {
+			fmt.Printf("Failed to generate resume token for peer %s: %v\n", peerID, err)
+		} else {
+			sess.token = hex.EncodeToString(token)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sessions == nil {
+		s.sessions = make(map[string][]*session)
+	}
+	s.nextSession++
+	sess.id = strconv.Itoa(s.nextSession)
+	s.sessions[peerID] = append(s.sessions[peerID], sess)
+	return sess.token
+}
+
+// findSession returns the session of a connection, or nil
+// Must be called with s.mu locked
+func (s *Server) findSession(peerID string, conn *WSConnection) *session {
+	for _, sess := range s.sessions[peerID] {
+		if sess.conn == conn {
+			return sess
+		}
+	}
+	return nil
+}
+
+// detachPeer handles a closed connection: its session waits for the grace period to be resumed,
+// or ends now when resumption is disabled
+func (s *Server) detachPeer(peerID string, conn *WSConnection) {
+	s.mu.Lock()
+	sess := s.findSession(peerID, conn)
+	if sess == nil {
+		// Another connection already resumed the session
+		s.mu.Unlock()
+		return
+	}
+	if sess.token == "" {
+		s.mu.Unlock()
+		s.endSession(sess)
+		return
+	}
+	sess.conn = nil
+	sess.replaying = false
+	sess.expiry = time.AfterFunc(s.resumeGrace, func() { s.expireSession(sess) })
+	s.mu.Unlock()
+}
+
+// expireSession ends a session nobody resumed within the grace period
+func (s *Server) expireSession(sess *session) {
+	s.mu.RLock()
+	detached := sess.conn == nil
+	s.mu.RUnlock()
+	if !detached {
+		return
+	}
+	if s.verbosity >= 1 {
+		fmt.Printf("Resume grace period ended for a connection of peer %s\n", sess.peerID)
+	}
+	s.endSession(sess)
+}
+
+// endSession removes a session, removing its peer with the last one
+// Otherwise the topics and protocols only this session used are released
+func (s *Server) endSession(sess *session) {
+	s.mu.Lock()
+	remaining := make([]*session, 0, len(s.sessions[sess.peerID]))
+	found := false
+	for _, other := range s.sessions[sess.peerID] {
+		if other == sess {
+			found = true
+		} else {
+			remaining = append(remaining, other)
+		}
+	}
+	if !found {
+		s.mu.Unlock()
+		return
+	}
+	if sess.expiry != nil {
+		sess.expiry.Stop()
+	}
+	if len(remaining) == 0 {
+		delete(s.sessions, sess.peerID)
+		s.mu.Unlock()
+		if err := s.peerManager.RemovePeer(sess.peerID); err != nil {
+			fmt.Printf("Failed to remove peer %s: %v\n", sess.peerID, err)
+		}
+		return
+	}
+	s.sessions[sess.peerID] = remaining
+	var topics, protocols []string
+	for topic := range sess.topics {
+		if !s.heldByOthers(sess, holdsTopic(topic)) {
+			topics = append(topics, topic)
+		}
+	}
+	for proto := range sess.protocols {
+		if !s.heldByOthers(sess, holdsProtocol(proto)) {
+			protocols = append(protocols, proto)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, topic := range topics {
+		if err := s.peerManager.Unsubscribe(sess.peerID, topic); err != nil {
+			fmt.Printf("Failed to unsubscribe peer %s from %s: %v\n", sess.peerID, topic, err)
+		}
+	}
+	for _, proto := range protocols {
+		if err := s.peerManager.Stop(sess.peerID, proto); err != nil {
+			fmt.Printf("Failed to stop protocol %s for peer %s: %v\n", proto, sess.peerID, err)
+		}
+	}
+}
+
+// heldByOthers returns true if another session of the same peer satisfies holds
+// Must be called with s.mu locked
+func (s *Server) heldByOthers(sess *session, holds func(*session) bool) bool {
+	for _, other := range s.sessions[sess.peerID] {
+		if other != sess && holds(other) {
+			return true
+		}
+	}
+	return false
+}
+
+// resumeSession reattaches conn to the session of a resume token and returns the session's peer ID
+// A connection still attached to the session is closed; buffered messages are replayed by replaySession
+func (s *Server) resumeSession(token string, conn *WSConnection) (string, error) {
+	s.mu.Lock()
+	var sess *session
+	for _, sessions := range s.sessions {
+		for _, candidate := range sessions {
+			if candidate.token != "" && subtle.ConstantTimeCompare([]byte(candidate.token), []byte(token)) == 1 {
+				sess = candidate
+			}
+		}
+	}
+	if sess == nil {
+		s.mu.Unlock()
+		return "", fmt.Errorf("unknown or expired resume token")
+	}
+	if sess.conn != conn && s.findSession(sess.peerID, conn) != nil {
+		s.mu.Unlock()
+		return "", fmt.Errorf("peer already on this connection")
+	}
+
+	if sess.expiry != nil {
+		sess.expiry.Stop()
+		sess.expiry = nil
+	}
+	old := sess.conn
+	sess.conn = conn
+	sess.replaying = true
+	s.mu.Unlock()
+
+	// The old connection is usually gone already; if not (e.g. a half-open socket), drop it
+	if old != nil {
+		old.Close()
+	}
+	return sess.peerID, nil
+}
+
+// replaySession sends a resumed session's buffered messages in order
+// Messages arriving meanwhile are buffered behind them, so the client sees the original order
+func (s *Server) replaySession(peerID string, conn *WSConnection) {
+	for {
+		s.mu.Lock()
+		sess := s.findSession(peerID, conn)
+		if sess == nil {
+			s.mu.Unlock()
+			return
+		}
+		if len(sess.buffer) == 0 {
+			sess.replaying = false
+			if sess.dropped > 0 {
+				fmt.Printf("Dropped %d buffered messages for peer %s while it was disconnected\n", sess.dropped, peerID)
+				sess.dropped = 0
+			}
+			s.mu.Unlock()
+			return
+		}
+		msg := sess.buffer[0]
+		sess.buffer = sess.buffer[1:]
+		s.mu.Unlock()
+
+		if !conn.queueMessage(msg) {
+			// Connection closed again: keep the message for the next resume
+			s.mu.Lock()
+			sess.buffer = append([]*protocol.Message{msg}, sess.buffer...)
+			s.mu.Unlock()
+			return
+		}
+	}
+}
+
+// sendToPeer sends a server message to a peer's sessions, or only the ones for which holds is true
+// If no session holds the message's topic or protocol, every session gets it
+// Messages for detached or replaying sessions are buffered
+func (s *Server) sendToPeer(peerID string, msg *protocol.Message, holds func(*session) bool) error {
+	msg.Peer = peerID // Connections with several peers route server messages by it
+	s.mu.Lock()
+	targets := s.sessions[peerID]
+	if holds != nil {
+		var holders []*session
+		for _, sess := range targets {
+			if holds(sess) {
+				holders = append(holders, sess)
+			}
+		}
+		if len(holders) > 0 {
+			targets = holders
+		}
+	}
+	var conns []*WSConnection
+	for _, sess := range targets {
+		if sess.conn != nil && !sess.replaying {
+			conns = append(conns, sess.conn)
+		} else {
+			s.bufferMessage(sess, msg)
+		}
+	}
+	s.mu.Unlock()
+
+	var errs []error
+	for _, conn := range conns {
+		if err := conn.SendMessage(msg); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sendToSession sends a server message to one of a peer's sessions, by session ID
+// The message is dropped if the session has ended, and buffered while it is detached or replaying
+func (s *Server) sendToSession(peerID, sessionID string, msg *protocol.Message) error {
+	msg.Peer = peerID
+	s.mu.Lock()
+	var conn *WSConnection
+	for _, sess := range s.sessions[peerID] {
+		if sess.id != sessionID {
+			continue
+		}
+		if sess.conn != nil && !sess.replaying {
+			conn = sess.conn
+		} else {
+			s.bufferMessage(sess, msg)
+		}
+	}
+	s.mu.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+	return conn.SendMessage(msg)
+}
+
+// bufferMessage keeps a message for a detached or replaying session, dropping the oldest when full
+// Must be called with s.mu locked
+func (s *Server) bufferMessage(sess *session, msg *protocol.Message) {
+	if len(sess.buffer) >= s.resumeBufferSize {
+		// Full (or buffering disabled): drop the oldest message
+		if len(sess.buffer) > 0 {
+			sess.buffer = sess.buffer[1:]
+		}
+		sess.dropped++
+	}
+	if s.resumeBufferSize > 0 {
+		sess.buffer = append(sess.buffer, msg)
+	}
+}
+
+// holdsTopic selects the sessions subscribed to a topic
+func holdsTopic(topic string) func(*session) bool {
+	return func(sess *session) bool { return sess.topics[topic] }
+}
+
+// holdsProtocol selects the sessions listening on a protocol
+func holdsProtocol(proto string) func(*session) bool {
+	return func(sess *session) bool { return sess.protocols[proto] }
+}
+
+// callConnection picks the connection that answers a call: the first attached session listening on the protocol,
+// or any attached session
+func (s *Server) callConnection(peerID, proto string) *WSConnection {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var fallback *WSConnection
+	for _, sess := range s.sessions[peerID] {
+		if sess.conn == nil {
+			continue
+		}
+		if sess.protocols[proto] {
+			return sess.conn
+		}
+		if fallback == nil {
+			fallback = sess.conn
+		}
+	}
+	return fallback
+}
+
+// sendAcks delivers send acks to the sessions that requested them, translated back to each connection's numbering
+// Acks no session is waiting for (e.g. from queues restored after a restart) are dropped
+// Returns false if no session got any acks
+func (s *Server) sendAcks(peerID string, acks []int, msgFor func(acks []int) *protocol.Message) bool {
+	s.mu.Lock()
+	type delivery struct {
+		sess *session
+		acks []int
+	}
+	var deliveries []*delivery
+	for _, ack := range acks {
+		for _, sess := range s.sessions[peerID] {
+			clientAck, exists := sess.acks[ack]
+			if !exists {
+				continue
+			}
+			delete(sess.acks, ack)
+			var d *delivery
+			for _, existing := range deliveries {
+				if existing.sess == sess {
+					d = existing
+				}
+			}
+			if d == nil {
+				d = &delivery{sess: sess}
+				deliveries = append(deliveries, d)
+			}
+			d.acks = append(d.acks, clientAck)
+			break
+		}
+	}
+	var conns []*WSConnection
+	var msgs []*protocol.Message
+	for _, d := range deliveries {
+		msg := msgFor(d.acks)
+		msg.Peer = peerID
+		if d.sess.conn != nil && !d.sess.replaying {
+			conns = append(conns, d.sess.conn)
+			msgs = append(msgs, msg)
+		} else {
+			s.bufferMessage(d.sess, msg)
+		}
+	}
+	s.mu.Unlock()
+
+	for i, conn := range conns {
+		if err := conn.SendMessage(msgs[i]); err != nil {
+			fmt.Printf("Failed to send %s message to peer %s: %v\n", msgs[i].Method, peerID, err)
+		}
+	}
+	return len(deliveries) > 0
+}
+
+// interceptRequest applies a client request to its connection's session before the shared peer handles it
+// Returns a response when the peer needn't handle the request: another connection still uses the topic or
+// protocol being released, or already started the protocol. Send acks are renumbered server-wide, since
+// every connection numbers its own acks, and subscribes name the session replayed history goes to
+func (s *Server) interceptRequest(conn *WSConnection, peerID string, msg *protocol.Message) *protocol.Message {
+	switch msg.Method {
+	case "subscribe":
+		var params map[string]json.RawMessage
+		if json.Unmarshal(msg.Params, &params) != nil {
+			return nil
+		}
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		delete(params, "subscriber")
+		if sess := s.findSession(peerID, conn); sess != nil {
+			params["subscriber"], _ = json.Marshal(sess.id)
+		}
+		msg.Params, _ = json.Marshal(params)
+	case "unsubscribe":
+		var req protocol.UnsubscribeRequest
+		if err := json.Unmarshal(msg.Params, &req); err != nil {
+			return nil
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if sess := s.findSession(peerID, conn); sess != nil {
+			delete(sess.topics, req.Topic)
+			if s.heldByOthers(sess, holdsTopic(req.Topic)) {
+				return s.handler.CreateEmptyResponse(msg.RequestID)
+			}
+		}
+	case "start", "stop":
+		var req protocol.StartRequest
+		if err := json.Unmarshal(msg.Params, &req); err != nil {
+			return nil
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		sess := s.findSession(peerID, conn)
+		if sess == nil || !s.heldByOthers(sess, holdsProtocol(req.Protocol)) {
+			if sess != nil && msg.Method == "stop" {
+				delete(sess.protocols, req.Protocol)
+			}
+			return nil
+		}
+		if msg.Method == "start" {
+			sess.protocols[req.Protocol] = true
+		} else {
+			delete(sess.protocols, req.Protocol)
+		}
+		return s.handler.CreateEmptyResponse(msg.RequestID)
+	case "send":
+		var req protocol.SendRequest
+		var params map[string]json.RawMessage
+		if json.Unmarshal(msg.Params, &req) != nil || json.Unmarshal(msg.Params, &params) != nil || req.Ack < 0 {
+			return nil
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if sess := s.findSession(peerID, conn); sess != nil {
+			s.nextAck++
+			sess.acks[s.nextAck] = req.Ack
+			params["ack"], _ = json.Marshal(s.nextAck)
+			msg.Params, _ = json.Marshal(params)
+		}
+	}
+	return nil
+}
+
+// completeRequest records what a client request the shared peer handled changed in the connection's session
+func (s *Server) completeRequest(conn *WSConnection, peerID string, msg *protocol.Message, response *protocol.Message) {
+	switch msg.Method {
+	case "subscribe", "start":
+		if response.Error != nil {
+			return
+		}
+		var req struct {
+			Topic    string `json:"topic"`
+			Protocol string `json:"protocol"`
+		}
+		if err := json.Unmarshal(msg.Params, &req); err != nil {
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if sess := s.findSession(peerID, conn); sess != nil {
+			if msg.Method == "subscribe" {
+				sess.topics[req.Topic] = true
+			} else {
+				sess.protocols[req.Protocol] = true
+			}
+		}
+	case "send":
+		if response.Error == nil {
+			return
+		}
+		// The message wasn't sent, so no ack will come
+		var req protocol.SendRequest
+		if err := json.Unmarshal(msg.Params, &req); err != nil || req.Ack < 0 {
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if sess := s.findSession(peerID, conn); sess != nil {
+			delete(sess.acks, req.Ack)
+		}
+	}
+}
+
+// IsPeerRegistered checks if a peer has a connection or a session waiting to be resumed
+func (s *Server) IsPeerRegistered(peerID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.sessions[peerID]) > 0
+}
+
+// endSessions stops resumption so peers are removed with their connections (server shutdown)
+func (s *Server) endSessions() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sessions := range s.sessions {
+		for _, sess := range sessions {
+			if sess.expiry != nil {
+				sess.expiry.Stop()
+			}
+		}
+	}
+	s.sessions = make(map[string][]*session)
+}
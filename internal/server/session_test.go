@@ -0,0 +1,267 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/zot/p2p-webapp/internal/peer"
+	"github.com/zot/p2p-webapp/internal/protocol"
+)
+
+// newSessionTestServer creates a server with connection sessions and no HTTP listener
+func newSessionTestServer(grace time.Duration, bufferSize int) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	pm := &peer.Manager{}
+	return &Server{
+		ctx:              ctx,
+		cancel:           cancel,
+		peerManager:      pm,
+		handler:          protocol.NewHandler(pm),
+		connections:      make(map[*WSConnection]bool),
+		sessions:         make(map[string][]*session),
+		resumeGrace:      grace,
+		resumeBufferSize: bufferSize,
+	}
+}
+
+// newSessionTestConn creates a connection to a peer without a socket, for inspecting queued messages
+func newSessionTestConn(peerID string) *WSConnection {
+	conn := &WSConnection{sendCh: make(chan *protocol.Message, 100), closeCh: make(chan struct{})}
+	if peerID != "" {
+		conn.addPeer(peerID)
+	}
+	return conn
+}
+
+// newSessionTestRequest creates a client request
+func newSessionTestRequest(requestID int, method string, params any) *protocol.Message {
+	data, _ := json.Marshal(params)
+	return &protocol.Message{RequestID: requestID, Method: method, Params: data}
+}
+
+// queuedMessages drains the messages queued for a connection
+func queuedMessages(conn *WSConnection) []*protocol.Message {
+	var msgs []*protocol.Message
+	for {
+		select {
+		case msg := <-conn.sendCh:
+			msgs = append(msgs, msg)
+		default:
+			return msgs
+		}
+	}
+}
+
+// TestSessionResume tests that a detached session buffers messages and replays them in order on resume
+func TestSessionResume(t *testing.T) {
+	srv := newSessionTestServer(time.Minute, 3)
+	defer srv.cancel()
+	first := newSessionTestConn("peer-a")
+	token := srv.attachPeer("peer-a", first)
+	if token == "" {
+		t.Fatal("Expected a resume token")
+	}
+
+	srv.detachPeer("peer-a", first)
+	if !srv.IsPeerRegistered("peer-a") {
+		t.Fatal("Expected the peer to stay alive for resumption")
+	}
+	for id := 1; id <= 4; id++ {
+		srv.sendToPeer("peer-a", &protocol.Message{RequestID: id}, nil)
+	}
+
+	if _, err := srv.resumeSession("not-the-token", newSessionTestConn("")); err == nil {
+		t.Error("Expected an unknown token to be rejected")
+	}
+	second := newSessionTestConn("peer-a")
+	peerID, err := srv.resumeSession(token, second)
+	if err != nil || peerID != "peer-a" {
+		t.Fatalf("Expected to resume peer-a, got %q (%v)", peerID, err)
+	}
+	srv.replaySession("peer-a", second)
+	srv.sendToPeer("peer-a", &protocol.Message{RequestID: 5}, nil) // Sent directly after replay
+
+	// The buffer keeps the newest 3 messages while detached
+	msgs := queuedMessages(second)
+	if len(msgs) != 4 {
+		t.Fatalf("Expected 4 queued messages, got %d", len(msgs))
+	}
+	for i, want := range []int{2, 3, 4, 5} {
+		if msgs[i].RequestID != want {
+			t.Errorf("Expected message %d, got %d", want, msgs[i].RequestID)
+		}
+	}
+
+	// The first connection closing late doesn't detach the resumed session
+	srv.detachPeer("peer-a", first)
+	srv.mu.RLock()
+	resumed := srv.findSession("peer-a", second)
+	srv.mu.RUnlock()
+	if resumed == nil || resumed.expiry != nil {
+		t.Error("Expected the session to stay attached to the resumed connection")
+	}
+}
+
+// TestSessionExpiry tests that a session nobody resumes ends after the grace period
+func TestSessionExpiry(t *testing.T) {
+	srv := newSessionTestServer(50*time.Millisecond, 10)
+	defer srv.cancel()
+	conn := newSessionTestConn("peer-b")
+	token := srv.attachPeer("peer-b", conn)
+	srv.detachPeer("peer-b", conn)
+
+	time.Sleep(200 * time.Millisecond)
+	if _, err := srv.resumeSession(token, newSessionTestConn("")); err == nil {
+		t.Error("Expected the token to expire with the grace period")
+	}
+	if srv.IsPeerRegistered("peer-b") {
+		t.Error("Expected the peer to be removed with its last session")
+	}
+
+	disabled := newSessionTestServer(0, 10)
+	defer disabled.cancel()
+	if disabled.attachPeer("peer-c", conn) != "" {
+		t.Error("Expected no resume token with a zero grace period")
+	}
+	disabled.detachPeer("peer-c", conn)
+	if disabled.IsPeerRegistered("peer-c") {
+		t.Error("Expected the peer to be removed when resumption is disabled")
+	}
+}
+
+// TestSharedPeerSessions tests that connections sharing a peer get their own topics, protocols and acks
+func TestSharedPeerSessions(t *testing.T) {
+	srv := newSessionTestServer(0, 10)
+	defer srv.cancel()
+	tab1 := newSessionTestConn("peer-a")
+	tab2 := newSessionTestConn("peer-a")
+	srv.attachPeer("peer-a", tab1)
+	srv.attachPeer("peer-a", tab2)
+	ok := &protocol.Message{IsResponse: true}
+
+	// Each tab subscribes to its own topic; both start the same protocol
+	srv.completeRequest(tab1, "peer-a", newSessionTestRequest(1, "subscribe", protocol.SubscribeRequest{Topic: "room-1"}), ok)
+	srv.completeRequest(tab2, "peer-a", newSessionTestRequest(1, "subscribe", protocol.SubscribeRequest{Topic: "room-2"}), ok)
+	start := newSessionTestRequest(2, "start", protocol.StartRequest{Protocol: "chat"})
+	if srv.interceptRequest(tab1, "peer-a", start) != nil {
+		t.Fatal("Expected the first start to reach the peer")
+	}
+	srv.completeRequest(tab1, "peer-a", start, ok)
+	if response := srv.interceptRequest(tab2, "peer-a", newSessionTestRequest(2, "start", protocol.StartRequest{Protocol: "chat"})); response == nil || response.Error != nil {
+		t.Fatal("Expected the second start to succeed without the peer")
+	}
+
+	srv.sendToPeer("peer-a", &protocol.Message{Method: "topicData", RequestID: 10}, holdsTopic("room-2"))
+	srv.sendToPeer("peer-a", &protocol.Message{Method: "peerData", RequestID: 11}, holdsProtocol("chat"))
+	srv.sendToPeer("peer-a", &protocol.Message{Method: "topicData", RequestID: 12}, holdsTopic("unknown"))
+	got1, got2 := queuedMessages(tab1), queuedMessages(tab2)
+	if len(got1) != 2 || got1[0].RequestID != 11 || got1[1].RequestID != 12 {
+		t.Errorf("Expected tab 1 to get messages 11 and 12, got %d messages", len(got1))
+	}
+	if len(got2) != 3 {
+		t.Errorf("Expected tab 2 to get all 3 messages, got %d", len(got2))
+	}
+
+	// Replayed history only goes to the tab that subscribed with it, whatever subscriber the client claims
+	subscribe := newSessionTestRequest(5, "subscribe", map[string]any{"topic": "room-1", "history": map[string]int{"maxMessages": 5}, "subscriber": "1"})
+	if srv.interceptRequest(tab2, "peer-a", subscribe) != nil {
+		t.Fatal("Expected the subscribe to reach the peer")
+	}
+	var subReq protocol.SubscribeRequest
+	json.Unmarshal(subscribe.Params, &subReq)
+	if subReq.Subscriber == "" || subReq.History == nil || subReq.History.MaxMessages != 5 {
+		t.Fatalf("Expected the subscribe to name tab 2's session and keep its history, got %+v", subReq)
+	}
+	srv.onTopicData("peer-a", "room-1", "peer-b", "old", peer.MessageMetadata{History: true, Subscriber: subReq.Subscriber})
+	if got1, got2 := queuedMessages(tab1), queuedMessages(tab2); len(got1) != 0 || len(got2) != 1 {
+		t.Errorf("Expected only tab 2 to get its history, got %d and %d messages", len(got1), len(got2))
+	}
+
+	// Both tabs number their acks from 0; acks come back to the right tab with its own number
+	send1 := newSessionTestRequest(3, "send", protocol.SendRequest{Peer: "peer-b", Protocol: "chat", Ack: 0})
+	send2 := newSessionTestRequest(3, "send", protocol.SendRequest{Peer: "peer-b", Protocol: "chat", Ack: 0})
+	srv.interceptRequest(tab1, "peer-a", send1)
+	srv.interceptRequest(tab2, "peer-a", send2)
+	var req1, req2 protocol.SendRequest
+	json.Unmarshal(send1.Params, &req1)
+	json.Unmarshal(send2.Params, &req2)
+	if req1.Ack == req2.Ack {
+		t.Fatalf("Expected server-wide ack numbers, both got %d", req1.Ack)
+	}
+	srv.onSendAck("peer-a", req2.Ack)
+	if len(queuedMessages(tab1)) != 0 {
+		t.Error("Expected tab 1 to get no ack")
+	}
+	if acks := queuedMessages(tab2); len(acks) != 1 || acks[0].Method != "ack" {
+		t.Error("Expected tab 2 to get its ack")
+	} else {
+		var ack protocol.AckRequest
+		json.Unmarshal(acks[0].Params, &ack)
+		if ack.Ack != 0 {
+			t.Errorf("Expected tab 2's own ack number 0, got %d", ack.Ack)
+		}
+	}
+
+	// Releasing a protocol another tab still uses stays on the server
+	if response := srv.interceptRequest(tab1, "peer-a", newSessionTestRequest(4, "stop", protocol.StopRequest{Protocol: "chat"})); response == nil {
+		t.Error("Expected stop to be answered while tab 2 still uses the protocol")
+	}
+	if response := srv.interceptRequest(tab2, "peer-a", newSessionTestRequest(4, "stop", protocol.StopRequest{Protocol: "chat"})); response != nil {
+		t.Error("Expected the last stop to reach the peer")
+	}
+
+	// The peer stays until its last connection leaves
+	srv.detachPeer("peer-a", tab1)
+	if !srv.IsPeerRegistered("peer-a") {
+		t.Fatal("Expected the peer to stay with tab 2 attached")
+	}
+	srv.detachPeer("peer-a", tab2)
+	if srv.IsPeerRegistered("peer-a") {
+		t.Error("Expected the peer to be removed with its last connection")
+	}
+}
+
+// TestConnectionPeers tests that one connection addresses several peers and gets their server messages
+func TestConnectionPeers(t *testing.T) {
+	srv := newSessionTestServer(0, 10)
+	defer srv.cancel()
+	conn := newSessionTestConn("peer-a")
+	if !conn.addPeer("peer-b") || conn.addPeer("peer-a") {
+		t.Fatal("Expected each peer to be added once")
+	}
+	srv.attachPeer("peer-a", conn)
+	srv.attachPeer("peer-b", conn)
+
+	for field, want := range map[string]string{"": "peer-a", "peer-a": "peer-a", "peer-b": "peer-b"} {
+		if got, ok := conn.requestPeer(&protocol.Message{Peer: field}); !ok || got != want {
+			t.Errorf("Expected peer field %q to address %s, got %q", field, want, got)
+		}
+	}
+	if _, ok := conn.requestPeer(&protocol.Message{Peer: "peer-c"}); ok {
+		t.Error("Expected a peer of another connection to be rejected")
+	}
+
+	// Server messages name the peer they are for
+	srv.sendToPeer("peer-b", &protocol.Message{Method: "topicData"}, nil)
+	srv.sendToPeer("peer-a", &protocol.Message{Method: "topicData"}, nil)
+	msgs := queuedMessages(conn)
+	if len(msgs) != 2 || msgs[0].Peer != "peer-b" || msgs[1].Peer != "peer-a" {
+		t.Errorf("Expected messages for peer-b then peer-a, got %d messages", len(msgs))
+	}
+
+	// Each peer's session ends on its own; a connection can't resume a peer it already has
+	srv.detachPeer("peer-a", conn)
+	if srv.IsPeerRegistered("peer-a") || !srv.IsPeerRegistered("peer-b") {
+		t.Error("Expected only peer-a to be removed")
+	}
+	resumable := newSessionTestServer(time.Minute, 10)
+	defer resumable.cancel()
+	token := resumable.attachPeer("peer-b", conn)
+	other := newSessionTestConn("")
+	resumable.attachPeer("peer-b", other)
+	if _, err := resumable.resumeSession(token, other); err == nil {
+		t.Error("Expected resuming a peer the connection already has to fail")
+	}
+}
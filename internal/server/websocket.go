@@ -22,16 +22,24 @@ var upgrader = websocket.Upgrader{
 // CRC: crc-WebSocketHandler.md
 type WSConnection struct {
 	conn          *websocket.Conn
-	peerID        string
-	peerCreated   bool
+	peerID        string   // Default peer, for requests without a peer field
+	peers         []string // Every peer created or resumed on this connection
 	handler       *protocol.Handler
 	peerManager   protocol.PeerManager
-	manager       *peer.Manager // For verbose logging
+	manager       *peer.Manager // For verbose logging and queue settings
 	server        *Server        // Reference to server for peer registration
 	sendCh        chan *protocol.Message
 	mu            sync.Mutex
 	closed        bool
 	closeCh       chan struct{}
+	binary        bool                   // Client accepts binary frames for byte payloads
+	sends         chan backgroundRequest // Sends that may block, handled in order by sendPump
+}
+
+// backgroundRequest is a client request handled off the read loop
+type backgroundRequest struct {
+	msg    protocol.Message
+	peerID string
 }
 
 // NewWSConnection creates a new WebSocket connection handler
@@ -46,6 +54,7 @@ func NewWSConnection(conn *websocket.Conn, handler *protocol.Handler, pm protoco
 		server:      server,
 		sendCh:      make(chan *protocol.Message, 100),
 		closeCh:     make(chan struct{}),
+		sends:       make(chan backgroundRequest, 100),
 	}
 }
 
@@ -54,6 +63,7 @@ func NewWSConnection(conn *websocket.Conn, handler *protocol.Handler, pm protoco
 func (ws *WSConnection) Start() {
 	go ws.readPump()
 	go ws.writePump()
+	go ws.sendPump()
 }
 
 // SendMessage queues a message to be sent to the client
@@ -73,6 +83,17 @@ func (ws *WSConnection) SendMessage(msg *protocol.Message) error {
 	}
 }
 
+// queueMessage queues a message, waiting for room in the send buffer
+// Returns false if the connection closed first
+func (ws *WSConnection) queueMessage(msg *protocol.Message) bool {
+	select {
+	case ws.sendCh <- msg:
+		return true
+	case <-ws.closeCh:
+		return false
+	}
+}
+
 // Close closes the WebSocket connection
 func (ws *WSConnection) Close() {
 	ws.mu.Lock()
@@ -81,19 +102,17 @@ func (ws *WSConnection) Close() {
 		return
 	}
 	ws.closed = true
-	peerID := ws.peerID
-	peerCreated := ws.peerCreated
+	peers := ws.peers
 	ws.mu.Unlock()
 
-	// Clean up peer if it was created
-	if peerCreated && peerID != "" && ws.peerManager != nil {
-		// Unregister peer from server
+	// Clean up the connection's peers: the server removes each one when its last session ends
+	for _, peerID := range peers {
 		if ws.server != nil {
-			ws.server.UnregisterPeer(peerID)
-		}
-
-		if err := ws.peerManager.RemovePeer(peerID); err != nil {
-			fmt.Printf("Failed to remove peer %s: %v\n", peerID, err)
+			ws.server.detachPeer(peerID, ws)
+		} else if ws.peerManager != nil {
+			if err := ws.peerManager.RemovePeer(peerID); err != nil {
+				fmt.Printf("Failed to remove peer %s: %v\n", peerID, err)
+			}
 		}
 	}
 
@@ -106,11 +125,58 @@ func (ws *WSConnection) SetPeerID(peerID string) {
 	ws.peerID = peerID
 }
 
+// setBinary records that the client accepts binary frames
+func (ws *WSConnection) setBinary() {
+	ws.mu.Lock()
+	ws.binary = true
+	ws.mu.Unlock()
+}
+
+// acceptsBinary returns true if byte payloads can be sent as binary frames
+func (ws *WSConnection) acceptsBinary() bool {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return ws.binary
+}
+
 // GetPeerID returns the peer ID for this connection
 func (ws *WSConnection) GetPeerID() string {
 	return ws.peerID
 }
 
+// addPeer records a peer created or resumed on this connection; the first one becomes the default
+// Returns false if the connection already has the peer
+func (ws *WSConnection) addPeer(peerID string) bool {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	for _, existing := range ws.peers {
+		if existing == peerID {
+			return false
+		}
+	}
+	ws.peers = append(ws.peers, peerID)
+	if ws.peerID == "" {
+		ws.peerID = peerID
+	}
+	return true
+}
+
+// requestPeer returns the peer a request addresses: its peer field, or the default peer
+// Returns false if the peer field names a peer this connection doesn't have
+func (ws *WSConnection) requestPeer(msg *protocol.Message) (string, bool) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if msg.Peer == "" {
+		return ws.peerID, true
+	}
+	for _, peerID := range ws.peers {
+		if peerID == msg.Peer {
+			return peerID, true
+		}
+	}
+	return "", false
+}
+
 // readPump reads messages from the WebSocket
 func (ws *WSConnection) readPump() {
 	defer ws.Close()
@@ -124,7 +190,7 @@ func (ws *WSConnection) readPump() {
 			return
 		}
 
-		_, data, err := ws.conn.ReadMessage()
+		frameType, data, err := ws.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				fmt.Printf("WebSocket read error: %v\n", err)
@@ -132,9 +198,17 @@ func (ws *WSConnection) readPump() {
 			return
 		}
 
-		// Parse message
+		// Parse message (binary frames carry a JSON header plus a raw body)
 		var msg protocol.Message
-		if err := json.Unmarshal(data, &msg); err != nil {
+		if frameType == websocket.BinaryMessage {
+			decoded, err := protocol.DecodeBinaryFrame(data)
+			if err != nil {
+				fmt.Printf("Failed to decode binary frame: %v\n", err)
+				continue
+			}
+			msg = *decoded
+			ws.setBinary()
+		} else if err := json.Unmarshal(data, &msg); err != nil {
 			fmt.Printf("Failed to unmarshal message: %v\n", err)
 			continue
 		}
@@ -148,25 +222,88 @@ func (ws *WSConnection) readPump() {
 			ws.manager.LogVerbose(peerID, 2, "WS received: %s (req: %d)", msg.Method, msg.RequestID)
 		}
 
+		// Responses answer server requests sent to this connection
+		if msg.IsResponse {
+			ws.handler.HandleResponse(ws, &msg)
+			continue
+		}
+
+		// A peer request with a resume token reattaches to a disconnected peer
+		if msg.Method == "peer" && ws.server != nil {
+			var req protocol.PeerRequest
+			if err := json.Unmarshal(msg.Params, &req); err == nil && req.Resume != "" {
+				ws.resumePeer(&msg, req)
+				continue
+			}
+		}
+
+		// Requests address the default peer unless their peer field picks another of the connection's peers
+		peerID, ok := ws.requestPeer(&msg)
+		if !ok {
+			if err := ws.SendMessage(ws.handler.CreateErrorResponse(msg.RequestID, 403, fmt.Sprintf("peer %s is not on this connection", msg.Peer))); err != nil {
+				fmt.Printf("Failed to send response for req %d: %v\n", msg.RequestID, err)
+				return
+			}
+			continue
+		}
+
+		// Requests to a peer shared with other connections update this connection's session first
+		if peerID != "" && ws.server != nil {
+			if response := ws.server.interceptRequest(ws, peerID, &msg); response != nil {
+				if err := ws.SendMessage(response); err != nil {
+					fmt.Printf("Failed to send response for req %d: %v\n", msg.RequestID, err)
+					return
+				}
+				continue
+			}
+		}
+
+		// Calls and pings wait for the remote peer, so don't hold up the read loop
+		if msg.Method == "call" || msg.Method == "peerinfo" {
+			go ws.handleInBackground(msg, peerID)
+			continue
+		}
+
+		// Sends wait for room in full queues with the block policy; the send pump keeps them in order
+		if msg.Method == "send" && ws.manager != nil && ws.manager.SendsMayBlock() {
+			select {
+			case ws.sends <- backgroundRequest{msg: msg, peerID: peerID}:
+			case <-ws.closeCh:
+				return
+			}
+			continue
+		}
+
 		// Handle message
-		response, err := ws.handler.HandleClientMessage(&msg, ws.peerID)
+		response, err := ws.handler.HandleClientMessage(&msg, peerID)
 		if err != nil {
 			fmt.Printf("Failed to handle message: %v\n", err)
 			continue
 		}
+		if response == nil {
+			continue
+		}
+		if peerID != "" && ws.server != nil {
+			ws.server.completeRequest(ws, peerID, &msg, response)
+		}
 
-		// Special handling for "peer" command - set peer ID on first call
+		// Special handling for "peer" command - the first peer becomes the connection's default
 		if msg.Method == "peer" && response.Error == nil {
+			var req protocol.PeerRequest
+			if err := json.Unmarshal(msg.Params, &req); err == nil && req.Binary {
+				ws.setBinary()
+			}
+
 			var resp protocol.PeerResponse
 			if err := json.Unmarshal(response.Result, &resp); err == nil {
-				ws.mu.Lock()
-				ws.peerID = resp.PeerID
-				ws.peerCreated = true
-				ws.mu.Unlock()
-
-				// Register peer with server
-				if ws.server != nil {
-					ws.server.RegisterPeer(resp.PeerID, ws)
+				if !ws.addPeer(resp.PeerID) {
+					// The key or identity of a peer this connection already has
+					response = ws.handler.CreateErrorResponse(msg.RequestID, 400, "peer already on this connection")
+				} else if ws.server != nil {
+					// Attach to the peer (new, or shared with other connections) and make the session resumable
+					if resp.ResumeToken = ws.server.attachPeer(resp.PeerID, ws); resp.ResumeToken != "" {
+						response.Result, _ = json.Marshal(resp)
+					}
 				}
 			}
 		}
@@ -194,6 +331,57 @@ func (ws *WSConnection) readPump() {
 	}
 }
 
+// resumePeer reattaches this connection to a disconnected peer and replays its buffered messages
+// CRC: crc-WebSocketHandler.md
+func (ws *WSConnection) resumePeer(msg *protocol.Message, req protocol.PeerRequest) {
+	peerID, err := ws.server.resumeSession(req.Resume, ws)
+	if err != nil {
+		ws.SendMessage(ws.handler.CreateErrorResponse(msg.RequestID, 404, err.Error()))
+		return
+	}
+	if req.Binary {
+		ws.setBinary()
+	}
+	ws.addPeer(peerID)
+	if ws.manager != nil {
+		ws.manager.LogVerbose(peerID, 1, "Resumed peer on a new WebSocket connection")
+	}
+
+	// The response goes first, so the client knows its peer before the replayed messages arrive
+	if err := ws.SendMessage(ws.handler.CreateResumeResponse(msg.RequestID, peerID, req.Resume)); err != nil {
+		fmt.Printf("Failed to send response for req %d: %v\n", msg.RequestID, err)
+	}
+	go ws.server.replaySession(peerID, ws)
+}
+
+// handleInBackground handles a long-running request for one of the connection's peers and sends its response when done
+func (ws *WSConnection) handleInBackground(msg protocol.Message, peerID string) {
+	response, err := ws.handler.HandleClientMessage(&msg, peerID)
+	if err != nil {
+		fmt.Printf("Failed to handle message: %v\n", err)
+		return
+	}
+	if peerID != "" && ws.server != nil {
+		ws.server.completeRequest(ws, peerID, &msg, response)
+	}
+
+	if err := ws.SendMessage(response); err != nil {
+		fmt.Printf("Failed to send response for req %d: %v\n", msg.RequestID, err)
+	}
+}
+
+// sendPump handles the connection's sends that may block, one at a time so they reach their queues in order
+func (ws *WSConnection) sendPump() {
+	for {
+		select {
+		case req := <-ws.sends:
+			ws.handleInBackground(req.msg, req.peerID)
+		case <-ws.closeCh:
+			return
+		}
+	}
+}
+
 // writePump writes messages to the WebSocket
 func (ws *WSConnection) writePump() {
 	defer ws.Close()
@@ -201,13 +389,23 @@ func (ws *WSConnection) writePump() {
 	for {
 		select {
 		case msg := <-ws.sendCh:
-			data, err := json.Marshal(msg)
+			// Byte payloads go out as binary frames to clients that accept them,
+			// otherwise base64-encoded in the JSON params
+			frameType := websocket.TextMessage
+			var data []byte
+			var err error
+			if msg.Body != nil && ws.acceptsBinary() {
+				frameType = websocket.BinaryMessage
+				data, err = protocol.EncodeBinaryFrame(msg)
+			} else {
+				data, err = protocol.EncodeTextFrame(msg)
+			}
 			if err != nil {
 				fmt.Printf("Failed to marshal message: %v\n", err)
 				continue
 			}
 
-			if err := ws.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			if err := ws.conn.WriteMessage(frameType, data); err != nil {
 				fmt.Printf("Failed to write message: %v\n", err)
 				return
 			}
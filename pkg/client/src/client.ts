@@ -3,22 +3,68 @@ import {
   Message,
   StringResponse,
   PeerResponse,
+  PeerRequest,
   ListPeersResponse,
+  IdentityInfo,
+  ListIdentitiesResponse,
+  PeerInfo,
+  Connectivity,
+  Blocklist,
   FileEntry,
   FileContent,
   StoreFileResponse,
   ConnectOptions,
+  AddPeerOptions,
   ProtocolDataCallback,
+  ProtocolCallCallback,
   TopicDataCallback,
   PeerChangeCallback,
+  SendFailedCallback,
+  TopicValidator,
+  TopicHistory,
+  TopicKey,
+  GroupKeyResponse,
   PeerDataRequest,
+  PeerCallRequest,
+  CallResponse,
   TopicDataRequest,
   PeerChangeRequest,
   AckRequest,
+  SendFailedRequest,
+  PeerConnectionRequest,
+  ReachabilityRequest,
+  AddressesRequest,
+  ConnectivityEvent,
+  ConnectivityCallback,
   PeerFilesRequest,
   GotFileRequest,
 } from './types.js';
 
+// Binary frames: [4-byte big-endian header length][JSON Message header][raw body]
+function encodeFrame(msg: Message, body: Uint8Array): ArrayBuffer {
+  const header = new TextEncoder().encode(JSON.stringify(msg));
+  const frame = new Uint8Array(4 + header.length + body.length);
+  new DataView(frame.buffer).setUint32(0, header.length);
+  frame.set(header, 4);
+  frame.set(body, 4 + header.length);
+  return frame.buffer;
+}
+
+function decodeFrame(buffer: ArrayBuffer): { msg: Message; body: Uint8Array } {
+  const headerLength = new DataView(buffer).getUint32(0);
+  const header = new TextDecoder().decode(new Uint8Array(buffer, 4, headerLength));
+  return { msg: JSON.parse(header), body: new Uint8Array(buffer, 4 + headerLength) };
+}
+
+function base64ToBytes(base64: string): Uint8Array {
+  const binaryString = atob(base64);
+  const bytes = new Uint8Array(binaryString.length);
+  for (let i = 0; i < binaryString.length; i++) {
+    bytes[i] = binaryString.charCodeAt(i);
+  }
+  return bytes;
+}
+
 interface PendingRequest {
   resolve: (value: any) => void;
   reject: (error: Error) => void;
@@ -36,13 +82,17 @@ export class P2PWebAppClient {
   private _connected: boolean = false;
   private _peerID: string | null = null;
   private _peerKey: string | null = null;
+  private _resumeToken: string | null = null;
   private _version: string | null = null;
   private onCloseCallback: (() => void) | null = null;
   private requestID: number = 0;
   private pending: Map<number, PendingRequest> = new Map();
   private protocolListeners: Map<string, ProtocolDataCallback> = new Map(); // key: protocol
+  private callListeners: Map<string, ProtocolCallCallback> = new Map(); // key: protocol
   private topicListeners: Map<string, TopicDataCallback> = new Map();
   private peerChangeListeners: Map<string, PeerChangeCallback> = new Map(); // key: topic
+  private sendFailedListener: SendFailedCallback | null = null;
+  private connectivityListener: ConnectivityCallback | null = null;
 
   // Message queuing for sequential processing
   private messageQueue: Message[] = [];
@@ -56,9 +106,13 @@ export class P2PWebAppClient {
   private fileListPending: Map<string, PendingPromiseRequest<{ rootCID: string; entries: { [path: string]: FileEntry } }>> = new Map(); // key: peerID
   private getFilePending: Map<string, PendingPromiseRequest<FileContent>> = new Map(); // key: CID
 
+  // Several peers on one connection
+  private owner: P2PWebAppClient | null = null; // Client owning the WebSocket, for peers added with addPeer()
+  private addedPeers: Map<string, P2PWebAppClient> = new Map(); // key: peerID of peers added with addPeer()
+
   /**
    * Connect to the WebSocket server and initialize peer identity
-   * @param options Optional connection options (peerKey, onClose callback)
+   * @param options Optional connection options (peerKey or identity, onClose callback)
    * @returns Promise resolving to this client instance
    * CRC: crc-P2PWebAppClient.md
    */
@@ -69,6 +123,7 @@ export class P2PWebAppClient {
     // First, establish WebSocket connection
     await new Promise<void>((resolve, reject) => {
       this.ws = new WebSocket(wsUrl);
+      this.ws.binaryType = 'arraybuffer'; // Byte payloads arrive as binary frames
 
       this.ws.onopen = () => resolve();
       this.ws.onerror = (error) => reject(new Error('WebSocket connection failed'));
@@ -76,20 +131,79 @@ export class P2PWebAppClient {
       this.ws.onclose = () => this.handleClose();
     });
 
+    await this.initPeer(options);
+    return this;
+  }
+
+  /**
+   * Create or resume another peer on this connection, e.g. a moderator bot next to the user,
+   * or simulated users in a test harness
+   * The returned client addresses the new peer over this client's WebSocket; closing either closes both
+   * @param options Optional peer options (peerKey, identity or resume token)
+   * @returns Promise resolving to a client for the added peer
+   * CRC: crc-P2PWebAppClient.md
+   */
+  async addPeer(options?: AddPeerOptions): Promise<P2PWebAppClient> {
+    const owner = this.owner ?? this;
+    const added = new P2PWebAppClient();
+    added.owner = owner;
+    await added.initPeer(options);
+    owner.addedPeers.set(added._peerID!, added);
+    return added;
+  }
+
+  /**
+   * Send the Peer() request: resume, use an identity or key, or create a fresh peer
+   */
+  private async initPeer(options?: AddPeerOptions): Promise<void> {
+    // Reattach to the disconnected peer if the server still has it;
+    // otherwise its subscriptions and listeners are gone, so start over
+    if (options?.resume) {
+      try {
+        const resumed = (await this.sendRequest('peer', { resume: options.resume, binary: true })) as PeerResponse;
+        this._peerID = resumed.peerid;
+        this._resumeToken = resumed.resumeToken ?? null;
+        this._version = resumed.version;
+        this._connected = true;
+        return;
+      } catch {
+        this.resetState();
+      }
+    }
+
     // Then, initialize peer identity
-    const result = await this.sendRequest('peer', options?.peerKey ? { peerkey: options.peerKey } : {});
+    // A server-side identity keeps the key on the server, so peerKey stays null
+    let params: PeerRequest = { binary: true };
+    if (options?.identity) {
+      if (options.createIdentity) {
+        const identities = await this.listIdentities();
+        if (!identities.some((id) => id.name === options.identity)) {
+          await this.createIdentity(options.identity, { passphrase: options.passphrase });
+        }
+      }
+      params = { identity: options.identity, passphrase: options.passphrase, binary: true };
+    } else if (options?.peerKey) {
+      params = { peerkey: options.peerKey, binary: true };
+    }
+    const result = await this.sendRequest('peer', params);
     const response = result as PeerResponse;
     this._peerID = response.peerid;
-    this._peerKey = response.peerkey;
+    this._peerKey = response.peerkey || null;
+    this._resumeToken = response.resumeToken ?? null;
     this._version = response.version;
     this._connected = true;
-    return this;
   }
 
   /**
-   * Close the WebSocket connection
+   * Close the WebSocket connection, with every peer on it
    */
   close(): void {
+    if (this.owner) {
+      this.owner.close(); // Added peers share their owner's connection
+      return;
+    }
+    this._resumeToken = null; // Closing on purpose: don't keep state for a resume
+    this.addedPeers.forEach((added) => (added._resumeToken = null));
     if (this.ws) {
       this.ws.close();
       this.ws = null;
@@ -99,13 +213,17 @@ export class P2PWebAppClient {
   /**
    * Start a protocol with a data listener (required before sending)
    * The listener receives (peer, data) for all messages on this protocol
+   * The optional call listener answers call() requests from peers; its return value is the reply
    */
-  async start(protocol: string, onData: ProtocolDataCallback): Promise<void> {
+  async start(protocol: string, onData: ProtocolDataCallback, onCall?: ProtocolCallCallback): Promise<void> {
     if (this.protocolListeners.has(protocol)) {
       throw new Error(`Protocol '${protocol}' already started`);
     }
     await this.sendRequest('start', { protocol });
     this.protocolListeners.set(protocol, onData);
+    if (onCall) {
+      this.callListeners.set(protocol, onCall);
+    }
   }
 
   /**
@@ -117,14 +235,16 @@ export class P2PWebAppClient {
     }
     await this.sendRequest('stop', { protocol });
     this.protocolListeners.delete(protocol);
+    this.callListeners.delete(protocol);
   }
 
   /**
    * Send data to a peer on a protocol
    * @param peer Target peer ID
    * @param protocol Protocol name
-   * @param data Data to send
+   * @param data Data to send (a Uint8Array is sent as raw bytes and received as a Uint8Array)
    * @returns Promise that resolves when delivery is confirmed
+   * Rejects with an error whose code is 429 when the peer's queue is full
    */
   async send(peer: string, protocol: string, data: any): Promise<void> {
     if (!this.protocolListeners.has(protocol)) {
@@ -139,30 +259,118 @@ export class P2PWebAppClient {
       this.ackPending.set(ackNum, { resolve, reject });
     });
 
-    // Send the request
-    await this.sendRequest('send', { peer, protocol, data, ack: ackNum });
+    // Send the request (bytes go in a binary frame instead of the JSON params)
+    try {
+      if (data instanceof Uint8Array) {
+        await this.sendRequest('send', { peer, protocol, ack: ackNum, binary: true }, data);
+      } else {
+        await this.sendRequest('send', { peer, protocol, data, ack: ackNum });
+      }
+    } catch (error) {
+      // Rejected sends (e.g. queue full) will never be acked
+      this.ackPending.delete(ackNum);
+      throw error;
+    }
 
     // Wait for ack
     return ackPromise;
   }
 
+  /**
+   * Send a request to a peer on a protocol and wait for its reply
+   * The remote peer answers with the call listener it passed to start()
+   * @param peer Target peer ID
+   * @param protocol Protocol name
+   * @param data Request data
+   * @param timeout Optional milliseconds to wait for the reply (default 30s)
+   * @returns Promise resolving to the remote peer's reply
+   */
+  async call(peer: string, protocol: string, data: any, timeout?: number): Promise<any> {
+    if (!this.protocolListeners.has(protocol)) {
+      throw new Error(`Cannot call on protocol '${protocol}': protocol not started. Call start() first.`);
+    }
+    const params: any = { peer, protocol, data };
+    if (timeout) {
+      params.timeout = timeout;
+    }
+    const result = await this.sendRequest('call', params);
+    return (result as CallResponse).data;
+  }
+
+  /**
+   * Reset the queues to a peer that was marked unreachable so sends are retried
+   * @param peer Target peer ID
+   * @param protocol Optional protocol (omit to reset every protocol for the peer)
+   */
+  async resetQueue(peer: string, protocol?: string): Promise<void> {
+    await this.sendRequest('resetqueue', protocol ? { peer, protocol } : { peer });
+  }
+
+  /**
+   * Set a listener notified when sends to a peer are abandoned because it became unreachable
+   * Pending send() promises for the abandoned messages are rejected as well
+   */
+  onSendFailed(listener: SendFailedCallback | null): void {
+    this.sendFailedListener = listener;
+  }
+
   /**
    * Subscribe to a topic with data listener and optional peer change listener
    * Automatically monitors the topic for peer join/leave events if onPeerChange is provided
+   * With history, the topic's recent messages are delivered first, with metadata.history set
+   * With encryption, the topic is encrypted with a group key (see setTopicKey)
    */
-  async subscribe(topic: string, onData: TopicDataCallback, onPeerChange?: PeerChangeCallback): Promise<void> {
+  async subscribe(topic: string, onData: TopicDataCallback, onPeerChange?: PeerChangeCallback, history?: TopicHistory, encryption?: TopicKey): Promise<void> {
     this.topicListeners.set(topic, onData);
     if (onPeerChange) {
       this.peerChangeListeners.set(topic, onPeerChange);
     }
-    await this.sendRequest('subscribe', { topic });
+    await this.sendRequest('subscribe', { topic, ...(history ? { history } : {}), ...encryption });
   }
 
   /**
    * Publish data to a topic
+   * Encrypted with the topic's current group key, or with encryption for this message only
    */
-  async publish(topic: string, data: any): Promise<void> {
-    await this.sendRequest('publish', { topic, data });
+  async publish(topic: string, data: any, encryption?: TopicKey): Promise<void> {
+    await this.sendRequest('publish', { topic, data, ...encryption });
+  }
+
+  /**
+   * Store a group key on the peer for encrypted topics
+   * @param key Base64 32-byte key (omit to generate one)
+   * @returns The key's ID and the base64 key, to share with the other members out of band
+   */
+  async addGroupKey(key?: string): Promise<GroupKeyResponse> {
+    return await this.sendRequest('addgroupkey', key ? { key } : {}) as GroupKeyResponse;
+  }
+
+  /**
+   * Encrypt a topic with a group key, or rotate it to a new one
+   * Messages are published with this key; earlier keys are still accepted until removed
+   * @returns The key's ID
+   */
+  async setTopicKey(topic: string, encryption: TopicKey): Promise<string> {
+    const response = await this.sendRequest('settopickey', { topic, ...encryption }) as GroupKeyResponse;
+    return response.keyId;
+  }
+
+  /**
+   * Stop accepting a retired group key on a topic
+   * @param keyId Key to remove (omit to remove every key, leaving the topic unencrypted)
+   */
+  async removeTopicKey(topic: string, keyId?: string): Promise<void> {
+    await this.sendRequest('removetopickey', keyId ? { topic, keyId } : { topic });
+  }
+
+  /**
+   * Set the checks gossipsub applies to a topic's messages, replacing any previous validator
+   * Invalid messages are dropped before delivery and forwarding, and their sender is penalized
+   * @param topic Topic name
+   * @param validator Checks to apply (an empty object removes the validator)
+   */
+  async setTopicValidator(topic: string, validator: TopicValidator): Promise<void> {
+    await this.sendRequest('settopicvalidator', { topic, ...validator });
   }
 
   /**
@@ -199,6 +407,88 @@ export class P2PWebAppClient {
     await this.sendRequest('removepeers', { peerIds });
   }
 
+  /**
+   * Get what this peer knows about another peer: connections, addresses, protocols and latency
+   * @param peerId Peer to describe
+   * @param ping Measure the round trip time with the libp2p ping protocol first
+   */
+  async peerInfo(peerId: string, ping = false): Promise<PeerInfo> {
+    return await this.sendRequest('peerinfo', { peer: peerId, ping }) as PeerInfo;
+  }
+
+  /**
+   * Get this peer's NAT reachability, its addresses and the peers it is connected to
+   */
+  async connections(): Promise<Connectivity> {
+    return await this.sendRequest('connections', {}) as Connectivity;
+  }
+
+  /**
+   * Set a listener notified when peers added with addPeers() connect or drop,
+   * when AutoNAT changes this peer's reachability, and when its addresses change
+   * (e.g. a relay reservation adds a /p2p-circuit address)
+   */
+  onConnectivity(listener: ConnectivityCallback | null): void {
+    this.connectivityListener = listener;
+  }
+
+  /**
+   * Connect to a bootstrap peer, e.g. on an air-gapped network without public bootstrap nodes
+   * @param addr Multiaddr of the peer, ending in /p2p/<peer id>
+   */
+  async bootstrap(addr: string): Promise<void> {
+    await this.sendRequest('bootstrap', { addr });
+  }
+
+  /**
+   * Store a named identity on the server; select it later with connect({identity: name})
+   * @param name Identity name (letters, digits, dashes and underscores)
+   * @param options peerKey imports an existing key (e.g. this.peerKey), passphrase encrypts it at rest
+   * @returns Promise resolving to the identity's peer ID
+   */
+  async createIdentity(name: string, options?: { peerKey?: string; passphrase?: string }): Promise<string> {
+    const result = await this.sendRequest('createidentity', {
+      name,
+      peerkey: options?.peerKey,
+      passphrase: options?.passphrase,
+    });
+    return (result as StringResponse).value;
+  }
+
+  /**
+   * List the identities stored on the server (names and peer IDs only)
+   * @returns Promise resolving to the stored identities
+   */
+  async listIdentities(): Promise<IdentityInfo[]> {
+    const result = await this.sendRequest('listidentities', {});
+    return (result as ListIdentitiesResponse).identities;
+  }
+
+  /**
+   * Block a peer on the server: its connections are closed and refused, and its topic messages are ignored
+   * The blocklist is shared by every peer of the server and kept across restarts
+   * @param peerId Peer ID to block
+   * @param reason Optional note shown by listBlocked()
+   */
+  async blockPeer(peerId: string, reason?: string): Promise<void> {
+    await this.sendRequest('blockpeer', { peer: peerId, reason });
+  }
+
+  /**
+   * Remove a peer from the server's blocklist
+   * @param peerId Peer ID to unblock
+   */
+  async unblockPeer(peerId: string): Promise<void> {
+    await this.sendRequest('unblockpeer', { peer: peerId });
+  }
+
+  /**
+   * List the server's blocked peers and its allow list
+   */
+  async listBlocked(): Promise<Blocklist> {
+    return await this.sendRequest('listblocked', {}) as Blocklist;
+  }
+
   /**
    * List files for a peer
    * @param peerid Peer ID whose files to list
@@ -268,27 +558,10 @@ export class P2PWebAppClient {
    * @returns Promise resolving to StoreFileResponse with fileCid and rootCid
    */
   async storeFile(path: string, content: string | Uint8Array): Promise<StoreFileResponse> {
-    let base64Content: string;
-
-    if (typeof content === 'string') {
-      // Convert string to UTF-8 bytes then to base64
-      const encoder = new TextEncoder();
-      const bytes = encoder.encode(content);
-      let binaryString = '';
-      for (let i = 0; i < bytes.length; i++) {
-        binaryString += String.fromCharCode(bytes[i]);
-      }
-      base64Content = btoa(binaryString);
-    } else {
-      // Encode Uint8Array to base64 for binary files
-      let binaryString = '';
-      for (let i = 0; i < content.length; i++) {
-        binaryString += String.fromCharCode(content[i]);
-      }
-      base64Content = btoa(binaryString);
-    }
+    // Strings are stored as UTF-8; the bytes travel as the binary frame body
+    const bytes = typeof content === 'string' ? new TextEncoder().encode(content) : content;
 
-    const result = await this.sendRequest('storefile', { path, content: base64Content, directory: false });
+    const result = await this.sendRequest('storefile', { path, directory: false }, bytes);
     return { fileCid: result.fileCid, rootCid: result.rootCid };
   }
 
@@ -318,7 +591,15 @@ export class P2PWebAppClient {
   }
 
   /**
-   * Get the current peer key
+   * Get the token that reattaches a new connection to this peer after a disconnect
+   * Pass it to connect({resume}) within the server's resume grace period (null if resumption is disabled)
+   */
+  get resumeToken(): string | null {
+    return this._resumeToken;
+  }
+
+  /**
+   * Get the current peer key (null for server-side identities)
    */
   get peerKey(): string | null {
     return this._peerKey;
@@ -347,7 +628,15 @@ export class P2PWebAppClient {
 
   private handleMessage(event: MessageEvent): void {
     try {
-      const msg: Message = JSON.parse(event.data);
+      let msg: Message;
+      if (event.data instanceof ArrayBuffer) {
+        const frame = decodeFrame(event.data);
+        msg = frame.msg;
+        this.attachBinaryPayload(msg, frame.body);
+      } else {
+        msg = JSON.parse(event.data);
+        this.attachBinaryPayload(msg, null);
+      }
 
       if (msg.isresponse) {
         // Handle response to our request (not queued, processed immediately)
@@ -355,21 +644,40 @@ export class P2PWebAppClient {
         if (pending) {
           this.pending.delete(msg.requestid);
           if (msg.error) {
-            pending.reject(new Error(msg.error.message));
+            pending.reject(Object.assign(new Error(msg.error.message), { code: msg.error.code }));
           } else {
             pending.resolve(msg.result);
           }
         }
       } else {
-        // Queue server-initiated requests for sequential processing
-        this.messageQueue.push(msg);
-        this.processMessageQueue();
+        // Queue server-initiated requests for sequential processing, by the client of the peer they are for
+        const target = (msg.peer && this.addedPeers.get(msg.peer)) || this;
+        target.messageQueue.push(msg);
+        target.processMessageQueue();
       }
     } catch (error) {
       console.error('Failed to handle message:', error);
     }
   }
 
+  /**
+   * Put byte payloads where listeners expect them as Uint8Arrays
+   * Binary frames carry them in the body, text frames as base64
+   */
+  private attachBinaryPayload(msg: Message, body: Uint8Array | null): void {
+    if (!msg.params?.binary) {
+      return;
+    }
+    switch (msg.method) {
+      case 'peerData':
+        msg.params.data = body ?? base64ToBytes(msg.params.data);
+        break;
+      case 'gotFile':
+        msg.params.content.content = body ?? base64ToBytes(msg.params.content.content);
+        break;
+    }
+  }
+
   private async processMessageQueue(): Promise<void> {
     // If already processing, return (next message will be processed when current finishes)
     if (this.processingMessage) {
@@ -403,7 +711,7 @@ export class P2PWebAppClient {
           const listener = this.protocolListeners.get(req.protocol);
           if (listener) {
             try {
-              await listener(req.peer, req.data);
+              await listener(req.peer, req.data, req.metadata);
             } catch (error) {
               console.error('Error in peerData listener:', error);
             }
@@ -411,13 +719,20 @@ export class P2PWebAppClient {
         }
         break;
 
+      case 'peerCall':
+        if (msg.params) {
+          // Answer without blocking the message queue while the listener works
+          this.answerCall(msg.requestid, msg.params as PeerCallRequest);
+        }
+        break;
+
       case 'topicData':
         if (msg.params) {
           const req = msg.params as TopicDataRequest;
           const listener = this.topicListeners.get(req.topic);
           if (listener) {
             try {
-              await listener(req.peerid, req.data);
+              await listener(req.peerid, req.data, req.metadata);
             } catch (error) {
               console.error('Error in topicData listener:', error);
             }
@@ -450,6 +765,47 @@ export class P2PWebAppClient {
         }
         break;
 
+      case 'sendFailed':
+        if (msg.params) {
+          const req = msg.params as SendFailedRequest;
+          for (const ack of req.acks || []) {
+            const pending = this.ackPending.get(ack);
+            if (pending) {
+              this.ackPending.delete(ack); // Remove pending promise after use
+              pending.reject(new Error(`Send to ${req.peer} failed: ${req.reason}`));
+            }
+          }
+          if (this.sendFailedListener) {
+            try {
+              await this.sendFailedListener(req.peer, req.protocol, req.reason);
+            } catch (error) {
+              console.error('Error in sendFailed listener:', error);
+            }
+          }
+        }
+        break;
+
+      case 'peerConnection':
+        if (msg.params) {
+          const req = msg.params as PeerConnectionRequest;
+          await this.notifyConnectivity({ type: 'peerConnection', peer: req.peer, connected: req.connected, limited: req.limited });
+        }
+        break;
+
+      case 'reachability':
+        if (msg.params) {
+          const req = msg.params as ReachabilityRequest;
+          await this.notifyConnectivity({ type: 'reachability', reachability: req.reachability });
+        }
+        break;
+
+      case 'addresses':
+        if (msg.params) {
+          const req = msg.params as AddressesRequest;
+          await this.notifyConnectivity({ type: 'addresses', addrs: req.addrs || [], relayed: req.relayed });
+        }
+        break;
+
       case 'peerFiles':
         if (msg.params) {
           const req = msg.params as PeerFilesRequest;
@@ -478,13 +834,67 @@ export class P2PWebAppClient {
     }
   }
 
+  private async notifyConnectivity(event: ConnectivityEvent): Promise<void> {
+    if (this.connectivityListener) {
+      try {
+        await this.connectivityListener(event);
+      } catch (error) {
+        console.error('Error in connectivity listener:', error);
+      }
+    }
+  }
+
+  private async answerCall(requestid: number, req: PeerCallRequest): Promise<void> {
+    const listener = this.callListeners.get(req.protocol);
+    const response: Message = { requestid, isresponse: true };
+    if (!listener) {
+      response.error = { code: 404, message: `No call listener for protocol '${req.protocol}'` };
+    } else {
+      try {
+        const reply = await listener(req.peer, req.data);
+        response.result = reply === undefined ? null : reply;
+      } catch (error) {
+        response.error = { code: 500, message: error instanceof Error ? error.message : String(error) };
+      }
+    }
+    const ws = (this.owner ?? this).ws;
+    if (ws && ws.readyState === WebSocket.OPEN) {
+      ws.send(JSON.stringify(response));
+    }
+  }
+
   private handleClose(): void {
     // Update connection state
     this._connected = false;
     this.ws = null;
 
+    // A resumable peer keeps its listeners and pending sends: the server buffers their messages
+    if (!this._resumeToken) {
+      this.resetState();
+    }
+
+    // Added peers lose the shared connection too; resume them with addPeer({resume})
+    this.addedPeers.forEach((added) => {
+      added._connected = false;
+      if (!added._resumeToken) {
+        added.resetState();
+      }
+    });
+    this.addedPeers.clear();
+
+    // Call the onClose callback if set
+    if (this.onCloseCallback) {
+      this.onCloseCallback();
+    }
+  }
+
+  /**
+   * Forget listeners and reject pending operations of the previous peer
+   */
+  private resetState(): void {
     // Clean up all listeners on disconnect
     this.protocolListeners.clear();
+    this.callListeners.clear();
     this.topicListeners.clear();
     this.peerChangeListeners.clear();
 
@@ -500,14 +910,17 @@ export class P2PWebAppClient {
 
     this.messageQueue.length = 0;
     this.processingMessage = false;
+  }
 
-    // Call the onClose callback if set
-    if (this.onCloseCallback) {
-      this.onCloseCallback();
+  private sendRequest(method: string, params: any, body?: Uint8Array): Promise<any> {
+    // Added peers send over their owner's connection, naming their peer
+    if (this.owner) {
+      return this.owner.sendPeerRequest(this._peerID, method, params, body);
     }
+    return this.sendPeerRequest(null, method, params, body);
   }
 
-  private sendRequest(method: string, params: any): Promise<any> {
+  private sendPeerRequest(peer: string | null, method: string, params: any, body?: Uint8Array): Promise<any> {
     if (!this.ws || this.ws.readyState !== WebSocket.OPEN) {
       return Promise.reject(new Error('WebSocket not connected'));
     }
@@ -522,8 +935,11 @@ export class P2PWebAppClient {
         params,
         isresponse: false,
       };
+      if (peer) {
+        msg.peer = peer;
+      }
 
-      this.ws!.send(JSON.stringify(msg));
+      this.ws!.send(body ? encodeFrame(msg, body) : JSON.stringify(msg));
     });
   }
 }
@@ -7,6 +7,7 @@ export interface Message {
   result?: any;
   error?: ErrorResponse;
   isresponse: boolean;
+  peer?: string; // Peer a request addresses or a server message is for, on connections with several peers
 }
 
 export interface ErrorResponse {
@@ -22,18 +23,30 @@ export interface PeerResponse {
   peerid: string;
   peerkey: string;
   version: string;
+  resumeToken?: string; // Reattaches a new connection to this peer after a disconnect
 }
 
 // Client request message types
 
 export interface ConnectOptions {
   peerKey?: string;
+  identity?: string; // Name of a server-side identity, used instead of peerKey
+  passphrase?: string; // Decrypts an encrypted identity
+  createIdentity?: boolean; // Create the identity first if it doesn't exist
+  resume?: string; // Resume token of a disconnected peer; falls back to the other options if it expired
   onClose?: () => void;
 }
 
+// Options of addPeer(): the peer to create or resume on an open connection
+export type AddPeerOptions = Omit<ConnectOptions, 'onClose'>;
+
 export interface PeerRequest {
   peerkey?: string;
+  identity?: string; // Name of a server-side identity
+  passphrase?: string; // Decrypts an encrypted identity
+  resume?: string; // Resume token of a disconnected peer
   rootDirectory?: string; // Optional CID of peer's root directory
+  binary?: boolean; // Client accepts binary frames for byte payloads
 }
 
 export interface StartRequest {
@@ -49,13 +62,72 @@ export interface SendRequest {
   protocol: string;
   data: any;
   ack: number; // -1 = no ack, >= 0 = request ack with this number
+  binary?: boolean; // data is bytes (binary frame body, or base64 in data)
+}
+
+export interface CallRequest {
+  peer: string;
+  protocol: string;
+  data: any;
+  timeout?: number; // Milliseconds to wait for the reply (default 30s)
+}
+
+export interface CallResponse {
+  data: any; // The remote peer's reply
+}
+
+export interface ResetQueueRequest {
+  peer: string;
+  protocol?: string; // Omit to reset every protocol for the peer
+}
+
+export interface SubscribeRequest extends TopicKey {
+  topic: string;
+  history?: TopicHistory;
+}
+
+// A group key for an encrypted topic: the key itself, or the ID of one stored on the peer
+export interface TopicKey {
+  key?: string;   // Base64 32-byte group key
+  keyId?: string; // ID returned by addGroupKey or setTopicKey
+}
+
+export interface AddGroupKeyRequest {
+  key?: string; // Omit to generate a key
+}
+
+export interface GroupKeyResponse {
+  keyId: string;
+  key?: string; // Base64 group key, returned by addGroupKey
 }
 
-export interface SubscribeRequest {
+export interface SetTopicKeyRequest extends TopicKey {
   topic: string;
 }
 
-export interface PublishRequest {
+export interface RemoveTopicKeyRequest {
+  topic: string;
+  keyId?: string; // Omit to remove every key
+}
+
+// Recent topic messages to replay before live ones
+export interface TopicHistory {
+  maxMessages?: number; // Most recent messages to replay
+  maxAge?: number;      // Milliseconds back from now
+}
+
+// Built-in checks for a topic's messages
+export interface TopicValidator {
+  maxSize?: number;    // Maximum message size in bytes
+  schema?: string;     // Name of a JSON Schema in the [p2p.topicSchemas] config
+  required?: string[]; // Fields every message must have (dotted paths allowed)
+}
+
+export interface SetTopicValidatorRequest extends TopicValidator {
+  topic: string;
+}
+
+export interface PublishRequest extends TopicKey {
   topic: string;
   data: any;
 }
@@ -72,6 +144,86 @@ export interface ListPeersResponse {
   peers: string[];
 }
 
+export interface CreateIdentityRequest {
+  name: string;
+  peerkey?: string; // Existing key to import (empty = generate)
+  passphrase?: string; // Encrypts the key at rest
+}
+
+export interface IdentityInfo {
+  name: string;
+  peerid: string;
+  encrypted: boolean; // Needs a passphrase to select
+}
+
+export interface ListIdentitiesResponse {
+  identities: IdentityInfo[];
+}
+
+// Connectivity introspection types
+
+export interface PeerInfoRequest {
+  peer: string;
+  ping?: boolean; // Measure the round trip time with the libp2p ping protocol
+}
+
+export interface ConnectionInfo {
+  addr: string;                       // Remote multiaddr
+  direction: 'inbound' | 'outbound';
+  relayed: boolean;                   // Through a circuit relay instead of direct
+  limited?: boolean;                  // Limited in time or bytes by the relay
+  opened: number;                     // Unix milliseconds
+  streams: number;                    // Open streams
+}
+
+export interface PeerInfo {
+  peerid: string;
+  connected: boolean;
+  connections: ConnectionInfo[];
+  addrs: string[];         // Known addresses of the peer
+  protocols: string[];     // Protocols the peer supports
+  agentVersion?: string;
+  latency?: number;        // Smoothed latency in milliseconds, when known
+  rtt?: number;            // Ping round trip time in milliseconds
+  pingError?: string;      // Why the requested ping failed
+}
+
+export type Reachability = 'public' | 'private' | 'unknown'; // Reported by AutoNAT
+
+export interface Connectivity {
+  reachability: Reachability;
+  addrs: string[];   // This peer's own addresses
+  peers: PeerInfo[]; // Connected peers
+}
+
+// Connectivity changes pushed by the server (see onConnectivity())
+export type ConnectivityEvent =
+  | { type: 'peerConnection'; peer: string; connected: boolean; limited?: boolean }
+  | { type: 'reachability'; reachability: Reachability }
+  | { type: 'addresses'; addrs: string[]; relayed: boolean };
+
+// Blocklist types
+
+export interface BlockPeerRequest {
+  peer: string;
+  reason?: string;
+}
+
+export interface UnblockPeerRequest {
+  peer: string;
+}
+
+export interface BlockedPeer {
+  peerid: string;
+  reason?: string;
+  blockedAt: number; // Unix milliseconds
+}
+
+export interface Blocklist {
+  blocked: BlockedPeer[];
+  allowed: string[]; // Non-empty = only these peers may connect
+}
+
 // File operation types
 
 export interface FileEntry {
@@ -109,12 +261,31 @@ export interface PeerDataRequest {
   peer: string;
   protocol: string;
   data: any;
+  binary?: boolean; // data is bytes (binary frame body, or base64 in data)
+  metadata?: MessageMetadata;
+}
+
+// How a peerData or topicData message arrived (times are Unix milliseconds)
+export interface MessageMetadata {
+  id: string;            // pubsub message ID (base64url), or the peer message ID
+  seqno?: string;        // pubsub sequence number, or the per-(peer, protocol) sequence number (decimal string: pubsub seqnos exceed 2^53)
+  receivedFrom?: string; // topicData only: peer that relayed the message
+  sentAt?: number;       // peerData only: when the sender queued the message
+  receivedAt: number;    // When the receiving peer got the message
+  history?: boolean;     // topicData only: replayed from topic history
+}
+
+export interface PeerCallRequest {
+  peer: string;
+  protocol: string;
+  data: any; // Answer by responding to this request
 }
 
 export interface TopicDataRequest {
   topic: string;
-  peerid: string;
+  peerid: string; // Author of the message
   data: any;
+  metadata?: MessageMetadata;
 }
 
 export interface PeerChangeRequest {
@@ -127,6 +298,28 @@ export interface AckRequest {
   ack: number;
 }
 
+export interface PeerConnectionRequest {
+  peer: string;      // Peer added with addPeers()
+  connected: boolean;
+  limited?: boolean; // Connected only through a limited relay connection
+}
+
+export interface ReachabilityRequest {
+  reachability: Reachability;
+}
+
+export interface AddressesRequest {
+  addrs: string[];
+  relayed: boolean; // Has a relay reservation (a /p2p-circuit address)
+}
+
+export interface SendFailedRequest {
+  peer: string; // Unreachable target peer
+  protocol: string;
+  acks: number[]; // Ack numbers of the abandoned messages
+  reason: string; // Last delivery error
+}
+
 export interface PeerFilesRequest {
   peerid: string; // Target peer whose files were listed
   cid: string; // Root directory CID
@@ -137,14 +330,18 @@ export interface GotFileRequest {
   cid: string; // Requested CID
   success: boolean; // Whether retrieval was successful
   content: any; // File content or error info
+  binary?: boolean; // content.content is bytes (binary frame body, or base64)
 }
 
 // Callback types
 
 // Callbacks can be sync or async for flexibility
-export type ProtocolDataCallback = (peer: string, data: any) => void | Promise<void>;
-export type TopicDataCallback = (peerID: string, data: any) => void | Promise<void>;
+export type ProtocolDataCallback = (peer: string, data: any, metadata?: MessageMetadata) => void | Promise<void>;
+export type TopicDataCallback = (peerID: string, data: any, metadata?: MessageMetadata) => void | Promise<void>;
 export type PeerChangeCallback = (peerID: string, joined: boolean) => void | Promise<void>;
+export type ProtocolCallCallback = (peer: string, data: any) => any | Promise<any>; // Return value is the reply
+export type ConnectivityCallback = (event: ConnectivityEvent) => void | Promise<void>;
+export type SendFailedCallback = (peer: string, protocol: string, reason: string) => void | Promise<void>;
 
 // File content types
 export type FileContent = FileContentFile | FileContentDirectory;
@@ -152,7 +349,7 @@ export type FileContent = FileContentFile | FileContentDirectory;
 export interface FileContentFile {
   type: 'file';
   mimeType: string;
-  content: string; // base64-encoded
+  content: Uint8Array; // Raw file bytes
 }
 
 export interface FileContentDirectory {